@@ -0,0 +1,280 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package alert contains alert messages and alerters that deliver them to notification services.
+package alert
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+import (
+	"faucet"
+)
+
+// Alert kinds.
+const (
+	KindBalance          = "balance"
+	KindBalanceRecovered = "balance-recovered"
+	KindDB               = "db"
+	KindRate             = "rate"
+	KindRateRecovered    = "rate-recovered"
+	KindSend             = "send"
+	KindToken            = "token"
+	KindTx               = "tx"
+	KindWallet           = "wallet"
+)
+
+// DebounceConfig sets quiet periods of alert kinds. After an alert is sent, further alerts of its kind are ignored
+// until there is a full period without them. Rate alerts use rate limit period.
+// Kinds with zero period and other kinds are not debounced.
+type DebounceConfig struct {
+	DB, Send, Token, Wallet time.Duration
+}
+
+// Alert is a notification about an important event.
+type Alert struct {
+	// Kind is one of Kind constants.
+	Kind string
+
+	// Time when the alert was sent.
+	Time time.Time
+
+	// Amount is the balance for KindBalance and KindBalanceRecovered,
+	// or total giveaway amount during rate limit period for KindRate and KindRateRecovered.
+	Amount faucet.Amount
+
+	// Period is rate limit period for KindRate and KindRateRecovered, or counting period for KindToken.
+	Period time.Duration
+
+	// Count is the number of consecutive failures for KindSend or rejected claims for KindToken.
+	Count int
+
+	// Err is the last error for KindDB, KindSend and KindWallet.
+	Err string
+
+	// TX is cryptocurrency transaction identifier for KindTx.
+	TX string
+
+	// State is transaction state for KindTx.
+	State faucet.TxState
+
+	// Age is time since the transaction was sent for KindTx, or since the wallet became unreachable for KindWallet.
+	Age time.Duration
+}
+
+// Text returns human-readable description of the alert.
+func (self *Alert) Text() string {
+	switch self.Kind {
+	case KindBalance:
+		return fmt.Sprintf("Faucet balance is low: %v.", self.Amount)
+	case KindBalanceRecovered:
+		return fmt.Sprintf("Faucet balance is no longer low: %v.", self.Amount)
+	case KindDB:
+		return fmt.Sprintf("Faucet failed to write claim log: %v.", self.Err)
+	case KindRate:
+		return fmt.Sprintf("Faucet giveaway rate is too high: %v in %v.", self.Amount, self.Period)
+	case KindRateRecovered:
+		return fmt.Sprintf("Faucet giveaway rate is normal again: %v in %v.", self.Amount, self.Period)
+	case KindSend:
+		return fmt.Sprintf("Faucet failed to send coins %v times in a row: %v.", self.Count, self.Err)
+	case KindToken:
+		return fmt.Sprintf("Faucet rejected %v claims with invalid token or solution in %v.", self.Count, self.Period)
+	case KindWallet:
+		return fmt.Sprintf("Faucet wallet is unreachable for %v: %v.", self.Age, self.Err)
+	case KindTx:
+		switch self.State {
+		case faucet.TxConflicted:
+			return fmt.Sprintf("Transaction %v was double-spent %v after sending.", self.TX, self.Age)
+		case faucet.TxAbandoned:
+			return fmt.Sprintf("Transaction %v was abandoned %v after sending.", self.TX, self.Age)
+		}
+		return fmt.Sprintf("Transaction %v is not confirmed %v after sending.", self.TX, self.Age)
+	}
+	return "Faucet alert: " + self.Kind
+}
+
+// period returns debounce period of the alert.
+func (self *DebounceConfig) period(a *Alert) time.Duration {
+	switch a.Kind {
+	case KindRate:
+		return a.Period
+	case KindDB:
+		return self.DB
+	case KindSend:
+		return self.Send
+	case KindToken:
+		return self.Token
+	case KindWallet:
+		return self.Wallet
+	}
+	return 0
+}
+
+// Debounce suppresses repeated alerts of the same kind according to Periods.
+type Debounce struct {
+	Periods DebounceConfig
+	m       sync.Mutex
+	until   map[string]time.Time
+}
+
+// Suppress returns whether the alert should be ignored because an alert of its kind was sent recently.
+// Ignored alerts extend the quiet period.
+func (self *Debounce) Suppress(a *Alert) bool {
+	p := self.Periods.period(a)
+	if p <= 0 {
+		return false
+	}
+	self.m.Lock()
+	defer self.m.Unlock()
+	ct := time.Now()
+	nt := ct.Add(p)
+	u := self.until[a.Kind]
+	if !ct.Before(u) {
+		return false
+	}
+	if nt.After(u) {
+		self.until[a.Kind] = nt
+	}
+	return true
+}
+
+// Sent starts the quiet period of the kind of the alert that was sent successfully.
+func (self *Debounce) Sent(a *Alert) {
+	p := self.Periods.period(a)
+	if p <= 0 {
+		return
+	}
+	self.m.Lock()
+	defer self.m.Unlock()
+	if self.until == nil {
+		self.until = make(map[string]time.Time)
+	}
+	self.until[a.Kind] = time.Now().Add(p)
+}
+
+// errText returns the message of the error, or empty string if it is nil.
+func errText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// Sender delivers alerts to a notification service.
+type Sender interface {
+	Send(a *Alert) error
+}
+
+// SenderAlerter implements Alerter interface by delivering alerts with a Sender.
+// Alerts are debounced, failures are logged.
+type SenderAlerter struct {
+	d    Debounce
+	name string
+	s    Sender
+}
+
+func (self *SenderAlerter) send(a *Alert) {
+	if self.d.Suppress(a) {
+		return
+	}
+	a.Time = time.Now()
+	err := self.s.Send(a)
+	if err != nil {
+		log.Println("failed to send", a.Kind, "alert to", self.name, err)
+		return
+	}
+	self.d.Sent(a)
+}
+
+func (self *SenderAlerter) BalanceAlert(balance faucet.Amount) {
+	self.send(&Alert{Kind: KindBalance, Amount: balance})
+}
+
+func (self *SenderAlerter) BalanceRecovered(balance faucet.Amount) {
+	self.send(&Alert{Kind: KindBalanceRecovered, Amount: balance})
+}
+
+func (self *SenderAlerter) DBAlert(err error) { self.send(&Alert{Kind: KindDB, Err: errText(err)}) }
+
+func (self *SenderAlerter) RateAlert(amount faucet.Amount, period time.Duration) {
+	self.send(&Alert{Kind: KindRate, Amount: amount, Period: period})
+}
+
+func (self *SenderAlerter) RateRecovered(amount faucet.Amount, period time.Duration) {
+	self.send(&Alert{Kind: KindRateRecovered, Amount: amount, Period: period})
+}
+
+func (self *SenderAlerter) SendAlert(n int, err error) {
+	self.send(&Alert{Kind: KindSend, Count: n, Err: errText(err)})
+}
+
+func (self *SenderAlerter) TokenAlert(n int, period time.Duration) {
+	self.send(&Alert{Kind: KindToken, Count: n, Period: period})
+}
+
+func (self *SenderAlerter) TxAlert(tx string, state faucet.TxState, sent time.Time) {
+	self.send(&Alert{Kind: KindTx, TX: tx, State: state, Age: time.Since(sent).Round(time.Second)})
+}
+
+func (self *SenderAlerter) WalletAlert(since time.Time, err error) {
+	self.send(&Alert{Kind: KindWallet, Age: time.Since(since).Round(time.Second), Err: errText(err)})
+}
+
+// NewSenderAlerter creates an alerter that delivers alerts with the sender and debounces them according to dc.
+// Name identifies the sender in log messages.
+func NewSenderAlerter(name string, s Sender, dc *DebounceConfig) *SenderAlerter {
+	return &SenderAlerter{d: Debounce{Periods: *dc}, name: name, s: s}
+}
+
+// Multi implements Alerter interface by sending each alert with all alerters concurrently.
+// Alert methods return when all alerters have finished.
+type Multi []faucet.Alerter
+
+func (self Multi) each(f func(al faucet.Alerter)) {
+	var wg sync.WaitGroup
+	wg.Add(len(self))
+	for _, al := range self {
+		go func(al faucet.Alerter) {
+			defer wg.Done()
+			f(al)
+		}(al)
+	}
+	wg.Wait()
+}
+
+func (self Multi) BalanceAlert(balance faucet.Amount) {
+	self.each(func(al faucet.Alerter) { al.BalanceAlert(balance) })
+}
+
+func (self Multi) BalanceRecovered(balance faucet.Amount) {
+	self.each(func(al faucet.Alerter) { al.BalanceRecovered(balance) })
+}
+
+func (self Multi) DBAlert(err error) { self.each(func(al faucet.Alerter) { al.DBAlert(err) }) }
+
+func (self Multi) RateAlert(amount faucet.Amount, period time.Duration) {
+	self.each(func(al faucet.Alerter) { al.RateAlert(amount, period) })
+}
+
+func (self Multi) RateRecovered(amount faucet.Amount, period time.Duration) {
+	self.each(func(al faucet.Alerter) { al.RateRecovered(amount, period) })
+}
+
+func (self Multi) SendAlert(n int, err error) {
+	self.each(func(al faucet.Alerter) { al.SendAlert(n, err) })
+}
+
+func (self Multi) TokenAlert(n int, period time.Duration) {
+	self.each(func(al faucet.Alerter) { al.TokenAlert(n, period) })
+}
+
+func (self Multi) TxAlert(tx string, state faucet.TxState, sent time.Time) {
+	self.each(func(al faucet.Alerter) { al.TxAlert(tx, state, sent) })
+}
+
+func (self Multi) WalletAlert(since time.Time, err error) {
+	self.each(func(al faucet.Alerter) { al.WalletAlert(since, err) })
+}
@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package alert_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	"faucet"
+	"faucet/alert"
+)
+
+// senderMock records alerts and fails if err is set.
+type senderMock struct {
+	m   sync.Mutex
+	as  []alert.Alert
+	err error
+}
+
+func (self *senderMock) Send(a *alert.Alert) error {
+	self.m.Lock()
+	defer self.m.Unlock()
+	self.as = append(self.as, *a)
+	return self.err
+}
+
+func TestMulti(t *testing.T) {
+	s1, s2 := new(senderMock), &senderMock{err: errors.New("unavailable")}
+	dc := new(alert.DebounceConfig)
+	m := alert.Multi{alert.NewSenderAlerter("s1", s1, dc), alert.NewSenderAlerter("s2", s2, dc)}
+	m.BalanceAlert(faucet.Coin)
+	m.RateAlert(100*faucet.Coin, time.Hour)
+	m.TxAlert("tx", faucet.TxConflicted, time.Now().Add(-time.Minute))
+	for _, s := range []*senderMock{s1, s2} {
+		if len(s.as) != 3 {
+			t.Fatal("sent", len(s.as), "alerts, want 3")
+		}
+		for i, k := range []string{alert.KindBalance, alert.KindRate, alert.KindTx} {
+			if a := &s.as[i]; a.Kind != k || a.Time.IsZero() {
+				t.Errorf("alert %v: %+v, want kind %v", i, a, k)
+			}
+		}
+		if a := s.as[2]; a.TX != "tx" || a.State != faucet.TxConflicted || a.Age != time.Minute {
+			t.Errorf("transaction alert %+v", a)
+		}
+	}
+	for i, want := range []string{
+		"Faucet balance is low: 1.",
+		"Faucet giveaway rate is too high: 100 in 1h0m0s.",
+		"Transaction tx was double-spent 1m0s after sending.",
+	} {
+		if txt := s1.as[i].Text(); txt != want {
+			t.Errorf("alert %v text %q, want %q", i, txt, want)
+		}
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	dc := &alert.DebounceConfig{Send: 100 * time.Millisecond}
+	s1, s2 := new(senderMock), &senderMock{err: errors.New("unavailable")}
+	a1, a2 := alert.NewSenderAlerter("s1", s1, dc), alert.NewSenderAlerter("s2", s2, dc)
+	err := errors.New("connection refused")
+	for i := 0; i < 3; i++ {
+		a1.SendAlert(3, err)
+		a2.SendAlert(3, err)
+		// Transaction alerts are not debounced.
+		a1.TxAlert("tx", faucet.TxAbandoned, time.Now())
+		// Token alerts have zero period.
+		a1.TokenAlert(2, time.Minute)
+	}
+	// Failed alerts are retried with the next one.
+	if len(s1.as) != 7 || len(s2.as) != 3 {
+		t.Fatal("sent", len(s1.as), "and", len(s2.as), "alerts, want 7 and 3")
+	}
+	if a := s1.as[0]; a.Text() != `Faucet failed to send coins 3 times in a row: connection refused.` {
+		t.Errorf("send alert text %q", a.Text())
+	}
+	time.Sleep(150 * time.Millisecond)
+	a1.SendAlert(3, err)
+	if len(s1.as) != 8 {
+		t.Error("alert was not sent after quiet period")
+	}
+}
@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package faucet
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Amount is an amount of coins in koinu, the smallest unit of Dogecoin.
+// It is formatted and parsed as decimal number of coins.
+type Amount int64
+
+// Coin is the number of koinu in 1 coin.
+const Coin Amount = 100000000
+
+// AmountDecimals is the number of decimal places in amounts expressed in coins.
+const AmountDecimals = 8
+
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// ParseAmount parses decimal number of coins.
+// It does not accept exponent and more than AmountDecimals decimal places.
+func ParseAmount(s string) (Amount, error) {
+	neg := false
+	ds := s
+	if len(ds) > 0 && (ds[0] == '-' || ds[0] == '+') {
+		neg = ds[0] == '-'
+		ds = ds[1:]
+	}
+	ip, fp := ds, ""
+	if i := strings.IndexByte(ds, '.'); i >= 0 {
+		ip, fp = ds[:i], ds[i+1:]
+	}
+	if len(ip) == 0 && len(fp) == 0 || len(fp) > AmountDecimals {
+		return 0, fmt.Errorf("%w %q", ErrInvalidAmount, s)
+	}
+	var a uint64
+	for _, c := range ip + fp + strings.Repeat("0", AmountDecimals-len(fp)) {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("%w %q", ErrInvalidAmount, s)
+		}
+		if a > (math.MaxInt64-9)/10 {
+			return 0, fmt.Errorf("%w %q: out of range", ErrInvalidAmount, s)
+		}
+		a = a*10 + uint64(c-'0')
+	}
+	if neg {
+		return -Amount(a), nil
+	}
+	return Amount(a), nil
+}
+
+// Coins returns approximate amount in coins as a floating point number.
+func (self Amount) Coins() float64 { return float64(self) / float64(Coin) }
+
+// String formats the amount as decimal number of coins without trailing zeros.
+func (self Amount) String() string {
+	var sb strings.Builder
+	u := uint64(self)
+	if self < 0 {
+		sb.WriteByte('-')
+		u = -u
+	}
+	sb.WriteString(strconv.FormatUint(u/uint64(Coin), 10))
+	f := u % uint64(Coin)
+	if f != 0 {
+		fs := strconv.FormatUint(f+uint64(Coin), 10)[1:]
+		sb.WriteByte('.')
+		sb.WriteString(strings.TrimRight(fs, "0"))
+	}
+	return sb.String()
+}
+
+func (self Amount) MarshalJSON() ([]byte, error) { return []byte(self.String()), nil }
+
+func (self *Amount) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if s == "null" {
+		return nil
+	}
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	a, err := ParseAmount(s)
+	if err != nil {
+		return err
+	}
+	*self = a
+	return nil
+}
+
+func (self Amount) MarshalYAML() (interface{}, error) {
+	tag := "!!int"
+	if self%Coin != 0 {
+		tag = "!!float"
+	}
+	return &yaml.Node{
+		Kind:  yaml.ScalarNode,
+		Tag:   tag,
+		Value: self.String(),
+	}, nil
+}
+
+func (self *Amount) UnmarshalYAML(value *yaml.Node) error {
+	s := new(string)
+	err := value.Decode(s)
+	if err != nil {
+		return err
+	}
+	a, err := ParseAmount(*s)
+	if err != nil {
+		return err
+	}
+	*self = a
+	return nil
+}
+
+// Scan implements sql.Scanner. Amounts are stored in SQL databases as integer numbers of koinu.
+// Floating point numbers are rejected, since they would be numbers of coins in databases that are not migrated.
+func (self *Amount) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case int64:
+		*self = Amount(v)
+	case float64:
+		// Claim amounts were stored as REAL numbers of coins before schema migration.
+		return fmt.Errorf("cannot scan floating point number %v into amount, migrate the database", v)
+	case []byte:
+		return self.Scan(string(v))
+	case string:
+		a, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		*self = Amount(a)
+	default:
+		return fmt.Errorf("cannot scan %T into amount", src)
+	}
+	return nil
+}
+
+// Value implements driver.Valuer.
+func (self Amount) Value() (driver.Value, error) { return int64(self), nil }
@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package faucet_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+import (
+	"faucet"
+)
+
+func TestParseAmount(t *testing.T) {
+	for _, c := range [...]struct {
+		s  string
+		a  faucet.Amount
+		ok bool
+	}{
+		{"0", 0, true},
+		{"1", faucet.Coin, true},
+		{"+1", faucet.Coin, true},
+		{"-1", -faucet.Coin, true},
+		{"0.1", faucet.Coin / 10, true},
+		{".1", faucet.Coin / 10, true},
+		{"1.", faucet.Coin, true},
+		{"100.00000001", 100*faucet.Coin + 1, true},
+		{"92233720368.54775807", 0, false},
+		{"1.000000001", 0, false},
+		{"1e3", 0, false},
+		{"", 0, false},
+		{".", 0, false},
+		{"-", 0, false},
+		{"1.2.3", 0, false},
+		{" 1", 0, false},
+	} {
+		a, err := faucet.ParseAmount(c.s)
+		if c.ok && err != nil {
+			t.Errorf("%q: %v", c.s, err)
+		} else if !c.ok && err == nil {
+			t.Errorf("%q: got %v, want error", c.s, a)
+		} else if a != c.a {
+			t.Errorf("%q: got %v, want %v", c.s, int64(a), int64(c.a))
+		}
+	}
+}
+
+func TestAmountString(t *testing.T) {
+	for _, c := range [...]struct {
+		a faucet.Amount
+		s string
+	}{
+		{0, "0"},
+		{1, "0.00000001"},
+		{faucet.Coin, "1"},
+		{-faucet.Coin / 2, "-0.5"},
+		{123456789012, "1234.56789012"},
+		{1000 * faucet.Coin, "1000"},
+	} {
+		s := c.a.String()
+		if s != c.s {
+			t.Errorf("%v: got %q, want %q", int64(c.a), s, c.s)
+		}
+		a, err := faucet.ParseAmount(s)
+		if err != nil || a != c.a {
+			t.Errorf("%q: parsed %v %v, want %v", s, int64(a), err, int64(c.a))
+		}
+	}
+}
+
+func TestAmountEncoding(t *testing.T) {
+	v := struct {
+		A, B faucet.Amount
+	}{faucet.Coin / 10 * 3, 20 * faucet.Coin}
+	j, err := json.Marshal(&v)
+	if err != nil {
+		t.Fatal("JSON encoding failed:", err)
+	}
+	if string(j) != `{"A":0.3,"B":20}` {
+		t.Error("JSON: got", string(j))
+	}
+	v.A, v.B = 0, 0
+	err = json.Unmarshal([]byte(`{"A":0.30000000,"B":"20"}`), &v)
+	if err != nil {
+		t.Fatal("JSON decoding failed:", err)
+	}
+	if v.A != faucet.Coin/10*3 || v.B != 20*faucet.Coin {
+		t.Error("JSON: decoded", v.A, v.B)
+	}
+	y, err := yaml.Marshal(&v)
+	if err != nil {
+		t.Fatal("YAML encoding failed:", err)
+	}
+	if string(y) != "a: 0.3\nb: 20\n" {
+		t.Errorf("YAML: got %q", y)
+	}
+	v.A, v.B = 0, 0
+	err = yaml.Unmarshal([]byte("a: 0.1\nb: 7\n"), &v)
+	if err != nil {
+		t.Fatal("YAML decoding failed:", err)
+	}
+	if v.A != faucet.Coin/10 || v.B != 7*faucet.Coin {
+		t.Error("YAML: decoded", v.A, v.B)
+	}
+}
+
+func TestAmountScan(t *testing.T) {
+	for _, src := range []interface{}{int64(150000000), []byte("150000000"), "150000000"} {
+		var a faucet.Amount
+		if err := a.Scan(src); err != nil || a != faucet.Coin*3/2 {
+			t.Errorf("Scan(%#v) returned %v %v", src, a, err)
+		}
+	}
+	// REAL values of unmigrated databases are numbers of coins, not koinu.
+	for _, src := range []interface{}{1.5, "1.5", nil} {
+		var a faucet.Amount
+		if err := a.Scan(src); err == nil {
+			t.Errorf("Scan(%#v) returned %v", src, a)
+		}
+	}
+}
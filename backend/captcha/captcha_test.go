@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package captcha_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+import (
+	"faucet"
+	"faucet/captcha"
+)
+
+func TestSiteVerify(t *testing.T) {
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Error("unexpected method", r.Method)
+		}
+		var res string
+		switch {
+		case r.PostFormValue("secret") != "s":
+			res = `{"success":false,"error-codes":["invalid-input-secret"]}`
+		case r.PostFormValue("response") == "bad":
+			res = `{"success":false,"error-codes":["invalid-input-response"]}`
+		case r.PostFormValue("response") == "garbage":
+			res = `<html>`
+		case r.PostFormValue("remoteip") != "192.0.2.1":
+			t.Errorf("unexpected remoteip %q", r.PostFormValue("remoteip"))
+			res = `{"success":false}`
+		default:
+			res = `{"success":true,"hostname":"localhost"}`
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, res)
+	}))
+	defer hs.Close()
+	cv, err := captcha.NewSiteVerify(&captcha.CaptchaConfig{
+		Provider: "hcaptcha",
+		Endpoint: hs.URL,
+		Secret:   "s",
+	})
+	if err != nil {
+		t.Fatal("NewSiteVerify failed:", err)
+	}
+	ctx := context.Background()
+	err = cv.Verify(ctx, "good", "192.0.2.1")
+	if err != nil {
+		t.Error("Verify failed:", err)
+	}
+	err = cv.Verify(ctx, "bad", "192.0.2.1")
+	if err != faucet.ErrCaptchaFailed {
+		t.Error("invalid response: unexpected error", err)
+	}
+	err = cv.Verify(ctx, "garbage", "192.0.2.1")
+	if err == nil || err == faucet.ErrCaptchaFailed {
+		t.Error("invalid reply: unexpected error", err)
+	}
+	cv, err = captcha.NewSiteVerify(&captcha.CaptchaConfig{
+		Endpoint: hs.URL,
+		Secret:   "x",
+	})
+	if err != nil {
+		t.Fatal("NewSiteVerify failed:", err)
+	}
+	err = cv.Verify(ctx, "good", "192.0.2.1")
+	if err == nil || err == faucet.ErrCaptchaFailed {
+		t.Error("invalid secret: unexpected error", err)
+	}
+	_, err = captcha.NewSiteVerify(&captcha.CaptchaConfig{Provider: "x", Secret: "s"})
+	if err == nil {
+		t.Error("NewSiteVerify accepted unknown provider")
+	}
+}
@@ -0,0 +1,7 @@
+// +build mysql
+
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import _ "github.com/go-sql-driver/mysql"
@@ -0,0 +1,7 @@
+// +build postgres
+
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import _ "github.com/lib/pq"
@@ -7,44 +7,75 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"reflect"
+	"syscall"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 import (
 	"faucet"
+	"faucet/alert"
+	"faucet/captcha"
 	"faucet/core"
 	"faucet/exalert"
+	"faucet/metrics"
 	"faucet/platform"
+	"faucet/risk"
 	"faucet/rpc"
 	"faucet/server"
+	"faucet/smtpalert"
 	"faucet/sqldb"
+	"faucet/webhook"
 )
 
 type logCfg struct{ Date, Time, Microseconds, UTC bool }
 
 type config struct {
-	Faucet core.FaucetConfig       `yaml:",inline"`
-	Alerts exalert.ExAlerterConfig `yaml:",inline"`
-	Server server.ServerConfig     `yaml:",inline"`
-	DB     sqldb.DBConfig
-	RPC    rpc.RPCConfig
-	Log    logCfg
+	Faucet   core.FaucetConfig       `yaml:",inline"`
+	Alerts   exalert.ExAlerterConfig `yaml:",inline"`
+	Server   server.ServerConfig     `yaml:",inline"`
+	Captcha  captcha.CaptchaConfig
+	DB       sqldb.DBConfig
+	Risk     risk.Config
+	RPC      rpc.RPCConfig
+	Log      logCfg
+	Webhooks []webhook.WebhookConfig
+	SMTP     smtpalert.SMTPConfig
 }
 
 var defCfg = config{
 	Faucet: core.FaucetConfig{
-		Fee:       1,
-		MinAmount: 2,
+		Fee:       faucet.Coin,
+		MinAmount: 2 * faucet.Coin,
+		Alerts: core.AlertsConfig{
+			Debounce: alert.DebounceConfig{
+				DB:     time.Hour,
+				Send:   time.Hour,
+				Token:  time.Hour,
+				Wallet: time.Hour,
+			},
+		},
 	},
 	Server: server.ServerConfig{
 		APIPrefix: "/api",
+		Admin: server.AdminConfig{
+			Prefix: "/admin",
+		},
+		Events: server.EventsConfig{
+			MaxConns:  4,
+			Heartbeat: 30 * time.Second,
+		},
 	},
 	RPC: rpc.RPCConfig{
-		URL: "http://localhost:44555",
+		RPCEndpoint: rpc.RPCEndpoint{
+			URL: "http://localhost:44555",
+		},
 	},
 	Log: logCfg{
 		Date: true,
@@ -52,13 +83,15 @@ var defCfg = config{
 	},
 }
 
-func shutdownOnSignal(s *server.Server) {
+// handleSignals calls reload on SIGHUP and stops the server on interrupt or SIGTERM.
+func handleSignals(s *server.Server, reload func()) {
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, os.Interrupt, syscall.SIGHUP, syscall.SIGTERM)
 	for s := range c {
-		if s == os.Interrupt {
+		if s == os.Interrupt || s == syscall.SIGTERM {
 			break
 		}
+		reload()
 	}
 	signal.Stop(c)
 	s.Stop()
@@ -85,9 +118,12 @@ func usage() {
 	fmt.Println("usage:")
 	fmt.Println(pn, "config create configout.yaml")
 	fmt.Println(pn, "config dump config.yaml")
+	fmt.Println(pn, "config intervals config.yaml")
 	fmt.Println(pn, "config process config.yaml configout.yaml")
 	fmt.Println(pn, "db create config.yaml")
+	fmt.Println(pn, "db migrate config.yaml")
 	fmt.Println(pn, "db sql driver_name")
+	fmt.Println(pn, "db version config.yaml")
 	fmt.Println(pn, "serve config.yaml")
 	os.Exit(1)
 }
@@ -131,6 +167,16 @@ func cmdConfig(args []string) error {
 		if err != nil {
 			return err
 		}
+	case "intervals":
+		if len(args) != 2 {
+			usage()
+		}
+		cfg := defCfg
+		err := loadYAML(args[1], &cfg)
+		if err != nil {
+			return err
+		}
+		writeIntervals(os.Stdout, &cfg.Faucet)
 	case "process":
 		if len(args) != 3 {
 			usage()
@@ -150,8 +196,59 @@ func cmdConfig(args []string) error {
 	return nil
 }
 
+// writeIntervals writes Markdown table of claim intervals enforced for client subnets.
+func writeIntervals(w io.Writer, cfg *core.FaucetConfig) {
+	rl := core.RateLimits{
+		IPClaimInterval: cfg.IPClaimInterval,
+		IPv4Prefix:      cfg.Subnets.IPv4Prefix,
+		IPv6Prefix:      cfg.Subnets.IPv6Prefix,
+		IntervalDivisor: cfg.Subnets.Divisor,
+		IntervalDepth:   cfg.Subnets.Depth,
+	}
+	p4, p6 := cfg.Subnets.IPv4Prefix, cfg.Subnets.IPv6Prefix
+	if p4 == 0 {
+		p4 = core.DefIPv4Prefix
+	}
+	if p6 == 0 {
+		p6 = core.DefIPv6Prefix
+	}
+	ds4 := rl.Intervals(net.IPv4(192, 0, 2, 1))
+	ds6 := rl.Intervals(net.ParseIP("2001:db8::1"))
+	fmt.Fprintln(w, "| Interval | IPv4 subnet | IPv6 subnet |")
+	fmt.Fprintln(w, "| -------- | ----------- | ----------- |")
+	for i := 0; i < len(ds4) || i < len(ds6); i++ {
+		var d time.Duration
+		s4, s6 := "-", "-"
+		if i < len(ds4) && p4-8*i >= 0 {
+			d = ds4[i]
+			s4 = fmt.Sprint("/", p4-8*i)
+		}
+		if i < len(ds6) {
+			d = ds6[i]
+			s6 = fmt.Sprint("/", p6-8*i)
+		}
+		if d == 0 {
+			break
+		}
+		fmt.Fprintf(w, "| %v | %v | %v |\n", d, s4, s6)
+	}
+}
+
 var sqlStmtEnd = []byte{';', '\n'}
 
+// openDB opens database configured in the given configuration file.
+func openDB(fn string) (*sqldb.DB, error) {
+	cfg := defCfg
+	err := loadYAML(fn, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.DB.Configured() {
+		return nil, fmt.Errorf("database is not configured")
+	}
+	return sqldb.NewDB(&cfg.DB)
+}
+
 func cmdDB(args []string) error {
 	if len(args) < 1 {
 		usage()
@@ -161,23 +258,49 @@ func cmdDB(args []string) error {
 		if len(args) != 2 {
 			usage()
 		}
-		cfg := defCfg
-		err := loadYAML(args[1], &cfg)
+		db, err := openDB(args[1])
 		if err != nil {
 			return err
 		}
-		if !cfg.DB.Configured() {
-			return fmt.Errorf("database is not configured")
+		defer func() {
+			if db != nil {
+				db.Close()
+			}
+		}()
+		err = db.CreateTables()
+		if err != nil {
+			return err
 		}
-		db, err := sqldb.NewDB(&cfg.DB)
+		err = db.Close()
+		db = nil
 		if err != nil {
 			return err
 		}
-		err = db.CreateTables()
+	case "convert", "migrate":
+		// convert is the command that converted claim amounts before schema versioning; migration does that now.
+		if len(args) != 2 {
+			usage()
+		}
+		db, err := openDB(args[1])
 		if err != nil {
 			return err
 		}
+		defer func() {
+			if db != nil {
+				db.Close()
+			}
+		}()
+		v, err := db.Migrate()
+		if err != nil {
+			return err
+		}
+		if v == sqldb.SchemaVersion {
+			fmt.Println("database schema version", v, "is up to date")
+		} else {
+			fmt.Println("migrated database schema from version", v, "to", sqldb.SchemaVersion)
+		}
 		err = db.Close()
+		db = nil
 		if err != nil {
 			return err
 		}
@@ -185,7 +308,7 @@ func cmdDB(args []string) error {
 		if len(args) != 2 {
 			usage()
 		}
-		sql := sqldb.CreateSQL[args[1]]
+		sql := sqldb.CreateStatements(args[1])
 		if len(sql) == 0 {
 			return fmt.Errorf("don't have table creation SQL code for driver %q", args[1])
 		}
@@ -205,43 +328,175 @@ func cmdDB(args []string) error {
 				return io.ErrShortWrite
 			}
 		}
+	case "version":
+		if len(args) != 2 {
+			usage()
+		}
+		db, err := openDB(args[1])
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if db != nil {
+				db.Close()
+			}
+		}()
+		v, err := db.Version()
+		if err != nil {
+			return err
+		}
+		fmt.Println("database schema version:", v)
+		fmt.Println("supported schema version:", sqldb.SchemaVersion)
+		err = db.Close()
+		db = nil
+		if err != nil {
+			return err
+		}
 	default:
 		usage()
 	}
 	return nil
 }
 
-func cmdServe(args []string) error {
-	if len(args) != 1 {
-		usage()
-	}
-	cfg := defCfg
-	err := loadYAML(args[0], &cfg)
+// checkDBVersion returns an error that tells what to do if the database schema is not current.
+func checkDBVersion(db *sqldb.DB, cfn string) error {
+	v, err := db.Version()
 	if err != nil {
 		return err
 	}
+	switch {
+	case v == 0:
+		return fmt.Errorf("database tables do not exist, create them with: %v db create %v", progName(), cfn)
+	case v < sqldb.SchemaVersion:
+		return fmt.Errorf("database schema version %v is outdated, upgrade it to version %v with: %v db migrate %v", v, sqldb.SchemaVersion, progName(), cfn)
+	case v > sqldb.SchemaVersion:
+		return sqldb.ErrSchemaVersion{Version: v}
+	}
+	return nil
+}
+
+// newAlerter creates configured alerters. Returns nil if alerts are not configured.
+func newAlerter(cfg *config) (faucet.Alerter, error) {
+	var als alert.Multi
+	dc := &cfg.Faucet.Alerts.Debounce
+	if cfg.Alerts.Configured() {
+		als = append(als, exalert.NewExAlerter(&cfg.Alerts, dc))
+	}
+	for i := range cfg.Webhooks {
+		w, err := webhook.NewWebhook(&cfg.Webhooks[i])
+		if err != nil {
+			return nil, err
+		}
+		als = append(als, alert.NewSenderAlerter("webhook "+cfg.Webhooks[i].URL, w, dc))
+	}
+	if cfg.SMTP.Configured() {
+		m, err := smtpalert.NewSMTP(&cfg.SMTP)
+		if err != nil {
+			return nil, err
+		}
+		als = append(als, alert.NewSenderAlerter("SMTP server "+cfg.SMTP.Server, m, dc))
+	}
+	switch len(als) {
+	case 0:
+		return nil, nil
+	case 1:
+		return als[0], nil
+	}
+	return als, nil
+}
+
+// setLogFlags sets flags of the standard logger.
+func setLogFlags(cfg *logCfg) {
 	lf := 0
-	if cfg.Log.Date {
+	if cfg.Date {
 		lf |= log.Ldate
 	}
-	if cfg.Log.Time {
+	if cfg.Time {
 		lf |= log.Ltime
 	}
-	if cfg.Log.Microseconds {
+	if cfg.Microseconds {
 		lf |= log.Lmicroseconds
 	}
-	if cfg.Log.UTC {
+	if cfg.UTC {
 		lf |= log.LUTC
 	}
 	log.SetFlags(lf)
-	var al faucet.Alerter
-	if cfg.Alerts.Configured() {
-		al = exalert.NewExAlerter(&cfg.Alerts)
+}
+
+// reloadConfig loads the configuration file again and applies settings that can be changed while the server is running.
+// Changes of settings that require restart are logged as warnings and ignored.
+// The alerter is replaced only if its configuration changed, so that suppression of repeated alerts is not reset.
+// If the new configuration is invalid, nothing is changed.
+func reloadConfig(fn string, cfg *config, al *faucet.Alerter, f *core.Faucet, bank *rpc.Failover, s *server.Server) error {
+	nc := defCfg
+	err := loadYAML(fn, &nc)
+	if err != nil {
+		return err
+	}
+	// Wallets are checked before anything is changed, since reloading the faucet cannot be undone.
+	err = nc.RPC.Check()
+	if err != nil {
+		return err
+	}
+	nal := *al
+	if !reflect.DeepEqual(cfg.Alerts, nc.Alerts) || !reflect.DeepEqual(cfg.Webhooks, nc.Webhooks) || !reflect.DeepEqual(cfg.SMTP, nc.SMTP) ||
+		cfg.Faucet.Alerts.Debounce != nc.Faucet.Alerts.Debounce {
+		nal, err = newAlerter(&nc)
+		if err != nil {
+			return err
+		}
+	}
+	err = f.Reload(&nc.Faucet, nal)
+	if err != nil {
+		return err
+	}
+	*al = nal
+	cfg.Faucet, cfg.Alerts, cfg.Webhooks, cfg.SMTP = nc.Faucet, nc.Alerts, nc.Webhooks, nc.SMTP
+	err = bank.Reload(&nc.RPC)
+	if err != nil {
+		return err
+	}
+	cfg.RPC = nc.RPC
+	s.SetAllowOrigin(nc.Server.AllowOrigin)
+	setLogFlags(&nc.Log)
+	sc := nc.Server
+	sc.AllowOrigin = cfg.Server.AllowOrigin
+	for _, c := range []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"server", cfg.Server, sc},
+		{"captcha", cfg.Captcha, nc.Captcha},
+		{"db", cfg.DB, nc.DB},
+		{"risk", cfg.Risk, nc.Risk},
+	} {
+		if !reflect.DeepEqual(c.old, c.new) {
+			log.Println("warning: change of", c.name, "configuration requires restart, old values are kept")
+		}
+	}
+	cfg.Server.AllowOrigin, cfg.Log = nc.Server.AllowOrigin, nc.Log
+	return nil
+}
+
+func cmdServe(args []string) error {
+	if len(args) != 1 {
+		usage()
+	}
+	cfg := defCfg
+	err := loadYAML(args[0], &cfg)
+	if err != nil {
+		return err
+	}
+	setLogFlags(&cfg.Log)
+	al, err := newAlerter(&cfg)
+	if err != nil {
+		return err
 	}
-	bank, err := rpc.NewRPCClient(&cfg.RPC)
+	bank, err := rpc.NewFailover(&cfg.RPC)
 	if err != nil {
 		return err
 	}
+	defer bank.Close()
 	var sdb *sqldb.DB
 	var fdb faucet.FaucetDB
 	if len(cfg.DB.Driver) > 0 {
@@ -254,15 +509,80 @@ func cmdServe(args []string) error {
 				sdb.Close()
 			}
 		}()
+		err = checkDBVersion(sdb, args[0])
+		if err != nil {
+			return err
+		}
+		if cfg.DB.Shared && cfg.Faucet.Batch.Interval >= time.Second {
+			return fmt.Errorf("sending claims in batches is not supported with shared database")
+		}
 		fdb = sdb
 	}
+	var cv faucet.CaptchaVerifier
+	if cfg.Captcha.Configured() {
+		cv, err = captcha.NewSiteVerify(&cfg.Captcha)
+		if err != nil {
+			return err
+		}
+	}
+	var rs *risk.Scorer
+	if cfg.Risk.Configured() {
+		rs, err = risk.NewScorer(&cfg.Risk)
+		if err != nil {
+			return err
+		}
+	}
 	f, err := core.NewFaucet(&cfg.Faucet, al, bank, fdb)
 	if err != nil {
 		return err
 	}
-	s := server.NewServer(&cfg.Server, f)
-	go shutdownOnSignal(s)
+	if cv != nil {
+		f.SetCaptchaVerifier(cv)
+	}
+	if rs != nil {
+		f.SetRiskScorer(rs)
+	}
+	if cfg.DB.Shared {
+		rl, err := core.NewRateLimits(&cfg.Faucet)
+		if err != nil {
+			return err
+		}
+		f.SetRateLimitStore(sqldb.NewRateLimitStore(sdb, rl))
+	}
+	s, err := server.NewServer(&cfg.Server, f)
+	if err != nil {
+		return err
+	}
+	err = server.RegisterAdmin(s, &cfg.Server.Admin, f)
+	if err != nil {
+		return err
+	}
+	server.RegisterEvents(s, &cfg.Server, f)
+	if len(cfg.Server.Metrics) > 0 {
+		reg := new(metrics.Registry)
+		f.RegisterMetrics(reg)
+		bank.RegisterMetrics(reg)
+		s.RegisterMetrics(reg)
+		s.Handle(cfg.Server.Metrics, reg)
+	}
+	go handleSignals(s, func() {
+		err := reloadConfig(args[0], &cfg, &al, f, bank, s)
+		if err != nil {
+			log.Println("failed to reload configuration:", err)
+		}
+		err = f.ReloadIPRules()
+		if err != nil {
+			log.Println("failed to reload IP rules:", err)
+		}
+		if rs != nil {
+			err = rs.Reload()
+			if err != nil {
+				log.Println("failed to reload risk lists:", err)
+			}
+		}
+	})
 	err = s.Serve()
+	f.Close()
 	if err != nil {
 		return err
 	}
@@ -18,7 +18,7 @@ import (
 
 type controlData struct {
 	AddressVersions  []uint
-	Amount           float64
+	Amount           faucet.Amount
 	Error, Msg, Wait string
 	Errors           []string
 }
@@ -115,13 +115,20 @@ func (self controlHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	v = r.FormValue("amount")
 	if len(v) > 0 {
-		a, err := strconv.ParseFloat(v, 64)
+		a, err := faucet.ParseAmount(v)
 		if err != nil {
 			if len(d.Msg) > 0 {
 				d.Msg += " "
 			}
 			d.Msg += "Invalid amount: " + err.Error() + "."
 		} else {
+			if a != self.f.amt {
+				self.f.ev.Publish(faucet.Event{
+					Kind:   faucet.EventAmount,
+					Time:   time.Now(),
+					Amount: a,
+				})
+			}
 			self.f.amt = a
 			d.Amount = a
 		}
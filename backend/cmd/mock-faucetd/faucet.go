@@ -7,27 +7,31 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/hex"
+	"net"
 	"time"
 )
 
 import (
 	"faucet"
+	"faucet/core"
 )
 
 type mockFaucet struct {
-	amt  float64
+	amt  faucet.Amount
 	avs  []uint
+	cs   []faucet.ClaimRecord
 	err  error
+	ev   core.Events
 	wait time.Time
 }
 
 func (self *mockFaucet) AddressVersions() []uint { return self.avs }
 
-func (self *mockFaucet) Amount(ctx context.Context) (float64, error) {
+func (self *mockFaucet) Amount(ctx context.Context) (faucet.Amount, error) {
 	return self.amt, self.err
 }
 
-func (self *mockFaucet) Claim(ctx context.Context, client, recipient, token string) (amount float64, tx string, err error) {
+func (self *mockFaucet) Claim(ctx context.Context, req *faucet.ClaimRequest) (amount faucet.Amount, id int64, tx string, err error) {
 	if self.err != nil {
 		err = self.err
 		return
@@ -47,19 +51,94 @@ func (self *mockFaucet) Claim(ctx context.Context, client, recipient, token stri
 	}
 	amount = self.amt
 	tx = hex.EncodeToString(btx)
+	cr := faucet.ClaimRecord{
+		ID:        int64(len(self.cs) + 1),
+		Time:      time.Now(),
+		Recipient: req.Recipient,
+		Amount:    amount,
+		TX:        btx,
+	}
+	h, _, e := net.SplitHostPort(req.Client)
+	if e == nil {
+		cr.Client = net.ParseIP(h)
+	}
+	self.cs = append(self.cs, cr)
+	id = cr.ID
+	self.ev.Publish(faucet.Event{
+		Kind:      faucet.EventClaim,
+		Time:      cr.Time,
+		Amount:    amount,
+		Recipient: core.TruncateRecipient(req.Recipient),
+		TX:        tx,
+	})
 	return
 }
 
-func (self *mockFaucet) Token(ctx context.Context, client string) (string, error) {
+func (self *mockFaucet) ClaimByID(ctx context.Context, id int64) (*faucet.ClaimRecord, error) {
+	if self.err != nil {
+		return nil, self.err
+	}
+	if id < 1 || id > int64(len(self.cs)) {
+		return nil, faucet.ErrNoClaim
+	}
+	cr := self.cs[id-1]
+	return &cr, nil
+}
+
+func (self *mockFaucet) Claims(ctx context.Context, q *faucet.ClaimQuery) ([]faucet.ClaimRecord, error) {
+	if self.err != nil {
+		return nil, self.err
+	}
+	var crs []faucet.ClaimRecord
+	for i := len(self.cs) - 1; i >= 0 && len(crs) < q.Limit; i-- {
+		cr := self.cs[i]
+		if q.Before > 0 && cr.ID >= q.Before {
+			continue
+		}
+		if len(q.Recipient) > 0 && cr.Recipient != q.Recipient {
+			continue
+		}
+		if q.Client != nil && !q.Client.Contains(cr.Client) {
+			continue
+		}
+		crs = append(crs, cr)
+	}
+	return crs, nil
+}
+
+func (self *mockFaucet) ClaimsByTX(ctx context.Context, tx string) ([]faucet.ClaimRecord, error) {
 	if self.err != nil {
-		return "", self.err
+		return nil, self.err
+	}
+	var crs []faucet.ClaimRecord
+	for _, cr := range self.cs {
+		if hex.EncodeToString(cr.TX) == tx {
+			crs = append(crs, cr)
+		}
+	}
+	if len(crs) == 0 {
+		return nil, faucet.ErrNoClaim
+	}
+	return crs, nil
+}
+
+// Schedule returns a schedule that tapers the amount when balance is below 100 times the amount.
+func (self *mockFaucet) Schedule() *faucet.AmountSchedule {
+	return &faucet.AmountSchedule{Balance: []faucet.SchedulePoint{{}, {Level: 100 * self.amt, Amount: self.amt}}}
+}
+
+func (self *mockFaucet) Subscribe() (<-chan faucet.Event, func()) { return self.ev.Subscribe() }
+
+func (self *mockFaucet) Token(ctx context.Context, client string) (string, uint, error) {
+	if self.err != nil {
+		return "", 0, self.err
 	}
 	t := make([]byte, 12)
 	_, err := rand.Read(t)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
-	return base64.RawStdEncoding.EncodeToString(t), nil
+	return base64.RawStdEncoding.EncodeToString(t), 0, nil
 }
 
 func (self *mockFaucet) WaitTime(ctx context.Context, client string) (time.Time, error) {
@@ -71,3 +150,5 @@ func (self *mockFaucet) WaitTime(ctx context.Context, client string) (time.Time,
 	}
 	return time.Time{}, nil
 }
+
+func (self *mockFaucet) Window() *faucet.PolicyWindow { return nil }
@@ -8,11 +8,13 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 import (
+	"faucet"
 	"faucet/platform"
 	"faucet/server"
 )
@@ -26,6 +28,10 @@ var defCfg = config{
 	Server: server.ServerConfig{
 		APIPrefix:   "/api",
 		AllowOrigin: "*",
+		Events: server.EventsConfig{
+			MaxConns:  4,
+			Heartbeat: 30 * time.Second,
+		},
 	},
 	ControlPage: "/mock.html",
 }
@@ -129,10 +135,14 @@ func cmdServe(args []string) error {
 		return err
 	}
 	f := &mockFaucet{
-		amt: 100,
+		amt: 100 * faucet.Coin,
 		avs: []uint{113, 196},
 	}
-	s := server.NewServer(&cfg.Server, f)
+	s, err := server.NewServer(&cfg.Server, f)
+	if err != nil {
+		return err
+	}
+	server.RegisterEvents(s, &cfg.Server, f)
 	if len(cfg.ControlPage) > 0 {
 		var h controlHandler
 		h, err = newControlHandler(f)
@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package core
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+import (
+	"faucet"
+)
+
+// Administrative methods of Faucet that implement faucet.Admin interface.
+
+// redactedValue replaces secrets in configuration output.
+const redactedValue = "REDACTED"
+
+// redactYAML replaces non-empty values of given keys in a mapping node.
+func redactYAML(n *yaml.Node, keys ...string) {
+	if n.Kind == yaml.DocumentNode {
+		for _, c := range n.Content {
+			redactYAML(c, keys...)
+		}
+		return
+	}
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		for _, k := range keys {
+			if n.Content[i].Value != k || len(n.Content[i+1].Value) == 0 {
+				continue
+			}
+			n.Content[i+1] = &yaml.Node{
+				Kind:  yaml.ScalarNode,
+				Tag:   "!!str",
+				Value: redactedValue,
+			}
+		}
+	}
+}
+
+func (self *Faucet) Ban(prefix string, until time.Time) error {
+	n, err := ParseIPNet(prefix)
+	if err != nil {
+		return err
+	}
+	self.ipr.Ban(n, until)
+	return nil
+}
+
+func (self *Faucet) Bans() []faucet.IPRule { return self.ipr.Bans() }
+
+func (self *Faucet) ClearIntervals(prefix string) error {
+	a, l, err := ParseClientPrefix(prefix)
+	if err != nil {
+		return err
+	}
+	_, err = self.rl.ClearIntervals(a, l)
+	return err
+}
+
+func (self *Faucet) Config() interface{} {
+	n := new(yaml.Node)
+	err := n.Encode(self.conf())
+	if err != nil {
+		return err.Error()
+	}
+	redactYAML(n, "tokenkey")
+	return n
+}
+
+func (self *Faucet) Paused() bool { return atomic.LoadInt32(&self.paused) != 0 }
+
+func (self *Faucet) PeriodTotal() faucet.Amount {
+	ta, err := self.rl.PeriodTotal()
+	if err != nil {
+		log.Println("failed to get period total:", err)
+	}
+	return ta
+}
+
+func (self *Faucet) SetPaused(paused bool) {
+	var v int32
+	if paused {
+		v = 1
+	}
+	if atomic.SwapInt32(&self.paused, v) == v {
+		return
+	}
+	if paused {
+		self.m.Lock()
+		self.evAmt = 0
+		self.ev.Publish(faucet.Event{
+			Kind: faucet.EventAmount,
+			Time: Now(),
+		})
+		self.m.Unlock()
+	} else if self.ev.Subscribers() > 0 {
+		go self.Amount(context.Background())
+	}
+}
+
+func (self *Faucet) Unban(prefix string) error {
+	n, err := ParseIPNet(prefix)
+	if err != nil {
+		return err
+	}
+	self.ipr.Unban(n)
+	return nil
+}
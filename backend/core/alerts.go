@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package core
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+import (
+	"faucet"
+)
+
+// WalletCheckInterval is the interval of checking whether the wallet is reachable for wallet alerts.
+var WalletCheckInterval = time.Minute
+
+// DefTokenAlertPeriod is the default period of counting claims rejected because of invalid tokens.
+const DefTokenAlertPeriod = time.Hour
+
+// monitor holds state of alert conditions.
+type monitor struct {
+	balLow, rateHigh bool // balance and rate alerts were sent, guarded by Faucet.m
+
+	m             sync.Mutex
+	sendErrs      int
+	toks          int
+	tokT          time.Time // start of token rejection counting period
+	walletAlerted bool
+	walletDown    time.Time // zero if the wallet is reachable
+	watch         sync.Once // starts watchWallet
+}
+
+// sendResult counts consecutive failures to send coins and sends an alert when there are Alerts.SendErrors of them.
+// Invalid recipients and insufficient funds are not failures.
+func (self *Faucet) sendResult(err error) {
+	if self.alerter() == nil || self.conf().Alerts.SendErrors <= 0 || err == faucet.ErrInvalidRecipient || err == faucet.ErrNoFunds {
+		return
+	}
+	mo := &self.mo
+	mo.m.Lock()
+	defer mo.m.Unlock()
+	if err == nil {
+		mo.sendErrs = 0
+		return
+	}
+	mo.sendErrs++
+	if mo.sendErrs == self.conf().Alerts.SendErrors {
+		go self.alerter().SendAlert(mo.sendErrs, err)
+	}
+}
+
+// tokenRejected counts claims rejected because of invalid token or solution
+// and sends an alert when there are Alerts.TokenRejections of them during Alerts.TokenPeriod.
+func (self *Faucet) tokenRejected() {
+	if self.alerter() == nil || self.conf().Alerts.TokenRejections <= 0 {
+		return
+	}
+	p := self.conf().Alerts.TokenPeriod
+	if p <= 0 {
+		p = DefTokenAlertPeriod
+	}
+	mo := &self.mo
+	mo.m.Lock()
+	defer mo.m.Unlock()
+	t := Now()
+	if t.Sub(mo.tokT) >= p {
+		mo.tokT = t
+		mo.toks = 0
+	}
+	mo.toks++
+	if mo.toks == self.conf().Alerts.TokenRejections {
+		go self.alerter().TokenAlert(mo.toks, p)
+	}
+}
+
+// dbFailed sends an alert about failure to write the claim log.
+func (self *Faucet) dbFailed(err error) {
+	if self.alerter() != nil {
+		go self.alerter().DBAlert(err)
+	}
+}
+
+// CheckWallet checks whether the wallet is reachable
+// and sends an alert when it has been unreachable for at least Alerts.WalletDown.
+func (self *Faucet) CheckWallet(ctx context.Context) {
+	if self.alerter() == nil || self.conf().Alerts.WalletDown <= 0 {
+		return
+	}
+	_, err := self.bank.Balance(ctx)
+	mo := &self.mo
+	mo.m.Lock()
+	defer mo.m.Unlock()
+	if err == nil {
+		mo.walletDown = time.Time{}
+		mo.walletAlerted = false
+		return
+	}
+	t := Now()
+	if mo.walletDown.IsZero() {
+		mo.walletDown = t
+	}
+	if !mo.walletAlerted && t.Sub(mo.walletDown) >= self.conf().Alerts.WalletDown {
+		mo.walletAlerted = true
+		go self.alerter().WalletAlert(mo.walletDown, err)
+	}
+}
+
+// watchWallet periodically checks the wallet until the faucet is closed.
+func (self *Faucet) watchWallet() {
+	t := time.NewTicker(WalletCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			self.CheckWallet(context.Background())
+		case <-self.done:
+			return
+		}
+	}
+}
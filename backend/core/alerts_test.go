@@ -0,0 +1,215 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package core_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+import (
+	"faucet"
+	"faucet/core"
+)
+
+// waitKinds waits until alerts that the faucet sends asynchronously arrive and returns kinds of alerts sent since the previous call.
+func (self *alerterMock) waitKinds(n int) []string {
+	for i := 0; i < 100; i++ {
+		self.m.Lock()
+		k := len(self.kinds)
+		self.m.Unlock()
+		if k >= n {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	self.m.Lock()
+	defer self.m.Unlock()
+	ks := self.kinds
+	self.kinds = nil
+	return ks
+}
+
+func checkKinds(t *testing.T, al *alerterMock, want ...string) {
+	t.Helper()
+	// Alerts sent by one call arrive in any order.
+	ks := al.waitKinds(len(want))
+	sort.Strings(ks)
+	if fmt.Sprint(ks) != fmt.Sprint(want) {
+		t.Errorf("sent alerts %v, want %v", ks, want)
+	}
+}
+
+func TestRecoveryAlerts(t *testing.T) {
+	cfg := &core.FaucetConfig{
+		Amount:       faucet.Coin,
+		MinAmount:    faucet.Coin,
+		StingyAmount: faucet.Coin,
+		LowBalance:   10 * faucet.Coin,
+	}
+	cfg.RateLimit.Amount = faucet.Coin
+	cfg.RateLimit.Period = time.Hour
+	tm := new(timeMock)
+	tm.set(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	core.Now = tm.get
+	defer resetNow()
+	bank := &bankMock{bal: 13 * faucet.Coin}
+	al := new(alerterMock)
+	f, err := core.NewFaucet(cfg, al, bank, nil)
+	if err != nil {
+		t.Fatal("NewFaucet failed:", err)
+	}
+	defer f.Close()
+	ctx := context.Background()
+	claim := func() {
+		t.Helper()
+		_, _, _, err := f.Claim(ctx, &faucet.ClaimRequest{Client: "192.0.2.1", Recipient: "r"})
+		if err != nil {
+			t.Fatal("Claim failed:", err)
+		}
+		tm.add(time.Minute)
+	}
+	claim()
+	claim()
+	checkKinds(t, al)
+	claim()
+	checkKinds(t, al, "rate")
+	claim()
+	checkKinds(t, al, "balance")
+	claim()
+	checkKinds(t, al)
+	// Both conditions end after the rate limit period and a refill.
+	tm.add(2 * time.Hour)
+	bank.m.Lock()
+	bank.bal = 100 * faucet.Coin
+	bank.m.Unlock()
+	claim()
+	checkKinds(t, al, "balance-recovered", "rate-recovered")
+	claim()
+	checkKinds(t, al)
+}
+
+func TestSendAlert(t *testing.T) {
+	cfg := &core.FaucetConfig{
+		Amount:    faucet.Coin,
+		MinAmount: faucet.Coin,
+	}
+	cfg.Alerts.SendErrors = 2
+	bank := &lossyBank{bankMock: bankMock{bal: 100 * faucet.Coin}}
+	al := new(alerterMock)
+	f, err := core.NewFaucet(cfg, al, bank, nil)
+	if err != nil {
+		t.Fatal("NewFaucet failed:", err)
+	}
+	defer f.Close()
+	ctx := context.Background()
+	for i, c := range []struct {
+		fail      bool
+		recipient string
+		kinds     []string
+	}{
+		{true, "r1", nil},
+		// Invalid recipients are not failures of the wallet.
+		{false, invalidRecipient, nil},
+		{true, "r2", []string{"send 2"}},
+		{true, "r3", nil},
+		{false, "r4", nil},
+		{true, "r5", nil},
+		{true, "r6", []string{"send 2"}},
+	} {
+		bank.fail = c.fail
+		_, _, _, err := f.Claim(ctx, &faucet.ClaimRequest{Client: "192.0.2.1", Recipient: c.recipient})
+		if ok := err == nil; ok != (!c.fail && c.recipient != invalidRecipient) {
+			t.Error("claim", i, "returned", err)
+		}
+		if ks := al.waitKinds(len(c.kinds)); fmt.Sprint(ks) != fmt.Sprint(c.kinds) {
+			t.Errorf("claim %v sent alerts %v, want %v", i, ks, c.kinds)
+		}
+	}
+}
+
+func TestTokenAlert(t *testing.T) {
+	cfg := &core.FaucetConfig{
+		Amount:    faucet.Coin,
+		MinAmount: faucet.Coin,
+	}
+	cfg.PoW.Difficulty = 4
+	cfg.TokenKey, _ = core.GenTokenKey()
+	cfg.Alerts.TokenRejections = 3
+	cfg.Alerts.TokenPeriod = time.Hour
+	tm := new(timeMock)
+	tm.set(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	core.Now = tm.get
+	defer resetNow()
+	al := new(alerterMock)
+	f, err := core.NewFaucet(cfg, al, &bankMock{bal: 100 * faucet.Coin}, nil)
+	if err != nil {
+		t.Fatal("NewFaucet failed:", err)
+	}
+	defer f.Close()
+	ctx := context.Background()
+	reject := func() {
+		t.Helper()
+		_, _, _, err := f.Claim(ctx, &faucet.ClaimRequest{Client: "192.0.2.1", Recipient: "r"})
+		if err != faucet.ErrInvalidToken {
+			t.Fatal("claim without token returned", err)
+		}
+	}
+	reject()
+	reject()
+	checkKinds(t, al)
+	// Rejections are counted anew after the period.
+	tm.add(time.Hour)
+	reject()
+	reject()
+	checkKinds(t, al)
+	reject()
+	checkKinds(t, al, "token 3")
+	reject()
+	checkKinds(t, al)
+}
+
+func TestWalletAlert(t *testing.T) {
+	cfg := &core.FaucetConfig{
+		Amount:    faucet.Coin,
+		MinAmount: faucet.Coin,
+	}
+	cfg.Alerts.WalletDown = 10 * time.Minute
+	tm := new(timeMock)
+	tm.set(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	core.Now = tm.get
+	defer resetNow()
+	bank := &bankMock{bal: 100 * faucet.Coin}
+	al := new(alerterMock)
+	f, err := core.NewFaucet(cfg, al, bank, nil)
+	if err != nil {
+		t.Fatal("NewFaucet failed:", err)
+	}
+	defer f.Close()
+	ctx := context.Background()
+	for i, c := range []struct {
+		err   error
+		d     time.Duration
+		kinds []string
+	}{
+		{errors.New("connection refused"), 0, nil},
+		{errors.New("connection refused"), 9 * time.Minute, nil},
+		{errors.New("connection refused"), time.Minute, []string{"wallet"}},
+		{errors.New("connection refused"), time.Hour, nil},
+		// The wallet is reachable again, so the outage is measured anew.
+		{nil, time.Minute, nil},
+		{errors.New("connection refused"), time.Minute, nil},
+		{errors.New("connection refused"), 10 * time.Minute, []string{"wallet"}},
+	} {
+		tm.add(c.d)
+		bank.setErr(c.err)
+		f.CheckWallet(ctx)
+		if ks := al.waitKinds(len(c.kinds)); fmt.Sprint(ks) != fmt.Sprint(c.kinds) {
+			t.Errorf("check %v sent alerts %v, want %v", i, ks, c.kinds)
+		}
+	}
+}
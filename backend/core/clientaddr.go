@@ -5,6 +5,7 @@ package core
 import (
 	"errors"
 	"net"
+	"strings"
 )
 
 var ErrInvalidClientAddress = errors.New("invalid client IP address")
@@ -57,3 +58,36 @@ func ParseClientAddr(client string) (net.IP, error) {
 	}
 	return ip.To16(), nil
 }
+
+var ErrInvalidClientPrefix = errors.New("invalid client address prefix")
+
+// ParseClientPrefix parses client IP address or CIDR prefix and transforms it into the form used for claim rate limiting.
+// Returns transformed address and the number of its leading bytes that constitute the prefix.
+// Prefix length must be a multiple of 8 bits, up to 64 bits for IPv6.
+func ParseClientPrefix(prefix string) ([8]byte, int, error) {
+	var a [8]byte
+	if !strings.ContainsRune(prefix, '/') {
+		ip, err := ParseClientAddr(prefix)
+		if err != nil {
+			return a, 0, err
+		}
+		return ClientRLAddr(ip), len(a), nil
+	}
+	ip, n, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return a, 0, err
+	}
+	ones, bits := n.Mask.Size()
+	if ones%8 != 0 {
+		return a, 0, ErrInvalidClientPrefix
+	}
+	a = ClientRLAddr(ip)
+	l := ones / 8
+	if bits == 8*net.IPv4len || ip.To4() != nil {
+		l += len(a) - net.IPv4len
+	}
+	if l > len(a) {
+		return a, 0, ErrInvalidClientPrefix
+	}
+	return a, l, nil
+}
@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package core
+
+import (
+	"sync"
+)
+
+import (
+	"faucet"
+)
+
+// EventBuffer is the number of events that can be queued for a subscriber.
+// A subscriber that falls further behind is unsubscribed.
+const EventBuffer = 16
+
+// Events is in-process publish/subscribe hub for faucet events.
+// Publishing never blocks, so slow subscribers cannot delay claims.
+// The zero value is ready to use.
+type Events struct {
+	m  sync.Mutex
+	ss map[chan faucet.Event]struct{}
+}
+
+// Publish sends an event to all subscribers.
+// Subscribers whose buffers are full are unsubscribed and their channels closed.
+func (self *Events) Publish(e faucet.Event) {
+	self.m.Lock()
+	defer self.m.Unlock()
+	for c := range self.ss {
+		select {
+		case c <- e:
+		default:
+			delete(self.ss, c)
+			close(c)
+		}
+	}
+}
+
+// Subscribe implements faucet.EventSource.
+func (self *Events) Subscribe() (<-chan faucet.Event, func()) {
+	c := make(chan faucet.Event, EventBuffer)
+	self.m.Lock()
+	if self.ss == nil {
+		self.ss = make(map[chan faucet.Event]struct{})
+	}
+	self.ss[c] = struct{}{}
+	self.m.Unlock()
+	return c, func() {
+		self.m.Lock()
+		defer self.m.Unlock()
+		if _, ok := self.ss[c]; ok {
+			delete(self.ss, c)
+			close(c)
+		}
+	}
+}
+
+// Subscribers returns the number of active subscriptions.
+func (self *Events) Subscribers() int {
+	self.m.Lock()
+	defer self.m.Unlock()
+	return len(self.ss)
+}
+
+// TruncateRecipient shortens recipient address for public display.
+func TruncateRecipient(recipient string) string {
+	const head, tail = 6, 4
+	if len(recipient) <= head+tail+3 {
+		return recipient
+	}
+	return recipient[:head] + "..." + recipient[len(recipient)-tail:]
+}
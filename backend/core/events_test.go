@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package core_test
+
+import (
+	"context"
+	"testing"
+)
+
+import (
+	"faucet"
+	"faucet/core"
+)
+
+func TestEvents(t *testing.T) {
+	var ev core.Events
+	c1, cancel1 := ev.Subscribe()
+	c2, cancel2 := ev.Subscribe()
+	defer cancel2()
+	if ev.Subscribers() != 2 {
+		t.Error("subscribers:", ev.Subscribers())
+	}
+	ev.Publish(faucet.Event{Kind: faucet.EventClaim})
+	for _, c := range []<-chan faucet.Event{c1, c2} {
+		e, ok := <-c
+		if !ok || e.Kind != faucet.EventClaim {
+			t.Error("received", e, ok)
+		}
+	}
+	cancel1()
+	cancel1()
+	if _, ok := <-c1; ok {
+		t.Error("channel is open after cancel")
+	}
+
+	// c2 is not read, so it falls behind and is closed without blocking publisher.
+	for i := 0; i <= core.EventBuffer; i++ {
+		ev.Publish(faucet.Event{Kind: faucet.EventAmount})
+	}
+	n := 0
+	for range c2 {
+		n++
+	}
+	if n != core.EventBuffer {
+		t.Error("received", n, "events, want", core.EventBuffer)
+	}
+	if ev.Subscribers() != 0 {
+		t.Error("subscribers:", ev.Subscribers())
+	}
+}
+
+func TestClaimEvents(t *testing.T) {
+	cfg := &core.FaucetConfig{
+		Amount:    10 * faucet.Coin,
+		Fee:       faucet.Coin,
+		MinAmount: 2 * faucet.Coin,
+	}
+	f, err := core.NewFaucet(cfg, nil, &bankMock{bal: 100 * faucet.Coin}, nil)
+	if err != nil {
+		t.Fatal("NewFaucet failed:", err)
+	}
+	c, cancel := f.Subscribe()
+	defer cancel()
+	_, _, _, err = f.Claim(context.Background(), &faucet.ClaimRequest{Client: "192.0.2.1", Recipient: "nUvxPtXWKwatQim1dDbjBc6vSSWKwDvYHn"})
+	if err != nil {
+		t.Fatal("Claim failed:", err)
+	}
+	_, err = f.Amount(context.Background())
+	if err != nil {
+		t.Fatal("Amount failed:", err)
+	}
+	f.SetPaused(true)
+	for i, want := range []faucet.Event{
+		{Kind: faucet.EventBalance, Balance: 100 * faucet.Coin},
+		{Kind: faucet.EventAmount, Amount: 10 * faucet.Coin},
+		{Kind: faucet.EventClaim, Amount: 10 * faucet.Coin, Recipient: "nUvxPt...vYHn", TX: "0000000000000001"},
+		{Kind: faucet.EventBalance, Balance: 90 * faucet.Coin},
+		{Kind: faucet.EventAmount},
+	} {
+		var e faucet.Event
+		select {
+		case e = <-c:
+		default:
+			t.Fatal("missing event", i, want)
+		}
+		if e.Time.IsZero() {
+			t.Error("event", i, "has no time")
+		}
+		e.Time = want.Time
+		if e != want {
+			t.Errorf("event %v: got %+v, want %+v", i, e, want)
+		}
+	}
+	select {
+	case e := <-c:
+		t.Errorf("unexpected event %+v", e)
+	default:
+	}
+}
@@ -6,98 +6,219 @@ package core
 import (
 	"context"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"log"
+	"math"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 import (
 	"faucet"
+	"faucet/alert"
 	"faucet/base58"
+	"faucet/metrics"
 )
 
+// AlertsConfig sets alert thresholds and debounce periods.
+type AlertsConfig struct {
+	WalletDown      time.Duration
+	SendErrors      int
+	TokenRejections int
+	TokenPeriod     time.Duration
+	Debounce        alert.DebounceConfig
+}
+
 type FaucetConfig struct {
-	Amount, Fee, MinAmount, StingyAmount, LowBalance float64
-	IPClaimInterval                                  time.Duration
+	Amount, Fee, MinAmount, StingyAmount, LowBalance faucet.Amount
+	IPClaimInterval, RecipientClaimInterval          time.Duration
 	RateLimit                                        struct {
-		Amount float64
+		Amount faucet.Amount
 		Period time.Duration
 	}
+	Batch struct {
+		Interval time.Duration
+		Size     int
+	}
+	PoW struct {
+		Difficulty, RateDifficulty uint
+	}
+	Subnets struct {
+		IPv4Prefix, IPv6Prefix int
+		Divisor, Depth         int
+	}
 	TokenKey        faucet.Bytes
 	AddressVersions []uint
+	IPRules         string
+	Snapshot        struct {
+		File     string
+		Interval time.Duration
+	}
+	Tracking struct {
+		Interval, Period, Stuck time.Duration
+		Confirmations           int
+	}
+	Alerts   AlertsConfig
+	Schedule faucet.AmountSchedule
+	Windows  []faucet.PolicyWindow
 }
 
 type Faucet struct {
 	m             sync.Mutex
 	balOK, rateOK bool
-	alerter       faucet.Alerter
 	bank          faucet.Bank
-	cfg           FaucetConfig
+	ch            Challenger
+	closed        sync.Once
+	cv            faucet.CaptchaVerifier
+	dc            *metrics.Counter // coins of sent claims
+	done          chan struct{}
+	evAmt, evBal  faucet.Amount
+	evOK          bool
+	ev            Events
 	fdb           faucet.FaucetDB
+	ipr           IPRules
+	mo            monitor
+	paused        int32
+	q             claimQueue
 	rcdb          RCDB
+	rl            RateLimitStore
+	rm            sync.Mutex // serializes reloads
+	rs            faucet.RiskScorer
+	st            atomic.Value // *settings
 	tc            TokenCipher
+	tr            tracker
+	ur            unresolved
 }
 
-func (self *Faucet) alert(bal, ramt float64) {
+func (self *Faucet) alert(st *settings, bal, ramt, rlim faucet.Amount) {
 	self.m.Lock()
 	defer self.m.Unlock()
-	if bal <= self.cfg.LowBalance {
+	al := st.alerter
+	if bal <= st.cfg.LowBalance {
 		if self.balOK {
 			self.balOK = false
-			go self.alerter.BalanceAlert(bal)
+			self.mo.balLow = true
+			go al.BalanceAlert(bal)
 		}
 	} else {
+		if self.mo.balLow {
+			self.mo.balLow = false
+			go al.BalanceRecovered(bal)
+		}
 		self.balOK = true
 	}
-	if ramt > self.cfg.RateLimit.Amount {
+	if ramt > rlim {
 		if self.rateOK {
 			self.rateOK = false
-			go self.alerter.RateAlert(ramt, self.cfg.RateLimit.Period)
+			self.mo.rateHigh = true
+			go al.RateAlert(ramt, st.cfg.RateLimit.Period)
 		}
 	} else {
+		if self.mo.rateHigh {
+			self.mo.rateHigh = false
+			go al.RateRecovered(ramt, st.cfg.RateLimit.Period)
+		}
 		self.rateOK = true
 	}
 }
 
-func (self *Faucet) amountAndBalance(ctx context.Context) (amount, balance float64, err error) {
+// amountAndBalance returns giveaway amount and bank balance.
+// If stingy is true, the amount is limited to StingyAmount.
+func (self *Faucet) amountAndBalance(ctx context.Context, stingy bool) (amount, balance faucet.Amount, err error) {
+	// Settings are loaded once, so that a concurrent reload does not mix old and new values.
+	st := self.settings()
+	cfg := &st.cfg
 	balance, err = self.bank.Balance(ctx)
 	if err != nil {
 		return
 	}
-	rl := self.cfg.RateLimit.Amount > 0 && self.cfg.RateLimit.Period >= time.Second
-	var ramt float64
+	_, qa := self.q.queued()
+	balance -= qa
+	pamt, rlim, pause := st.policy(Now())
+	rl := rlim > 0 && cfg.RateLimit.Period >= time.Second
+	var ramt faucet.Amount
 	if rl {
-		ramt = self.rcdb.PeriodTotal()
+		ramt, err = self.rl.PeriodTotal()
+		if err != nil {
+			return
+		}
 	}
-	amount = balance - self.cfg.Fee
-	if amount > self.cfg.StingyAmount && self.cfg.StingyAmount >= self.cfg.MinAmount && amount < self.cfg.LowBalance {
-		amount = self.cfg.StingyAmount
+	amount = balance - cfg.Fee
+	s := &cfg.Schedule
+	if len(s.Balance) > 0 {
+		if a := scheduled(s.Balance, balance, s.Tiered); amount > a {
+			amount = a
+		}
+	} else if amount > cfg.StingyAmount && cfg.StingyAmount >= cfg.MinAmount && amount < cfg.LowBalance {
+		amount = cfg.StingyAmount
 	}
-	if amount > self.cfg.StingyAmount && ramt > self.cfg.RateLimit.Amount {
-		amount = self.cfg.StingyAmount
+	if len(s.Rate) > 0 {
+		if a := scheduled(s.Rate, ramt, s.Tiered); amount > a {
+			amount = a
+		}
+	} else if amount > cfg.StingyAmount && ramt > rlim {
+		amount = cfg.StingyAmount
 	}
-	if amount > self.cfg.Amount {
-		amount = self.cfg.Amount
+	if amount > pamt {
+		amount = pamt
 	}
-	if amount < self.cfg.MinAmount {
+	if amount < cfg.MinAmount || pause {
 		amount = 0
 	}
-	if self.alerter != nil && (self.cfg.LowBalance > 0 || rl) {
-		self.alert(balance, ramt)
+	if st.alerter != nil && (cfg.LowBalance > 0 || rl) {
+		self.alert(st, balance, ramt, rlim)
+	}
+	self.publishState(amount, balance)
+	if stingy && amount > cfg.StingyAmount {
+		amount = cfg.StingyAmount
+		if amount < cfg.MinAmount {
+			amount = 0
+		}
 	}
 	return
 }
 
+// publishState publishes events about changes of giveaway amount and bank balance.
+func (self *Faucet) publishState(amount, balance faucet.Amount) {
+	self.m.Lock()
+	defer self.m.Unlock()
+	t := Now()
+	if !self.evOK || balance != self.evBal {
+		self.ev.Publish(faucet.Event{
+			Kind:    faucet.EventBalance,
+			Time:    t,
+			Balance: balance,
+		})
+	}
+	if !self.evOK || amount != self.evAmt {
+		self.ev.Publish(faucet.Event{
+			Kind:   faucet.EventAmount,
+			Time:   t,
+			Amount: amount,
+		})
+	}
+	self.evAmt, self.evBal, self.evOK = amount, balance, true
+}
+
+// lastState returns giveaway amount and bank balance that were published last. ok is false if there are none yet.
+func (self *Faucet) lastState() (amount, balance faucet.Amount, ok bool) {
+	self.m.Lock()
+	defer self.m.Unlock()
+	return self.evAmt, self.evBal, self.evOK
+}
+
 func (self *Faucet) validRecipient(recipient string) bool {
-	if len(self.cfg.AddressVersions) == 0 {
+	if len(self.conf().AddressVersions) == 0 {
 		return true
 	}
 	rv := base58.AddressVersion(recipient)
 	if rv < 0 {
 		return false
 	}
-	for _, av := range self.cfg.AddressVersions {
+	for _, av := range self.conf().AddressVersions {
 		if uint(rv) == av {
 			return true
 		}
@@ -105,115 +226,525 @@ func (self *Faucet) validRecipient(recipient string) bool {
 	return false
 }
 
-func (self *Faucet) AddressVersions() []uint { return self.cfg.AddressVersions }
+func (self *Faucet) AddressVersions() []uint { return self.conf().AddressVersions }
 
-func (self *Faucet) Amount(ctx context.Context) (float64, error) {
-	amt, _, err := self.amountAndBalance(ctx)
+func (self *Faucet) Amount(ctx context.Context) (faucet.Amount, error) {
+	if self.Paused() {
+		return 0, nil
+	}
+	amt, _, err := self.amountAndBalance(ctx, false)
 	if err != nil {
 		err = faucet.ServiceUnavailableError{Err: err}
 	}
 	return amt, err
 }
 
-func (self *Faucet) Claim(ctx context.Context, client, recipient, token string) (amount float64, tx string, err error) {
+func (self *Faucet) ClaimByID(ctx context.Context, id int64) (*faucet.ClaimRecord, error) {
+	if self.fdb == nil {
+		return nil, faucet.ErrNoClaimLog
+	}
+	cr, err := self.fdb.ClaimByID(id)
+	if err != nil {
+		return nil, faucet.ServiceUnavailableError{Err: err}
+	}
+	if cr == nil {
+		return nil, faucet.ErrNoClaim
+	}
+	return cr, nil
+}
+
+func (self *Faucet) Claims(ctx context.Context, q *faucet.ClaimQuery) ([]faucet.ClaimRecord, error) {
+	if self.fdb == nil {
+		return nil, faucet.ErrNoClaimLog
+	}
+	crs, err := self.fdb.QueryClaims(q)
+	if err != nil {
+		return nil, faucet.ServiceUnavailableError{Err: err}
+	}
+	return crs, nil
+}
+
+func (self *Faucet) ClaimsByTX(ctx context.Context, tx string) ([]faucet.ClaimRecord, error) {
+	if self.fdb == nil {
+		return nil, faucet.ErrNoClaimLog
+	}
+	btx, err := hex.DecodeString(tx)
+	if err != nil || len(btx) == 0 {
+		return nil, faucet.ErrNoClaim
+	}
+	crs, err := self.fdb.ClaimsByTX(btx)
+	if err != nil {
+		return nil, faucet.ServiceUnavailableError{Err: err}
+	}
+	if len(crs) == 0 {
+		return nil, faucet.ErrNoClaim
+	}
+	return crs, nil
+}
+
+func (self *Faucet) Claim(ctx context.Context, req *faucet.ClaimRequest) (amount faucet.Amount, id int64, tx string, err error) {
+	recipient := req.Recipient
 	if !self.validRecipient(recipient) {
 		err = faucet.ErrInvalidRecipient
 		return
 	}
+	key := req.Key
+	if len(key) > MaxKeyLength {
+		err = faucet.ErrInvalidKey
+		return
+	}
+	if len(key) > 0 && self.fdb == nil {
+		// Idempotency cannot be guaranteed without the claim log.
+		err = faucet.ErrNoClaimLog
+		return
+	}
+	if len(key) > 0 {
+		var cr *faucet.ClaimRecord
+		cr, err = self.fdb.ClaimByKey(key)
+		if err != nil {
+			err = faucet.ServiceUnavailableError{Err: err}
+			return
+		}
+		if cr != nil {
+			return self.keyedClaim(cr, recipient)
+		}
+	}
 	var a1 net.IP
-	a1, err = ParseClientAddr(client)
+	a1, err = ParseClientAddr(req.Client)
 	if err != nil {
 		return
 	}
-	if self.tc != nil && (len(token) == 0 || !CheckToken(a1, token, self.tc)) {
+	ir := self.ipr.Match(a1)
+	if ir.Action == faucet.IPDeny {
+		err = faucet.Blocked{Until: ir.Until}
+		return
+	}
+	exempt := ir.Action == faucet.IPExempt
+	risk := faucet.RiskLow
+	if self.rs != nil && ir.Net == nil {
+		risk = self.rs.Risk(a1)
+		if risk == faucet.RiskHigh {
+			err = faucet.ErrHighRisk
+			return
+		}
+	}
+	if self.ch != nil {
+		if len(req.Token) == 0 {
+			self.tokenRejected()
+			err = faucet.ErrInvalidToken
+			return
+		}
+		err = self.ch.Verify(a1, req.Token, req.Solution)
+		if err != nil {
+			if err == faucet.ErrInvalidToken || err == faucet.ErrInvalidSolution {
+				self.tokenRejected()
+			}
+			return
+		}
+	} else if self.tc != nil && (len(req.Token) == 0 || !CheckToken(a1, req.Token, self.tc)) {
+		self.tokenRejected()
 		err = faucet.ErrInvalidToken
 		return
 	}
-	var ts []time.Time
-	if self.cfg.IPClaimInterval >= time.Second {
-		a2 := ClientRLAddr(a1)
-		ts = self.rcdb.CheckAddIntervals(a2)
+	if self.Paused() {
+		err = faucet.ErrPaused
+		return
+	}
+	if self.cv != nil {
+		if len(req.Captcha) == 0 {
+			err = faucet.ErrCaptchaFailed
+			return
+		}
+		err = self.cv.Verify(ctx, req.Captcha, a1.String())
+		if err != nil {
+			if err != faucet.ErrCaptchaFailed {
+				err = faucet.ServiceUnavailableError{Err: err}
+			}
+			return
+		}
+	}
+	var (
+		a2 [8]byte
+		ts []time.Time
+		rr string // recipient of the record at the end of ts
+	)
+	if (self.settings().ipInterval(Now()) >= time.Second && !exempt) || self.conf().RecipientClaimInterval >= time.Second {
+		a2 = ClientRLAddr(a1)
+		var added bool
+		if exempt {
+			ts, err = self.rl.CheckAddRecipientInterval(recipient)
+			added = true
+		} else {
+			ts, added, err = self.rl.CheckAddIntervals(a2, recipient)
+		}
+		if added {
+			rr = recipient
+		}
+		if err != nil {
+			err = faucet.ServiceUnavailableError{Err: err}
+			return
+		}
 		if len(ts) == 0 {
-			t := self.rcdb.CheckInterval(a2)
-			err = faucet.MustWait{Until: t}
+			err = self.mustWait(a2, recipient, exempt)
 			return
 		}
 		defer func() {
 			if len(ts) > 0 {
-				self.rcdb.DelIntervals(a2, ts)
+				self.delIntervals(a2, rr, ts)
 			}
 		}()
 	}
-	var bal float64
-	amount, bal, err = self.amountAndBalance(ctx)
+	var bal faucet.Amount
+	amount, bal, err = self.amountAndBalance(ctx, risk == faucet.RiskMedium)
 	if err != nil {
 		err = faucet.ServiceUnavailableError{Err: err}
 		return
 	}
 	if amount == 0 {
-		if bal < self.cfg.Fee+self.cfg.MinAmount {
+		switch {
+		case bal < self.conf().Fee+self.conf().MinAmount:
 			err = faucet.ErrNoFunds
-		} else {
+		case risk == faucet.RiskMedium:
+			err = faucet.ErrHighRisk
+		default:
 			err = faucet.ErrPaused
 		}
 		return
 	}
-	t1 := Now()
-	tx, err = self.bank.Send(nil, recipient, amount)
-	t2 := Now()
-	if err != nil && err != faucet.ErrInvalidRecipient {
-		err = faucet.SendError{Err: err}
+	// Claims are logged before sending, so that the outcome of sending is not lost.
+	c := qClaim{
+		a: a2,
+		cr: faucet.ClaimRecord{
+			Time:      Now(),
+			Client:    a1,
+			Recipient: recipient,
+			Amount:    amount,
+			Status:    faucet.ClaimSending,
+		},
+	}
+	if self.batching() {
+		c.cr.Status = faucet.ClaimPending
+	}
+	if self.fdb != nil {
+		if len(key) == 0 {
+			key = newKey()
+		}
+		var cr *faucet.ClaimRecord
+		c.cr.ID, cr, err = self.logPending(c.cr.Time, a1, recipient, amount, key, c.cr.Status)
+		if err != nil {
+			err = faucet.ServiceUnavailableError{Err: err}
+			return
+		}
+		if cr != nil {
+			return self.keyedClaim(cr, recipient)
+		}
+		id = c.cr.ID
 	}
-	if len(tx) > 0 {
+	if self.batching() {
+		self.addClaim(c.cr.Time, amount)
+		c.ts, c.rr = ts, rr
+		self.enqueue(c)
 		ts = nil
-		t := t1.Add(t2.Sub(t1) / 2)
-		self.rcdb.AddClaim(t, amount)
-		if self.fdb != nil {
-			btx, err := hex.DecodeString(tx)
-			if err != nil {
-				log.Printf("failed to decode transactin identifier %q: %v", tx, err)
-			}
-			err = self.fdb.LogClaim(t, a1, recipient, amount, btx)
-			if err != nil {
-				log.Println("failed to log claim", t, a1, recipient, amount, tx, err)
+		return
+	}
+	t1 := c.cr.Time
+	comment := sendComment([]qClaim{c})
+	tx, err = self.send(recipient, amount, comment)
+	t2 := Now()
+	self.sendResult(err)
+	if err != nil && err != faucet.ErrInvalidRecipient && err != faucet.ErrNoFunds {
+		// The wallet may have sent coins, so the claim is not allowed again until the transaction is looked up.
+		if f := self.finder(); f != nil {
+			u := &uClaim{amount: amount, cs: []qClaim{c}, comment: comment, recipient: recipient, t: t1}
+			ftx, ferr := self.findSent(nil, f, u)
+			if ferr == nil && len(ftx) > 0 {
+				tx, err = ftx, nil
 			}
 		}
+		if err != nil {
+			self.addClaim(t1, amount)
+			c.ts, c.rr = ts, rr
+			self.unresolve([]qClaim{c}, t1, comment, err)
+			ts = nil
+			err = faucet.SendError{Err: err}
+			return
+		}
 	}
+	if err != nil {
+		self.setClaimStatus([]qClaim{c}, faucet.ClaimFailed, "")
+		if err != faucet.ErrInvalidRecipient {
+			err = faucet.SendError{Err: err}
+		}
+		return
+	}
+	ts = nil
+	t := t1.Add(t2.Sub(t1) / 2)
+	self.addClaim(t, amount)
+	self.ev.Publish(faucet.Event{
+		Kind:      faucet.EventClaim,
+		Time:      t,
+		Amount:    amount,
+		Recipient: TruncateRecipient(recipient),
+		TX:        tx,
+	})
+	self.setClaimStatus([]qClaim{c}, faucet.ClaimSent, tx)
 	return
 }
 
-func (self *Faucet) Token(ctx context.Context, client string) (string, error) {
-	if self.tc == nil {
-		return "", nil
+// mustWait returns MustWait error with time of next claim that would be allowed, or error of rate limit store.
+func (self *Faucet) mustWait(a [8]byte, recipient string, exempt bool) error {
+	var (
+		t   time.Time
+		err error
+	)
+	if !exempt {
+		t, err = self.rl.CheckInterval(a)
+		if err != nil {
+			return faucet.ServiceUnavailableError{Err: err}
+		}
+	}
+	rt, err := self.rl.CheckRecipientInterval(recipient)
+	if err != nil {
+		return faucet.ServiceUnavailableError{Err: err}
+	}
+	if t.Before(rt) {
+		t = rt
+	}
+	return faucet.MustWait{Until: t}
+}
+
+// addClaim adds claim record to rate limit store.
+func (self *Faucet) addClaim(t time.Time, amount faucet.Amount) {
+	err := self.rl.AddClaim(t, amount)
+	if err != nil {
+		log.Println("failed to add rate limit record", t, amount, err)
+	}
+}
+
+// delIntervals removes interval records of a claim that was not sent.
+func (self *Faucet) delIntervals(a [8]byte, recipient string, ts []time.Time) {
+	err := self.rl.DelIntervals(a, recipient, ts)
+	if err != nil {
+		log.Println("failed to remove interval records", recipient, ts, err)
+	}
+}
+
+// SetCaptchaVerifier enables verification of CAPTCHA responses submitted with claims.
+// It must be called before the faucet is used.
+func (self *Faucet) SetCaptchaVerifier(cv faucet.CaptchaVerifier) { self.cv = cv }
+
+// SetRiskScorer enables estimation of client abuse risk.
+// Clients with medium risk get no more than StingyAmount, and claims from clients with high risk are rejected.
+// If StingyAmount is less than MinAmount, claims from clients with medium risk are rejected too.
+// Clients that match IP rules are not scored.
+// It must be called before the faucet is used.
+func (self *Faucet) SetRiskScorer(rs faucet.RiskScorer) { self.rs = rs }
+
+// SetRateLimitStore replaces in-memory rate limiting records with the store.
+// Records are not copied to the store.
+// It must be called before the faucet is used.
+func (self *Faucet) SetRateLimitStore(s RateLimitStore) { self.rl = s }
+
+// RegisterMetrics registers metrics of faucet state.
+// Giveaway amount and bank balance are the values computed last for clients, so scraping does not query the wallet.
+func (self *Faucet) RegisterMetrics(r *metrics.Registry) {
+	coins := func(a faucet.Amount, err error) float64 {
+		if err != nil {
+			return math.NaN()
+		}
+		return a.Coins()
+	}
+	last := func(balance bool) float64 {
+		a, b, ok := self.lastState()
+		if !ok {
+			return math.NaN()
+		}
+		if balance {
+			a = b
+		}
+		return a.Coins()
+	}
+	r.Register(
+		self.dc,
+		&metrics.GaugeFunc{
+			Name: "faucet_amount_coins",
+			Help: "Current giveaway amount.",
+			F:    func() float64 { return last(false) },
+		},
+		&metrics.GaugeFunc{
+			Name: "faucet_balance_coins",
+			Help: "Current bank balance.",
+			F:    func() float64 { return last(true) },
+		},
+		&metrics.GaugeFunc{
+			Name: "faucet_period_total_coins",
+			Help: "Total amount of claims during rate limit period.",
+			F:    func() float64 { return coins(self.rl.PeriodTotal()) },
+		},
+		&metrics.GaugeFunc{
+			Name: "faucet_queued_claims",
+			Help: "Number of claims queued for sending in a batch.",
+			F: func() float64 {
+				n, _ := self.q.queued()
+				return float64(n)
+			},
+		},
+		&metrics.GaugeFunc{
+			Name: "faucet_interval_records",
+			Help: "Number of active claim interval records.",
+			F: func() float64 {
+				n, err := self.rl.IntervalCount()
+				if err != nil {
+					return math.NaN()
+				}
+				return float64(n)
+			},
+		},
+	)
+}
+
+// Subscribe implements faucet.EventSource.
+func (self *Faucet) Subscribe() (<-chan faucet.Event, func()) { return self.ev.Subscribe() }
+
+// difficulty returns proof-of-work difficulty for new challenges.
+// It is raised to PoW.RateDifficulty while total giveaway rate exceeds the rate limit.
+func (self *Faucet) difficulty() uint {
+	st := self.settings()
+	d := st.cfg.PoW.Difficulty
+	_, rlim, _ := st.policy(Now())
+	if st.cfg.PoW.RateDifficulty > d && rlim > 0 && st.cfg.RateLimit.Period >= time.Second {
+		ramt, err := self.rl.PeriodTotal()
+		if err != nil || ramt > rlim {
+			d = st.cfg.PoW.RateDifficulty
+		}
+	}
+	return d
+}
+
+func (self *Faucet) Token(ctx context.Context, client string) (string, uint, error) {
+	if self.ch == nil && self.tc == nil {
+		return "", 0, nil
 	}
 	a, err := ParseClientAddr(client)
 	if err != nil {
-		return "", err
+		return "", 0, err
+	}
+	if self.ch != nil {
+		t, d := self.ch.Challenge(a)
+		return t, d, nil
 	}
-	return GenToken(a, self.tc), nil
+	return GenToken(a, self.tc), 0, nil
 }
 
 func (self *Faucet) WaitTime(ctx context.Context, client string) (time.Time, error) {
-	if self.cfg.IPClaimInterval < time.Second {
-		return time.Time{}, nil
-	}
 	a, err := ParseClientAddr(client)
 	if err != nil {
 		return time.Time{}, err
 	}
-	return self.rcdb.CheckInterval(ClientRLAddr(a)), nil
+	switch ir := self.ipr.Match(a); ir.Action {
+	case faucet.IPDeny:
+		return time.Time{}, faucet.Blocked{Until: ir.Until}
+	case faucet.IPExempt:
+		return time.Time{}, nil
+	}
+	if self.settings().ipInterval(Now()) < time.Second {
+		return time.Time{}, nil
+	}
+	t, err := self.rl.CheckInterval(ClientRLAddr(a))
+	if err != nil {
+		return time.Time{}, faucet.ServiceUnavailableError{Err: err}
+	}
+	return t, nil
+}
+
+// ReloadIPRules reloads IP rules file.
+func (self *Faucet) ReloadIPRules() error {
+	if len(self.conf().IPRules) == 0 {
+		return nil
+	}
+	return self.ipr.Load(self.conf().IPRules)
+}
+
+// watchIPRules reloads IP rules file when it changes until the faucet is closed.
+func (self *Faucet) watchIPRules() {
+	t := time.NewTicker(IPRulesCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if !self.ipr.Changed() {
+				continue
+			}
+			err := self.ReloadIPRules()
+			if err != nil {
+				log.Println("failed to reload IP rules:", err)
+			} else {
+				log.Println("reloaded IP rules from", self.conf().IPRules)
+			}
+		case <-self.done:
+			return
+		}
+	}
+}
+
+// snapshotting returns whether rate limiting records are saved to snapshot file.
+func (self *Faucet) snapshotting() bool { return self.fdb == nil && len(self.conf().Snapshot.File) > 0 }
+
+// saveSnapshot saves rate limiting records to snapshot file.
+func (self *Faucet) saveSnapshot() {
+	err := self.rcdb.SaveSnapshot(self.conf().Snapshot.File)
+	if err != nil {
+		log.Println("failed to save snapshot:", err)
+	}
+}
+
+// watchSnapshot periodically saves snapshot until the faucet is closed.
+func (self *Faucet) watchSnapshot() {
+	iv := self.conf().Snapshot.Interval
+	if iv <= 0 {
+		iv = DefSnapshotInterval
+	}
+	t := time.NewTicker(iv)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			self.saveSnapshot()
+		case <-self.done:
+			return
+		}
+	}
 }
 
 // NewFaucet creates faucet core object. If alerter or db is nil, it will not be used.
 func NewFaucet(cfg *FaucetConfig, alerter faucet.Alerter, bank faucet.Bank, db faucet.FaucetDB) (*Faucet, error) {
 	self := &Faucet{
-		alerter: alerter,
-		bank:    bank,
-		cfg:     *cfg,
-		fdb:     db,
+		bank: bank,
+		dc:   metrics.NewCounter("faucet_dispensed_coins_total", "Total amount of coins sent.", ""),
+		done: make(chan struct{}),
+		fdb:  db,
+	}
+	self.dc.Init("")
+	rl, err := NewRateLimits(cfg)
+	if err != nil {
+		return nil, err
+	}
+	self.st.Store(&settings{cfg: *cfg, alerter: alerter, ws: rl.ws})
+	self.rcdb.RateLimits = *rl
+	self.rl = MemStore{DB: &self.rcdb}
+	if self.batching() && db == nil {
+		return nil, errors.New("sending claims in batches requires database")
+	}
+	err = checkSchedule(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if self.tracking() {
+		err := self.initTracking()
+		if err != nil {
+			return nil, err
+		}
 	}
-	self.rcdb.IPClaimInterval = cfg.IPClaimInterval
-	self.rcdb.RatePeriod = cfg.RateLimit.Period
 	if len(cfg.TokenKey) > 0 {
 		c, err := NewTokenCipher(cfg.TokenKey)
 		if err != nil {
@@ -221,14 +752,39 @@ func NewFaucet(cfg *FaucetConfig, alerter faucet.Alerter, bank faucet.Bank, db f
 		}
 		self.tc = c
 	}
+	if cfg.PoW.Difficulty > 0 {
+		if self.tc == nil {
+			return nil, errors.New("proof of work requires token key")
+		}
+		if cfg.PoW.Difficulty > MaxDifficulty || cfg.PoW.RateDifficulty > MaxDifficulty {
+			return nil, fmt.Errorf("proof-of-work difficulty must not exceed %v", MaxDifficulty)
+		}
+		self.ch = NewPoW(self.tc, self.difficulty)
+	}
+	if len(cfg.IPRules) > 0 {
+		err := self.ipr.Load(cfg.IPRules)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if self.snapshotting() {
+		err := self.rcdb.LoadSnapshot(cfg.Snapshot.File)
+		if err != nil {
+			return nil, err
+		}
+	}
 	if db != nil {
 		var rld time.Duration
-		if rld < cfg.IPClaimInterval {
-			rld = cfg.IPClaimInterval
+		// Policy windows may lengthen intervals of claims in the log.
+		if iv := self.settings().maxIPInterval(); rld < iv {
+			rld = iv
 		}
 		if rld < cfg.RateLimit.Period {
 			rld = cfg.RateLimit.Period
 		}
+		if rld < cfg.RecipientClaimInterval {
+			rld = cfg.RecipientClaimInterval
+		}
 		if rld >= time.Second {
 			cli, err := db.ClaimsSince(Now().Add(-rld))
 			if err != nil {
@@ -249,6 +805,23 @@ func NewFaucet(cfg *FaucetConfig, alerter faucet.Alerter, bank faucet.Bank, db f
 				return nil, err
 			}
 		}
+		err := self.requeue()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(cfg.IPRules) > 0 {
+		go self.watchIPRules()
+	}
+	if self.snapshotting() {
+		go self.watchSnapshot()
+	}
+	if self.finder() != nil {
+		go self.watchUnresolved()
+	}
+	if self.tracking() {
+		go self.watchTxs()
 	}
+	self.startWatchWallet()
 	return self, nil
 }
@@ -0,0 +1,182 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package core_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	"faucet"
+	"faucet/core"
+	"faucet/metrics"
+)
+
+// captchaMock accepts response "ok" and records client addresses.
+type captchaMock struct{ clients []string }
+
+func (self *captchaMock) Verify(ctx context.Context, response, client string) error {
+	self.clients = append(self.clients, client)
+	switch response {
+	case "ok":
+		return nil
+	case "error":
+		return errors.New("siteverify is down")
+	}
+	return faucet.ErrCaptchaFailed
+}
+
+func TestCaptcha(t *testing.T) {
+	cfg := &core.FaucetConfig{
+		Amount:          10 * faucet.Coin,
+		MinAmount:       faucet.Coin,
+		IPClaimInterval: time.Hour,
+	}
+	bank := &bankMock{bal: 100 * faucet.Coin}
+	f, err := core.NewFaucet(cfg, nil, bank, nil)
+	if err != nil {
+		t.Fatal("NewFaucet failed:", err)
+	}
+	cv := new(captchaMock)
+	f.SetCaptchaVerifier(cv)
+	ctx := context.Background()
+	req := &faucet.ClaimRequest{Client: "192.0.2.1:1234", Recipient: "r"}
+	_, _, _, err = f.Claim(ctx, req)
+	if err != faucet.ErrCaptchaFailed {
+		t.Error("claim without CAPTCHA returned", err)
+	}
+	req.Captcha = "bad"
+	_, _, _, err = f.Claim(ctx, req)
+	if err != faucet.ErrCaptchaFailed {
+		t.Error("claim with invalid CAPTCHA returned", err)
+	}
+	req.Captcha = "error"
+	_, _, _, err = f.Claim(ctx, req)
+	if _, ok := err.(faucet.ServiceUnavailableError); !ok {
+		t.Error("claim with failed verification returned", err)
+	}
+	// Rejected claims do not count towards claim interval.
+	req.Captcha = "ok"
+	amt, _, _, err := f.Claim(ctx, req)
+	if err != nil || amt != cfg.Amount {
+		t.Error("claim returned", amt, err)
+	}
+	if len(bank.txs) != 1 {
+		t.Error("sent", len(bank.txs), "transactions")
+	}
+	for _, c := range cv.clients {
+		if c != "192.0.2.1" {
+			t.Errorf("verified client %q", c)
+		}
+	}
+}
+
+// riskMock assigns risk levels to IP addresses.
+type riskMock map[string]faucet.RiskLevel
+
+func (self riskMock) Risk(ip net.IP) faucet.RiskLevel { return self[ip.String()] }
+
+func TestRisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "risk")
+	if err != nil {
+		t.Fatal("TempDir failed:", err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "rules")
+	err = ioutil.WriteFile(fn, []byte("exempt 192.0.2.4\n"), 0644)
+	if err != nil {
+		t.Fatal("WriteFile failed:", err)
+	}
+	cfg := &core.FaucetConfig{
+		Amount:       10 * faucet.Coin,
+		MinAmount:    faucet.Coin,
+		StingyAmount: 2 * faucet.Coin,
+		IPRules:      fn,
+	}
+	f, err := core.NewFaucet(cfg, nil, &bankMock{bal: 100 * faucet.Coin}, nil)
+	if err != nil {
+		t.Fatal("NewFaucet failed:", err)
+	}
+	defer f.Close()
+	f.SetRiskScorer(riskMock{
+		"192.0.2.2": faucet.RiskMedium,
+		"192.0.2.3": faucet.RiskHigh,
+		"192.0.2.4": faucet.RiskHigh,
+	})
+	ctx := context.Background()
+	for _, c := range []struct {
+		client string
+		amt    faucet.Amount
+		err    error
+	}{
+		{"192.0.2.1", cfg.Amount, nil},
+		{"192.0.2.2", cfg.StingyAmount, nil},
+		{"192.0.2.3", 0, faucet.ErrHighRisk},
+		// Clients that match IP rules are not scored.
+		{"192.0.2.4", cfg.Amount, nil},
+	} {
+		amt, _, _, err := f.Claim(ctx, &faucet.ClaimRequest{Client: c.client, Recipient: "r"})
+		if amt != c.amt || err != c.err {
+			t.Error(c.client, "claim returned", amt, err)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	cfg := &core.FaucetConfig{
+		Amount:    10 * faucet.Coin,
+		MinAmount: faucet.Coin,
+	}
+	cfg.Batch.Interval = time.Hour
+	cfg.Batch.Size = 2
+	bank := &bankMock{bal: 25 * faucet.Coin}
+	f, err := core.NewFaucet(cfg, nil, bank, new(fdbMock))
+	if err != nil {
+		t.Fatal("NewFaucet failed:", err)
+	}
+	reg := new(metrics.Registry)
+	f.RegisterMetrics(reg)
+	check := func(want ...string) {
+		t.Helper()
+		var sb strings.Builder
+		reg.WriteTo(&sb)
+		for _, w := range want {
+			if !strings.Contains(sb.String(), "\n"+w+"\n") {
+				t.Errorf("metrics do not contain %q:\n%v", w, sb.String())
+			}
+		}
+	}
+	check("faucet_amount_coins NaN", "faucet_balance_coins NaN", "faucet_dispensed_coins_total 0")
+	ctx := context.Background()
+	if _, err = f.Amount(ctx); err != nil {
+		t.Fatal("Amount failed:", err)
+	}
+	// Scraping does not query the wallet.
+	bank.m.Lock()
+	bank.bal = 5 * faucet.Coin
+	bank.m.Unlock()
+	check("faucet_amount_coins 10", "faucet_balance_coins 25")
+	bank.m.Lock()
+	bank.bal = 25 * faucet.Coin
+	bank.m.Unlock()
+	// Queued claims are counted when the batch is sent.
+	_, _, _, err = f.Claim(ctx, &faucet.ClaimRequest{Client: "192.0.2.1", Recipient: "r1"})
+	if err != nil {
+		t.Fatal("Claim failed:", err)
+	}
+	check("faucet_dispensed_coins_total 0")
+	_, _, _, err = f.Claim(ctx, &faucet.ClaimRequest{Client: "198.51.100.1", Recipient: "r2"})
+	if err != nil {
+		t.Fatal("Claim failed:", err)
+	}
+	f.Close()
+	check("faucet_dispensed_coins_total 20")
+}
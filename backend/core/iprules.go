@@ -0,0 +1,205 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+import (
+	"faucet"
+)
+
+// IPRulesCheckInterval is the interval of checking IP rules file for changes.
+var IPRulesCheckInterval = 10 * time.Second
+
+// ParseIPNet parses IP address or CIDR prefix. IP address is treated as a network with a single address.
+func ParseIPNet(prefix string) (*net.IPNet, error) {
+	if strings.ContainsRune(prefix, '/') {
+		_, n, err := net.ParseCIDR(prefix)
+		return n, err
+	}
+	ip := net.ParseIP(prefix)
+	if ip == nil {
+		return nil, &net.ParseError{Type: "IP address", Text: prefix}
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(8*net.IPv4len, 8*net.IPv4len)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(8*net.IPv6len, 8*net.IPv6len)}, nil
+}
+
+// ParseIPRules parses IP rules, one per line:
+//
+//	action prefix [expiry]
+//
+// Action is allow, deny or exempt. Prefix is IP address or CIDR prefix. Expiry is time in RFC 3339 format.
+// Empty lines and lines beginning with # are ignored.
+func ParseIPRules(r io.Reader) ([]faucet.IPRule, error) {
+	var rs []faucet.IPRule
+	s := bufio.NewScanner(r)
+	for ln := 1; s.Scan(); ln++ {
+		fs := strings.Fields(s.Text())
+		if len(fs) == 0 || strings.HasPrefix(fs[0], "#") {
+			continue
+		}
+		if len(fs) < 2 || len(fs) > 3 {
+			return nil, fmt.Errorf("line %v: invalid rule", ln)
+		}
+		var ir faucet.IPRule
+		switch fs[0] {
+		case "allow":
+			ir.Action = faucet.IPAllow
+		case "deny":
+			ir.Action = faucet.IPDeny
+		case "exempt":
+			ir.Action = faucet.IPExempt
+		default:
+			return nil, fmt.Errorf("line %v: invalid action %q", ln, fs[0])
+		}
+		var err error
+		ir.Net, err = ParseIPNet(fs[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %w", ln, err)
+		}
+		if len(fs) > 2 {
+			ir.Until, err = time.Parse(time.RFC3339, fs[2])
+			if err != nil {
+				return nil, fmt.Errorf("line %v: %w", ln, err)
+			}
+		}
+		rs = append(rs, ir)
+	}
+	return rs, s.Err()
+}
+
+// prefixLen returns prefix length of the network in IPv6 address space.
+func prefixLen(n *net.IPNet) int {
+	ones, bits := n.Mask.Size()
+	return ones + 8*net.IPv6len - bits
+}
+
+// IPRules matches client IP addresses against rules loaded from a file and temporary bans.
+type IPRules struct {
+	bans []faucet.IPRule
+	fi   os.FileInfo
+	m    sync.RWMutex
+	path string
+	rs   []faucet.IPRule
+}
+
+// Load loads rules from the file. If path is empty, the file that was loaded before is reloaded.
+func (self *IPRules) Load(path string) error {
+	self.m.Lock()
+	if len(path) == 0 {
+		path = self.path
+	}
+	self.m.Unlock()
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	rs, err := ParseIPRules(f)
+	if err != nil {
+		return fmt.Errorf("%v: %w", path, err)
+	}
+	self.m.Lock()
+	self.fi = fi
+	self.path = path
+	self.rs = rs
+	self.m.Unlock()
+	return nil
+}
+
+// Changed returns whether the loaded file was modified.
+func (self *IPRules) Changed() bool {
+	self.m.RLock()
+	defer self.m.RUnlock()
+	if self.fi == nil {
+		return false
+	}
+	fi, err := os.Stat(self.path)
+	if err != nil {
+		return false
+	}
+	return !fi.ModTime().Equal(self.fi.ModTime()) || fi.Size() != self.fi.Size()
+}
+
+// Ban adds temporary deny rule or replaces existing rule for the same network.
+func (self *IPRules) Ban(n *net.IPNet, until time.Time) {
+	self.m.Lock()
+	defer self.m.Unlock()
+	ct := Now()
+	bs := self.bans[:0]
+	for _, b := range self.bans {
+		if ct.Before(b.Until) && b.Net.String() != n.String() {
+			bs = append(bs, b)
+		}
+	}
+	self.bans = append(bs, faucet.IPRule{
+		Action: faucet.IPDeny,
+		Net:    n,
+		Until:  until,
+	})
+}
+
+// Bans returns active temporary bans.
+func (self *IPRules) Bans() []faucet.IPRule {
+	self.m.RLock()
+	defer self.m.RUnlock()
+	ct := Now()
+	var bs []faucet.IPRule
+	for _, b := range self.bans {
+		if ct.Before(b.Until) {
+			bs = append(bs, b)
+		}
+	}
+	return bs
+}
+
+// Unban removes temporary ban of the network. Returns whether it was found.
+func (self *IPRules) Unban(n *net.IPNet) bool {
+	self.m.Lock()
+	defer self.m.Unlock()
+	for i, b := range self.bans {
+		if b.Net.String() == n.String() {
+			self.bans = append(self.bans[:i], self.bans[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns the rule for the IP address. The rule with the longest prefix wins; among rules with the same prefix, deny wins, otherwise the first one.
+// Expired rules are ignored. If no rule matches, returns allow rule with nil network.
+func (self *IPRules) Match(ip net.IP) faucet.IPRule {
+	self.m.RLock()
+	defer self.m.RUnlock()
+	ct := Now()
+	r := faucet.IPRule{Action: faucet.IPAllow}
+	l := -1
+	for _, rs := range [][]faucet.IPRule{self.bans, self.rs} {
+		for _, ir := range rs {
+			if !ir.Net.Contains(ip) || (!ir.Until.IsZero() && !ct.Before(ir.Until)) {
+				continue
+			}
+			il := prefixLen(ir.Net)
+			if il > l || (il == l && ir.Action == faucet.IPDeny) {
+				r, l = ir, il
+			}
+		}
+	}
+	return r
+}
@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package core_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	"faucet"
+	"faucet/core"
+)
+
+func TestIPRules(t *testing.T) {
+	tm := new(timeMock)
+	tm.set(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	core.Now = tm.get
+	defer resetNow()
+	const rules = `
+# comment
+deny 192.0.2.0/24
+allow 192.0.2.10
+exempt 192.0.2.11
+deny 192.0.2.11
+deny 2001:db8::/32 2020-01-01T01:00:00Z
+exempt 2001:db8:1::/48
+`
+	rs, err := core.ParseIPRules(strings.NewReader(rules))
+	if err != nil {
+		t.Fatal("ParseIPRules failed:", err)
+	}
+	if len(rs) != 6 {
+		t.Fatal("parsed", len(rs), "rules")
+	}
+	for _, s := range []string{"block 192.0.2.1", "deny 192.0.2.300", "deny", "deny 192.0.2.1 tomorrow"} {
+		_, err = core.ParseIPRules(strings.NewReader(s))
+		if err == nil {
+			t.Errorf("ParseIPRules accepted %q", s)
+		}
+	}
+	var ipr core.IPRules
+	f, err := ioutil.TempFile("", "iprules")
+	if err != nil {
+		t.Fatal("TempFile failed:", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(rules)
+	f.Close()
+	err = ipr.Load(f.Name())
+	if err != nil {
+		t.Fatal("Load failed:", err)
+	}
+	check := func(ip string, a0 faucet.IPAction) {
+		t.Helper()
+		if a := ipr.Match(net.ParseIP(ip)).Action; a != a0 {
+			t.Error(ip, "action", a, "want", a0)
+		}
+	}
+	check("192.0.2.1", faucet.IPDeny)
+	check("192.0.2.10", faucet.IPAllow)
+	check("192.0.2.11", faucet.IPDeny)
+	check("198.51.100.1", faucet.IPAllow)
+	check("2001:db8::1", faucet.IPDeny)
+	check("2001:db8:1::1", faucet.IPExempt)
+	tm.add(time.Hour)
+	check("2001:db8::1", faucet.IPAllow)
+
+	n, _ := core.ParseIPNet("198.51.100.0/24")
+	ipr.Ban(n, tm.get().Add(time.Minute))
+	check("198.51.100.1", faucet.IPDeny)
+	if bs := ipr.Bans(); len(bs) != 1 || bs[0].Net.String() != "198.51.100.0/24" {
+		t.Error("bans:", bs)
+	}
+	tm.add(time.Minute)
+	check("198.51.100.1", faucet.IPAllow)
+	if bs := ipr.Bans(); len(bs) != 0 {
+		t.Error("expired bans:", bs)
+	}
+	ipr.Ban(n, tm.get().Add(time.Minute))
+	if !ipr.Unban(n) {
+		t.Error("Unban did not find the ban")
+	}
+	check("198.51.100.1", faucet.IPAllow)
+}
+
+func TestIPRulesClaim(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iprules")
+	if err != nil {
+		t.Fatal("TempDir failed:", err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "rules")
+	err = ioutil.WriteFile(fn, []byte("deny 192.0.2.0/24\nexempt 198.51.100.1\n"), 0644)
+	if err != nil {
+		t.Fatal("WriteFile failed:", err)
+	}
+	cfg := &core.FaucetConfig{
+		Amount:          faucet.Coin,
+		MinAmount:       faucet.Coin,
+		IPClaimInterval: time.Hour,
+		IPRules:         fn,
+	}
+	f, err := core.NewFaucet(cfg, nil, &bankMock{bal: 100 * faucet.Coin}, nil)
+	if err != nil {
+		t.Fatal("NewFaucet failed:", err)
+	}
+	defer f.Close()
+	ctx := context.Background()
+	_, _, _, err = f.Claim(ctx, &faucet.ClaimRequest{Client: "192.0.2.1", Recipient: "r"})
+	if _, ok := err.(faucet.Blocked); !ok {
+		t.Error("denied claim returned", err)
+	}
+	if _, err = f.WaitTime(ctx, "192.0.2.1"); err == nil {
+		t.Error("WaitTime for denied client did not fail")
+	}
+	for i := 0; i < 2; i++ {
+		_, _, _, err = f.Claim(ctx, &faucet.ClaimRequest{Client: "198.51.100.1", Recipient: "r"})
+		if err != nil {
+			t.Error("exempt claim", i, "failed:", err)
+		}
+	}
+
+	err = ioutil.WriteFile(fn, []byte("deny 198.51.100.1\n"), 0644)
+	if err != nil {
+		t.Fatal("WriteFile failed:", err)
+	}
+	err = f.ReloadIPRules()
+	if err != nil {
+		t.Fatal("ReloadIPRules failed:", err)
+	}
+	_, _, _, err = f.Claim(ctx, &faucet.ClaimRequest{Client: "192.0.2.1", Recipient: "r"})
+	if err != nil {
+		t.Error("claim after reload failed:", err)
+	}
+	_, _, _, err = f.Claim(ctx, &faucet.ClaimRequest{Client: "198.51.100.1", Recipient: "r"})
+	if _, ok := err.(faucet.Blocked); !ok {
+		t.Error("claim denied after reload returned", err)
+	}
+	err = f.Ban("192.0.2.0/24", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal("Ban failed:", err)
+	}
+	_, _, _, err = f.Claim(ctx, &faucet.ClaimRequest{Client: "192.0.2.2", Recipient: "r"})
+	if e, ok := err.(faucet.Blocked); !ok || e.Until.IsZero() {
+		t.Error("banned claim returned", err)
+	}
+}
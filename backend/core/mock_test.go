@@ -3,10 +3,14 @@
 package core_test
 
 import (
+	"context"
+	"fmt"
+	"sync"
 	"time"
 )
 
 import (
+	"faucet"
 	"faucet/core"
 )
 
@@ -17,3 +21,46 @@ func (self *timeMock) get() time.Time      { return self.t }
 func (self *timeMock) set(t time.Time)     { self.t = t }
 
 func resetNow() { core.Now = time.Now }
+
+// invalidRecipient is rejected by bankMock.
+const invalidRecipient = "invalid"
+
+// bankMock is a Bank that records sent transactions. If err is set, all requests fail with it.
+type bankMock struct {
+	bal faucet.Amount
+	err error
+	m   sync.Mutex
+	txs []map[string]faucet.Amount
+}
+
+func (self *bankMock) Balance(ctx context.Context) (faucet.Amount, error) {
+	self.m.Lock()
+	defer self.m.Unlock()
+	return self.bal, self.err
+}
+
+func (self *bankMock) setErr(err error) {
+	self.m.Lock()
+	defer self.m.Unlock()
+	self.err = err
+}
+
+func (self *bankMock) Send(ctx context.Context, recipient string, amount faucet.Amount) (string, error) {
+	return self.SendMany(ctx, map[string]faucet.Amount{recipient: amount})
+}
+
+func (self *bankMock) SendMany(ctx context.Context, amounts map[string]faucet.Amount) (string, error) {
+	self.m.Lock()
+	defer self.m.Unlock()
+	if self.err != nil {
+		return "", self.err
+	}
+	for r, a := range amounts {
+		if r == invalidRecipient {
+			return "", faucet.ErrInvalidRecipient
+		}
+		self.bal -= a
+	}
+	self.txs = append(self.txs, amounts)
+	return fmt.Sprintf("%016x", len(self.txs)), nil
+}
@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package core
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"math/bits"
+	"net"
+	"sync"
+	"time"
+)
+
+import (
+	"faucet"
+)
+
+// ChallengeValidity is how long a proof-of-work challenge can be solved after it was issued.
+const ChallengeValidity = 10 * time.Minute
+
+// MaxDifficulty is the maximum proof-of-work difficulty in bits.
+const MaxDifficulty = 64
+
+// MaxSolutionLength is the maximum length of proof-of-work solution.
+const MaxSolutionLength = 64
+
+// Challenger issues challenges that clients must solve before claiming and verifies the solutions.
+// It is used instead of token check.
+type Challenger interface {
+	// Challenge returns a new challenge for the client address and its difficulty.
+	Challenge(client net.IP) (string, uint)
+
+	// Verify checks the solution of a challenge that was issued to the client address.
+	// It returns faucet.ErrInvalidToken if the challenge is invalid, expired or was solved already,
+	// and faucet.ErrInvalidSolution if the solution is wrong.
+	Verify(client net.IP, challenge, solution string) error
+}
+
+// PoW is hashcash-style proof-of-work Challenger.
+// The solution is a string such that SHA-256 hash of challenge, colon and solution
+// has at least difficulty leading zero bits.
+// Challenges are encrypted with TokenCipher and contain issue time, difficulty, random bytes and client address hash,
+// so they need not be stored until they are solved.
+type PoW struct {
+	c          TokenCipher
+	difficulty func() uint
+	m          sync.Mutex
+	used       iSet
+}
+
+// clientHash returns 6 bytes that identify client address in a challenge.
+func clientHash(client net.IP) []byte {
+	h := sha256.Sum256(client.To16())
+	return h[:6]
+}
+
+// Challenge layout before encryption: 5 bytes of issue time, difficulty, 4 random bytes, client address hash.
+func (self *PoW) Challenge(client net.IP) (string, uint) {
+	d := self.difficulty()
+	b := make([]byte, 16)
+	binary.LittleEndian.PutUint64(b, uint64(Now().Unix()))
+	b[5] = byte(d)
+	rand.Read(b[6:10])
+	copy(b[10:], clientHash(client))
+	self.c.Encrypt(b, b)
+	return hex.EncodeToString(b), d
+}
+
+func (self *PoW) Verify(client net.IP, challenge, solution string) error {
+	b, err := hex.DecodeString(challenge)
+	// Challenges are used once, so other spellings of the same bytes are not accepted.
+	if err != nil || len(b) != 16 || hex.EncodeToString(b) != challenge {
+		return faucet.ErrInvalidToken
+	}
+	self.c.Decrypt(b, b)
+	ct := Now()
+	d := uint(b[5])
+	b[5] = 0
+	t := time.Unix(int64(binary.LittleEndian.Uint64(b[:8])&0xffffffffff), 0)
+	if t.After(ct) || ct.Sub(t) > ChallengeValidity || string(b[10:]) != string(clientHash(client)) {
+		return faucet.ErrInvalidToken
+	}
+	if len(solution) == 0 || len(solution) > MaxSolutionLength || !CheckSolution(challenge, solution, d) {
+		return faucet.ErrInvalidSolution
+	}
+	self.m.Lock()
+	defer self.m.Unlock()
+	self.used.purge(ct)
+	if !self.used.get(challenge).IsZero() {
+		return faucet.ErrInvalidToken
+	}
+	self.used.add(challenge, t.Add(ChallengeValidity))
+	return nil
+}
+
+// CheckSolution checks whether hash of challenge and solution has at least difficulty leading zero bits.
+func CheckSolution(challenge, solution string, difficulty uint) bool {
+	h := sha256.Sum256([]byte(challenge + ":" + solution))
+	var n uint
+	for _, b := range h {
+		if n >= difficulty {
+			break
+		}
+		z := uint(bits.LeadingZeros8(b))
+		n += z
+		if z < 8 {
+			break
+		}
+	}
+	return n >= difficulty
+}
+
+// NewPoW creates proof-of-work challenger. Function difficulty returns current difficulty for new challenges.
+func NewPoW(c TokenCipher, difficulty func() uint) *PoW {
+	return &PoW{
+		c:          c,
+		difficulty: difficulty,
+	}
+}
@@ -0,0 +1,139 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package core_test
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	"faucet"
+	"faucet/core"
+)
+
+// solve finds proof-of-work solution by brute force.
+func solve(challenge string, difficulty uint) string {
+	for i := 0; ; i++ {
+		s := strconv.Itoa(i)
+		if core.CheckSolution(challenge, s, difficulty) {
+			return s
+		}
+	}
+}
+
+func TestPoW(t *testing.T) {
+	key, err := core.GenTokenKey()
+	if err != nil {
+		t.Fatal("GenTokenKey failed:", err)
+	}
+	c, err := core.NewTokenCipher(key)
+	if err != nil {
+		t.Fatal("NewTokenCipher failed:", err)
+	}
+	tm := new(timeMock)
+	tm.set(time.Now())
+	core.Now = tm.get
+	defer resetNow()
+	d := uint(8)
+	pow := core.NewPoW(c, func() uint { return d })
+	ip1 := net.ParseIP("192.0.2.1")
+	ip2 := net.ParseIP("192.0.2.2")
+
+	ch, cd := pow.Challenge(ip1)
+	if cd != d {
+		t.Error("challenge difficulty", cd, "want", d)
+	}
+	s := "x"
+	for core.CheckSolution(ch, s, d) {
+		s += "x"
+	}
+	if pow.Verify(ip1, ch, s) != faucet.ErrInvalidSolution {
+		t.Error("wrong solution accepted")
+	}
+	if pow.Verify(ip1, ch, "") != faucet.ErrInvalidSolution {
+		t.Error("empty solution accepted")
+	}
+	s = solve(ch, d)
+	if pow.Verify(ip2, ch, s) != faucet.ErrInvalidToken {
+		t.Error("challenge for different IP address accepted")
+	}
+	if pow.Verify(ip1, ch[2:]+ch[:2], s) != faucet.ErrInvalidToken {
+		t.Error("altered challenge accepted")
+	}
+	tm.add(core.ChallengeValidity / 2)
+	err = pow.Verify(ip1, ch, s)
+	if err != nil {
+		t.Error("solution not accepted:", err)
+	}
+	if pow.Verify(ip1, ch, s) != faucet.ErrInvalidToken {
+		t.Error("solved challenge accepted again")
+	}
+	if uc := strings.ToUpper(ch); pow.Verify(ip1, uc, solve(uc, d)) != faucet.ErrInvalidToken {
+		t.Error("solved challenge in upper case accepted again")
+	}
+
+	// Difficulty is bound to the challenge.
+	ch, _ = pow.Challenge(ip1)
+	d = 12
+	s = solve(ch, 8)
+	err = pow.Verify(ip1, ch, s)
+	if err != nil {
+		t.Error("solution with difficulty of challenge not accepted:", err)
+	}
+
+	ch, _ = pow.Challenge(ip1)
+	s = solve(ch, d)
+	tm.add(core.ChallengeValidity + time.Second)
+	if pow.Verify(ip1, ch, s) != faucet.ErrInvalidToken {
+		t.Error("expired challenge accepted")
+	}
+}
+
+func TestPoWDifficulty(t *testing.T) {
+	key, err := core.GenTokenKey()
+	if err != nil {
+		t.Fatal("GenTokenKey failed:", err)
+	}
+	cfg := &core.FaucetConfig{
+		Amount:       10 * faucet.Coin,
+		MinAmount:    faucet.Coin,
+		StingyAmount: 2 * faucet.Coin,
+		TokenKey:     key,
+	}
+	cfg.RateLimit.Amount = 15 * faucet.Coin
+	cfg.RateLimit.Period = time.Hour
+	cfg.PoW.Difficulty = 4
+	cfg.PoW.RateDifficulty = 10
+	f, err := core.NewFaucet(cfg, nil, &bankMock{bal: 100 * faucet.Coin}, nil)
+	if err != nil {
+		t.Fatal("NewFaucet failed:", err)
+	}
+	ctx := context.Background()
+	// Difficulty rises when total exceeds rate limit after the second claim.
+	for i, d0 := range []uint{4, 4, 10} {
+		ch, d, err := f.Token(ctx, "192.0.2.1")
+		if err != nil {
+			t.Fatal("Token failed:", err)
+		}
+		if d != d0 {
+			t.Error("claim", i, "difficulty", d, "want", d0)
+		}
+		_, _, _, err = f.Claim(ctx, &faucet.ClaimRequest{Client: "192.0.2.1", Recipient: "r", Token: ch})
+		if err != faucet.ErrInvalidSolution {
+			t.Error("claim", i, "without solution returned", err)
+		}
+		_, _, _, err = f.Claim(ctx, &faucet.ClaimRequest{Client: "192.0.2.1", Recipient: "r", Token: ch, Solution: solve(ch, d)})
+		if err != nil {
+			t.Error("claim", i, "failed:", err)
+		}
+	}
+	_, _, _, err = f.Claim(ctx, &faucet.ClaimRequest{Client: "192.0.2.1", Recipient: "r"})
+	if err != faucet.ErrInvalidToken {
+		t.Error("claim without challenge returned", err)
+	}
+}
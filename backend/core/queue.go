@@ -0,0 +1,205 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package core
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+import (
+	"faucet"
+)
+
+// qClaim is a claim that is queued or being sent.
+type qClaim struct {
+	a  [8]byte // client address for interval records
+	cr faucet.ClaimRecord
+	ts []time.Time // interval records to remove if sending fails
+	rr string      // recipient of the record at the end of ts, empty if there is none
+}
+
+// claimQueue collects accepted claims that are sent in batches.
+type claimQueue struct {
+	cs    []qClaim
+	fm    sync.Mutex // serializes flushes
+	m     sync.Mutex
+	t     *time.Timer
+	total faucet.Amount  // queued and being sent
+	wg    sync.WaitGroup // flushes started when batch is full
+}
+
+// queued returns the number and total amount of claims that are queued or being sent.
+func (self *claimQueue) queued() (int, faucet.Amount) {
+	self.m.Lock()
+	defer self.m.Unlock()
+	return len(self.cs), self.total
+}
+
+func (self *Faucet) batching() bool { return self.conf().Batch.Interval >= time.Second }
+
+// enqueue adds a claim to the queue and schedules sending.
+func (self *Faucet) enqueue(c qClaim) {
+	q := &self.q
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.cs = append(q.cs, c)
+	q.total += c.cr.Amount
+	if self.conf().Batch.Size > 0 && len(q.cs) >= self.conf().Batch.Size {
+		if q.t != nil {
+			q.t.Stop()
+			q.t = nil
+		}
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			self.flush()
+		}()
+	} else if q.t == nil {
+		q.t = time.AfterFunc(self.conf().Batch.Interval, self.flush)
+	}
+}
+
+// flush sends all queued claims in one transaction.
+func (self *Faucet) flush() {
+	q := &self.q
+	q.fm.Lock()
+	defer q.fm.Unlock()
+	q.m.Lock()
+	cs := q.cs
+	q.cs = nil
+	if q.t != nil {
+		q.t.Stop()
+		q.t = nil
+	}
+	q.m.Unlock()
+	if len(cs) == 0 {
+		return
+	}
+	var total faucet.Amount
+	ids := make([]int64, len(cs))
+	amounts := make(map[string]faucet.Amount)
+	for i, c := range cs {
+		ids[i] = c.cr.ID
+		amounts[c.cr.Recipient] += c.cr.Amount
+		total += c.cr.Amount
+	}
+	err := self.fdb.SetClaimStatus(ids, faucet.ClaimSending, nil)
+	if err != nil {
+		log.Println("failed to update claim status, will retry:", err)
+		self.dbFailed(err)
+		q.m.Lock()
+		q.cs = append(cs, q.cs...)
+		if q.t == nil {
+			q.t = time.AfterFunc(self.conf().Batch.Interval, self.flush)
+		}
+		q.m.Unlock()
+		return
+	}
+	defer func() {
+		q.m.Lock()
+		q.total -= total
+		q.m.Unlock()
+	}()
+	t := Now()
+	comment := sendComment(cs)
+	tx, err := self.sendMany(amounts, comment)
+	if err != faucet.ErrInvalidRecipient || len(amounts) == 1 {
+		self.sent(cs, t, tx, comment, err)
+		return
+	}
+	// One invalid address fails the whole batch, so each recipient is sent to separately.
+	rcs := make(map[string][]qClaim)
+	for _, c := range cs {
+		rcs[c.cr.Recipient] = append(rcs[c.cr.Recipient], c)
+	}
+	for r, a := range amounts {
+		t = Now()
+		comment = sendComment(rcs[r])
+		tx, err = self.send(r, a, comment)
+		self.sent(rcs[r], t, tx, comment, err)
+	}
+}
+
+// sent records the outcome of sending claims with the comment that started at time t.
+// Claims that failed with unknown outcome are reconciled later.
+func (self *Faucet) sent(cs []qClaim, t time.Time, tx, comment string, err error) {
+	self.sendResult(err)
+	switch err {
+	case nil:
+	case faucet.ErrInvalidRecipient, faucet.ErrNoFunds:
+		ids := make([]int64, len(cs))
+		for i, c := range cs {
+			ids[i] = c.cr.ID
+		}
+		log.Println("failed to send claims", ids, err)
+		self.setClaimStatus(cs, faucet.ClaimFailed, "")
+		for _, c := range cs {
+			if len(c.ts) > 0 {
+				self.delIntervals(c.a, c.rr, c.ts)
+			}
+		}
+		return
+	default:
+		self.unresolve(cs, t, comment, err)
+		return
+	}
+	self.setClaimStatus(cs, faucet.ClaimSent, tx)
+	t = Now()
+	for _, c := range cs {
+		self.ev.Publish(faucet.Event{
+			Kind:      faucet.EventClaim,
+			Time:      t,
+			Amount:    c.cr.Amount,
+			Recipient: TruncateRecipient(c.cr.Recipient),
+			TX:        tx,
+		})
+	}
+}
+
+// requeue queues pending claims from the log after restart.
+// Claims that were being sent have unknown outcome. They are reconciled if the bank can look up transactions,
+// otherwise they are marked failed.
+func (self *Faucet) requeue() error {
+	crs, err := self.fdb.PendingClaims()
+	if err != nil {
+		return err
+	}
+	var failed []int64
+	q := &self.q
+	q.m.Lock()
+	for _, cr := range crs {
+		if cr.Status == faucet.ClaimSending {
+			if self.finder() != nil {
+				self.unresolve([]qClaim{{cr: cr}}, cr.Time, "", errors.New("service stopped while sending"))
+				continue
+			}
+			log.Println("claim", cr.ID, "was being sent when the service stopped, check the wallet and pay manually if needed")
+			failed = append(failed, cr.ID)
+			continue
+		}
+		q.cs = append(q.cs, qClaim{cr: cr})
+		q.total += cr.Amount
+	}
+	if len(q.cs) > 0 {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			self.flush()
+		}()
+	}
+	q.m.Unlock()
+	return self.fdb.SetClaimStatus(failed, faucet.ClaimFailed, nil)
+}
+
+// Close sends queued claims and saves snapshot. It should be called after the faucet stops accepting claims.
+func (self *Faucet) Close() {
+	self.closed.Do(func() { close(self.done) })
+	self.q.wg.Wait()
+	self.flush()
+	if self.snapshotting() {
+		self.saveSnapshot()
+	}
+}
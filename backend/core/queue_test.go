@@ -0,0 +1,255 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package core_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	"faucet"
+	"faucet/core"
+)
+
+// fdbMock is FaucetDB in memory.
+type fdbMock struct {
+	crs  []faucet.ClaimRecord
+	keys map[string]int64
+	m    sync.Mutex
+}
+
+func (self *fdbMock) ClaimByKey(key string) (*faucet.ClaimRecord, error) {
+	self.m.Lock()
+	id := self.keys[key]
+	self.m.Unlock()
+	return self.ClaimByID(id)
+}
+
+func (self *fdbMock) ClaimByID(id int64) (*faucet.ClaimRecord, error) {
+	self.m.Lock()
+	defer self.m.Unlock()
+	if id < 1 || id > int64(len(self.crs)) {
+		return nil, nil
+	}
+	cr := self.crs[id-1]
+	return &cr, nil
+}
+
+func (self *fdbMock) ClaimsByTX(tx []byte) ([]faucet.ClaimRecord, error) {
+	self.m.Lock()
+	defer self.m.Unlock()
+	var crs []faucet.ClaimRecord
+	for _, cr := range self.crs {
+		if cr.Status == faucet.ClaimSent && bytes.Equal(cr.TX, tx) {
+			crs = append(crs, cr)
+		}
+	}
+	return crs, nil
+}
+
+func (self *fdbMock) ClaimsSince(t time.Time) (faucet.ClaimLogIter, error) { return new(claimLog), nil }
+
+func (self *fdbMock) LogClaim(t time.Time, client net.IP, recipient string, amount faucet.Amount, tx []byte) error {
+	self.m.Lock()
+	defer self.m.Unlock()
+	self.crs = append(self.crs, faucet.ClaimRecord{
+		ID:        int64(len(self.crs) + 1),
+		Time:      t,
+		Client:    client,
+		Recipient: recipient,
+		Amount:    amount,
+		TX:        tx,
+	})
+	return nil
+}
+
+func (self *fdbMock) LogPendingClaim(t time.Time, client net.IP, recipient string, amount faucet.Amount, key string, status faucet.ClaimStatus) (int64, error) {
+	self.m.Lock()
+	_, dup := self.keys[key]
+	self.m.Unlock()
+	if dup {
+		return 0, errors.New("duplicate key")
+	}
+	err := self.LogClaim(t, client, recipient, amount, nil)
+	self.m.Lock()
+	defer self.m.Unlock()
+	id := int64(len(self.crs))
+	self.crs[id-1].Status = status
+	if len(key) > 0 {
+		if self.keys == nil {
+			self.keys = make(map[string]int64)
+		}
+		self.keys[key] = id
+	}
+	return id, err
+}
+
+func (self *fdbMock) PendingClaims() ([]faucet.ClaimRecord, error) {
+	self.m.Lock()
+	defer self.m.Unlock()
+	var crs []faucet.ClaimRecord
+	for _, cr := range self.crs {
+		if cr.Status == faucet.ClaimPending || cr.Status == faucet.ClaimSending {
+			crs = append(crs, cr)
+		}
+	}
+	return crs, nil
+}
+
+func (self *fdbMock) QueryClaims(q *faucet.ClaimQuery) ([]faucet.ClaimRecord, error) { return nil, nil }
+
+func (self *fdbMock) SetClaimStatus(ids []int64, status faucet.ClaimStatus, tx []byte) error {
+	self.m.Lock()
+	defer self.m.Unlock()
+	for _, id := range ids {
+		self.crs[id-1].Status = status
+		if tx != nil {
+			self.crs[id-1].TX = tx
+		}
+	}
+	return nil
+}
+
+func (self *fdbMock) SetTxState(tx []byte, state faucet.TxState, confirmations int) error {
+	self.m.Lock()
+	defer self.m.Unlock()
+	for i := range self.crs {
+		if cr := &self.crs[i]; cr.Status == faucet.ClaimSent && bytes.Equal(cr.TX, tx) {
+			cr.TxState = state
+			cr.Confirmations = confirmations
+		}
+	}
+	return nil
+}
+
+func (self *fdbMock) UnconfirmedClaims(since time.Time) ([]faucet.ClaimRecord, error) {
+	self.m.Lock()
+	defer self.m.Unlock()
+	var crs []faucet.ClaimRecord
+	for _, cr := range self.crs {
+		if cr.Status == faucet.ClaimSent && cr.TxState == faucet.TxUnconfirmed && !cr.Time.Before(since) {
+			crs = append(crs, cr)
+		}
+	}
+	return crs, nil
+}
+
+// checkClaims compares statuses and transaction identifiers of logged claims.
+func checkClaims(t *testing.T, db *fdbMock, sts []faucet.ClaimStatus, txs []string) {
+	t.Helper()
+	if len(db.crs) != len(sts) {
+		t.Fatal("logged", len(db.crs), "claims, want", len(sts))
+	}
+	for i, cr := range db.crs {
+		if cr.Status != sts[i] || hex.EncodeToString(cr.TX) != txs[i] {
+			t.Errorf("claim %v: status %v tx %x, want %v %v", cr.ID, cr.Status, cr.TX, sts[i], txs[i])
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	cfg := &core.FaucetConfig{
+		Amount:          10 * faucet.Coin,
+		Fee:             faucet.Coin,
+		MinAmount:       2 * faucet.Coin,
+		IPClaimInterval: time.Hour,
+	}
+	cfg.Batch.Interval = time.Hour
+	cfg.Batch.Size = 3
+	bank := &bankMock{bal: 25 * faucet.Coin}
+	db := new(fdbMock)
+	f, err := core.NewFaucet(cfg, nil, bank, db)
+	if err != nil {
+		t.Fatal("NewFaucet failed:", err)
+	}
+	ctx := context.Background()
+	for i, c := range [...]struct {
+		client, recipient string
+		amt               faucet.Amount
+	}{
+		{"192.0.2.1", "r1", 10 * faucet.Coin},
+		{"198.51.100.1", "r2", 10 * faucet.Coin},
+		// Queued amounts are not available.
+		{"203.0.113.1", "r1", 4 * faucet.Coin},
+	} {
+		amt, id, tx, err := f.Claim(ctx, &faucet.ClaimRequest{Client: c.client, Recipient: c.recipient})
+		if err != nil {
+			t.Fatal("Claim failed:", err)
+		}
+		if amt != c.amt || id != int64(i+1) || len(tx) != 0 {
+			t.Error("claim", i, "got", amt, id, tx)
+		}
+	}
+	// The batch is full, so it is being sent. Close waits for that.
+	f.Close()
+	if len(bank.txs) != 1 || len(bank.txs[0]) != 2 || bank.txs[0]["r1"] != 14*faucet.Coin || bank.txs[0]["r2"] != 10*faucet.Coin {
+		t.Error("sent", bank.txs)
+	}
+	tx1 := "0000000000000001"
+	checkClaims(t, db, []faucet.ClaimStatus{faucet.ClaimSent, faucet.ClaimSent, faucet.ClaimSent}, []string{tx1, tx1, tx1})
+	cr, err := f.ClaimByID(ctx, 2)
+	if err != nil || cr.Recipient != "r2" {
+		t.Error("ClaimByID returned", cr, err)
+	}
+	_, err = f.ClaimByID(ctx, 4)
+	if err != faucet.ErrNoClaim {
+		t.Error("ClaimByID of missing claim returned", err)
+	}
+
+	// Invalid recipient does not fail the whole batch.
+	bank.bal = 25 * faucet.Coin
+	_, _, _, err = f.Claim(ctx, &faucet.ClaimRequest{Client: "10.0.0.1", Recipient: invalidRecipient})
+	if err != nil {
+		t.Fatal("Claim failed:", err)
+	}
+	_, _, _, err = f.Claim(ctx, &faucet.ClaimRequest{Client: "172.16.0.1", Recipient: "r3"})
+	if err != nil {
+		t.Fatal("Claim failed:", err)
+	}
+	_, _, _, err = f.Claim(ctx, &faucet.ClaimRequest{Client: "10.0.0.2", Recipient: "r4"})
+	if _, ok := err.(faucet.MustWait); !ok {
+		t.Error("Claim from the same network returned", err)
+	}
+	f.Close()
+	if len(bank.txs) != 2 || len(bank.txs[1]) != 1 || bank.txs[1]["r3"] != 10*faucet.Coin {
+		t.Error("sent", bank.txs)
+	}
+	checkClaims(t, db, []faucet.ClaimStatus{faucet.ClaimSent, faucet.ClaimSent, faucet.ClaimSent, faucet.ClaimFailed, faucet.ClaimSent}, []string{tx1, tx1, tx1, "", "0000000000000002"})
+	// Interval record of the failed claim is removed.
+	_, _, _, err = f.Claim(ctx, &faucet.ClaimRequest{Client: "10.0.0.2", Recipient: "r4"})
+	if err != nil {
+		t.Error("Claim after failed claim returned", err)
+	}
+	f.Close()
+}
+
+func TestRequeue(t *testing.T) {
+	cfg := &core.FaucetConfig{
+		Amount:    10 * faucet.Coin,
+		MinAmount: faucet.Coin,
+	}
+	bank := &bankMock{bal: 100 * faucet.Coin}
+	db := new(fdbMock)
+	for i, st := range []faucet.ClaimStatus{faucet.ClaimSent, faucet.ClaimPending, faucet.ClaimSending, faucet.ClaimPending} {
+		db.LogPendingClaim(time.Now(), net.ParseIP("192.0.2.1"), "r", faucet.Amount(i+1)*faucet.Coin, "", faucet.ClaimPending)
+		db.crs[i].Status = st
+	}
+	// Queued claims are sent even if batches are disabled.
+	f, err := core.NewFaucet(cfg, nil, bank, db)
+	if err != nil {
+		t.Fatal("NewFaucet failed:", err)
+	}
+	f.Close()
+	if len(bank.txs) != 1 || len(bank.txs[0]) != 1 || bank.txs[0]["r"] != 6*faucet.Coin {
+		t.Error("sent", bank.txs)
+	}
+	tx := "0000000000000001"
+	checkClaims(t, db, []faucet.ClaimStatus{faucet.ClaimSent, faucet.ClaimSent, faucet.ClaimFailed, faucet.ClaimSent}, []string{"", tx, "", tx})
+}
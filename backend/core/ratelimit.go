@@ -0,0 +1,192 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+import (
+	"faucet"
+)
+
+// Default client subnet granularity of claim intervals.
+const (
+	DefIPv4Prefix      = 32
+	DefIPv6Prefix      = 64
+	DefIntervalDivisor = 16
+	MaxIPv6Prefix      = 64
+)
+
+// RateLimits configures rate limiting.
+//
+// IPClaimInterval applies to clients in the same subnet with IPv4Prefix or IPv6Prefix length.
+// Intervals divided by IntervalDivisor apply to subnets with prefixes 8 bits shorter, and so on,
+// while the interval is longer than 1 second, for up to IntervalDepth prefix lengths.
+// Zero values of these fields mean defaults; zero IntervalDepth means no limit.
+type RateLimits struct {
+	IPClaimInterval        time.Duration // Minimum interval between claims from the same IP address or prefix.
+	IPv4Prefix             int           // Prefix length of IPv4 subnet for IPClaimInterval, a multiple of 8.
+	IPv6Prefix             int           // Prefix length of IPv6 subnet for IPClaimInterval, a multiple of 8 up to 64.
+	IntervalDivisor        int           // Divisor of interval for each shorter prefix.
+	IntervalDepth          int           // Maximum number of prefix lengths with enforced intervals.
+	RatePeriod             time.Duration // Period over which total amount is computed.
+	RecipientClaimInterval time.Duration // Minimum interval between claims to the same recipient address.
+	ws                     []window      // policy windows that override IPClaimInterval
+}
+
+// NewRateLimits returns rate limits configured for the faucet.
+func NewRateLimits(cfg *FaucetConfig) (*RateLimits, error) {
+	sn := &cfg.Subnets
+	if sn.IPv4Prefix < 0 || sn.IPv4Prefix > 8*net.IPv4len || sn.IPv4Prefix%8 != 0 {
+		return nil, errors.New("IPv4 subnet prefix length must be a multiple of 8 up to 32")
+	}
+	if sn.IPv6Prefix < 0 || sn.IPv6Prefix > MaxIPv6Prefix || sn.IPv6Prefix%8 != 0 {
+		return nil, fmt.Errorf("IPv6 subnet prefix length must be a multiple of 8 up to %v", MaxIPv6Prefix)
+	}
+	if sn.Divisor < 0 || sn.Depth < 0 {
+		return nil, errors.New("subnet interval divisor and depth must not be negative")
+	}
+	ws, err := parseWindows(cfg.Windows)
+	if err != nil {
+		return nil, err
+	}
+	return &RateLimits{
+		IPClaimInterval:        cfg.IPClaimInterval,
+		IPv4Prefix:             sn.IPv4Prefix,
+		IPv6Prefix:             sn.IPv6Prefix,
+		IntervalDivisor:        sn.Divisor,
+		IntervalDepth:          sn.Depth,
+		RatePeriod:             cfg.RateLimit.Period,
+		RecipientClaimInterval: cfg.RecipientClaimInterval,
+		ws:                     ws,
+	}, nil
+}
+
+// Subnets returns the length of the longest prefix of rate limiting address that has interval record,
+// and intervals for prefixes from that one down, each 1 byte shorter.
+// IPClaimInterval is overridden by the policy window that is active now.
+func (self *RateLimits) Subnets(a [8]byte) (int, []time.Duration) { return self.subnetsAt(a, Now()) }
+
+// subnetsAt is like Subnets, but applies the policy window that is active at the time.
+func (self *RateLimits) subnetsAt(a [8]byte, t time.Time) (int, []time.Duration) {
+	l := self.IPv6Prefix
+	if l == 0 {
+		l = DefIPv6Prefix
+	}
+	if a[0] == 0 && a[1] == 0 && a[2] == 0 && a[3] == 0 {
+		l = self.IPv4Prefix
+		if l == 0 {
+			l = DefIPv4Prefix
+		}
+		l += 8 * (len(a) - net.IPv4len)
+	}
+	l /= 8
+	div := time.Duration(self.IntervalDivisor)
+	if div == 0 {
+		div = DefIntervalDivisor
+	}
+	iv := self.IPClaimInterval
+	if w := activeWindow(self.ws, t); w != nil && w.IPClaimInterval > 0 {
+		iv = w.IPClaimInterval
+	}
+	var ds []time.Duration
+	for d := iv; len(ds) < l && d > time.Second && (self.IntervalDepth == 0 || len(ds) < self.IntervalDepth); d /= div {
+		ds = append(ds, d)
+	}
+	return l, ds
+}
+
+// Intervals returns intervals enforced for subnets of the IP address, from the subnet with IPv4Prefix or IPv6Prefix length,
+// each next one with 8 bits shorter prefix. IPv4 prefixes shorter than 0 bits contain all IPv4 addresses.
+func (self *RateLimits) Intervals(ip net.IP) []time.Duration {
+	_, ds := self.Subnets(ClientRLAddr(ip))
+	return ds
+}
+
+// RateLimitStore keeps track of recent claims for purposes of rate limiting.
+// Client addresses are rate limiting addresses returned by ClientRLAddr.
+// RCDB keeps records in memory of one process; other implementations can share them between faucet instances.
+type RateLimitStore interface {
+	// AddClaim adds a claim record.
+	AddClaim(t time.Time, amount faucet.Amount) error
+
+	// CheckAddIntervals atomically checks if the claim should be allowed now and if yes, adds corresponding interval records.
+	// Recipient is checked only if it is not empty and RecipientClaimInterval is set.
+	// Returns time points of added records, IP prefix records from the longest prefix followed by recipient record,
+	// and whether the recipient record was added. Returns nil if claiming should not be allowed.
+	CheckAddIntervals(a [8]byte, recipient string) ([]time.Time, bool, error)
+
+	// CheckAddRecipientInterval is like CheckAddIntervals, but checks only recipient interval.
+	// Returns nil if claiming should not be allowed or RecipientClaimInterval is not set.
+	CheckAddRecipientInterval(recipient string) ([]time.Time, error)
+
+	// CheckInterval checks if claiming from this client address should be allowed now.
+	// Returns zero if claiming should be allowed, otherwise returns time of next claim.
+	CheckInterval(a [8]byte) (time.Time, error)
+
+	// CheckRecipientInterval checks if claiming to this recipient address should be allowed now.
+	// Returns zero if claiming should be allowed, otherwise returns time of next claim.
+	CheckRecipientInterval(recipient string) (time.Time, error)
+
+	// ClearIntervals removes interval records for the address prefix a[:l], for prefixes that begin with it, and for prefixes that it begins with.
+	// Returns the number of removed records.
+	ClearIntervals(a [8]byte, l int) (int, error)
+
+	// DelIntervals removes records added by CheckAddIntervals or CheckAddRecipientInterval.
+	// Recipient must be empty if no recipient record was added, since the records do not depend on current limits;
+	// address is ignored for records added by CheckAddRecipientInterval.
+	DelIntervals(a [8]byte, recipient string, ts []time.Time) error
+
+	// IntervalCount returns the number of active interval records.
+	IntervalCount() (int, error)
+
+	// PeriodTotal returns total amount of claims during RatePeriod.
+	PeriodTotal() (faucet.Amount, error)
+
+	// SetRateLimits replaces rate limits. Existing records are kept and apply until they expire.
+	SetRateLimits(rl *RateLimits)
+}
+
+// MemStore implements RateLimitStore with RCDB.
+type MemStore struct{ DB *RCDB }
+
+func (self MemStore) AddClaim(t time.Time, amount faucet.Amount) error {
+	self.DB.AddClaim(t, amount)
+	return nil
+}
+
+func (self MemStore) CheckAddIntervals(a [8]byte, recipient string) ([]time.Time, bool, error) {
+	ts, rr := self.DB.CheckAddIntervals(a, recipient)
+	return ts, rr, nil
+}
+
+func (self MemStore) CheckAddRecipientInterval(recipient string) ([]time.Time, error) {
+	return self.DB.CheckAddRecipientInterval(recipient), nil
+}
+
+func (self MemStore) CheckInterval(a [8]byte) (time.Time, error) {
+	return self.DB.CheckInterval(a), nil
+}
+
+func (self MemStore) CheckRecipientInterval(recipient string) (time.Time, error) {
+	return self.DB.CheckRecipientInterval(recipient), nil
+}
+
+func (self MemStore) ClearIntervals(a [8]byte, l int) (int, error) {
+	return self.DB.ClearIntervals(a, l), nil
+}
+
+func (self MemStore) DelIntervals(a [8]byte, recipient string, ts []time.Time) error {
+	self.DB.DelIntervals(a, recipient, ts)
+	return nil
+}
+
+func (self MemStore) IntervalCount() (int, error) { return self.DB.IntervalCount(), nil }
+
+func (self MemStore) PeriodTotal() (faucet.Amount, error) { return self.DB.PeriodTotal(), nil }
+
+func (self MemStore) SetRateLimits(rl *RateLimits) { self.DB.SetRateLimits(rl) }
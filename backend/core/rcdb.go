@@ -6,6 +6,7 @@ import (
 	"container/heap"
 	"net"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 )
@@ -16,7 +17,7 @@ import (
 
 // cRecord contains information about a claim.
 type cRecord struct {
-	a float64
+	a faucet.Amount
 	t time.Time
 }
 
@@ -47,17 +48,86 @@ func (self *iRecords) Pop() interface{} {
 	return x
 }
 
-// RCDB keeps track of recent claims for purposes of rate limiting.
+// iSet is a set of interval records that can be looked up by key and removed when expired.
+type iSet struct {
+	h iRecords
+	m map[string]time.Time
+}
+
+// add adds a record. Existing record for the same key should be expired or purged.
+func (self *iSet) add(a string, t time.Time) {
+	if self.m == nil {
+		self.m = make(map[string]time.Time)
+	}
+	heap.Push(&self.h, iRecord{
+		a: a,
+		t: t,
+	})
+	self.m[a] = t
+}
+
+// del removes a record if it was not replaced with a later one.
+func (self *iSet) del(a string, t time.Time) {
+	t2 := self.m[a]
+	if t2.IsZero() {
+		return
+	}
+	if t.Before(t2) {
+		return
+	}
+	delete(self.m, a)
+	for j, r := range self.h {
+		if r.a == a {
+			heap.Remove(&self.h, j)
+			break
+		}
+	}
+}
+
+func (self *iSet) get(a string) time.Time { return self.m[a] }
+
+func (self *iSet) purge(ct time.Time) {
+	for len(self.h) > 0 && ct.After(self.h[0].t) {
+		r := heap.Pop(&self.h).(iRecord)
+		if !r.t.Before(self.m[r.a]) {
+			delete(self.m, r.a)
+		}
+	}
+}
+
+// rebuild updates the heap after records were set with set.
+func (self *iSet) rebuild() {
+	self.h = self.h[:0]
+	for a, t := range self.m {
+		self.h = append(self.h, iRecord{
+			a: a,
+			t: t,
+		})
+	}
+	heap.Init(&self.h)
+}
+
+// set extends a record if it would end later than existing one. rebuild must be called afterwards.
+func (self *iSet) set(a string, t time.Time) {
+	if self.m == nil {
+		self.m = make(map[string]time.Time)
+	}
+	if t.Before(self.m[a]) {
+		return
+	}
+	self.m[a] = t
+}
+
+// RCDB keeps track of recent claims for purposes of rate limiting in memory.
 // Old records are automatically removed.
 type RCDB struct {
-	IPClaimInterval time.Duration // Minimum interval between claims from the same IP address or prefix.
-	RatePeriod      time.Duration // Period over which total amount is computed.
-	cs              cRecords
-	csp             int
-	ish             iRecords
-	ism             map[string]time.Time
-	m               sync.Mutex
-	ta              float64
+	RateLimits
+	cs  cRecords
+	csp int
+	is  iSet
+	m   sync.Mutex
+	rs  iSet
+	ta  faucet.Amount
 }
 
 func (self *RCDB) compactClaims() {
@@ -79,13 +149,12 @@ func (self *RCDB) purgeClaims(before time.Time) {
 }
 
 func (self *RCDB) purgeIntervals(ct time.Time) {
-	for len(self.ish) > 0 && ct.After(self.ish[0].t) {
-		delete(self.ism, heap.Pop(&self.ish).(iRecord).a)
-	}
+	self.is.purge(ct)
+	self.rs.purge(ct)
 }
 
 // AddClaim adds a claim record.
-func (self *RCDB) AddClaim(t time.Time, amount float64) {
+func (self *RCDB) AddClaim(t time.Time, amount faucet.Amount) {
 	self.m.Lock()
 	defer self.m.Unlock()
 	self.purgeClaims(Now().Add(-self.RatePeriod))
@@ -107,17 +176,15 @@ func (self *RCDB) AddFromLog(cli faucet.ClaimLogIter) error {
 	rt := ct.Add(-self.RatePeriod)
 	self.purgeClaims(rt)
 	self.compactClaims()
-	if self.ism == nil {
-		self.ism = make(map[string]time.Time)
-	}
 	self.purgeIntervals(ct)
 	var (
-		lt     time.Time
-		client net.IP
-		amt    float64
+		lt        time.Time
+		client    net.IP
+		recipient string
+		amt       faucet.Amount
 	)
 	for cli.Next() {
-		err := cli.Get(&lt, &client, &amt)
+		err := cli.Get(&lt, &client, &recipient, &amt)
 		if err != nil {
 			return err
 		}
@@ -130,61 +197,95 @@ func (self *RCDB) AddFromLog(cli faucet.ClaimLogIter) error {
 		}
 		a1 := ClientRLAddr(client)
 		a2 := string(a1[:])
-		d := self.IPClaimInterval
-		for l := len(a2); l > 0 && d > time.Second; l-- {
+		l, ds := self.subnetsAt(a1, lt)
+		for i, d := range ds {
 			it := lt.Add(d)
 			if ct.After(it) {
 				break
 			}
-			if it.Before(self.ism[a2[:l]]) {
-				continue
+			self.is.set(a2[:l-i], it)
+		}
+		if len(recipient) > 0 && self.RecipientClaimInterval >= time.Second {
+			it := lt.Add(self.RecipientClaimInterval)
+			if !ct.After(it) {
+				self.rs.set(recipient, it)
 			}
-			self.ism[a2[:l]] = it
-			d /= 16
 		}
 	}
 	sort.Sort(self.cs)
-	self.ish = self.ish[:0]
-	for a, t := range self.ism {
-		self.ish = append(self.ish, iRecord{
-			a: a,
-			t: t,
-		})
-	}
-	heap.Init(&self.ish)
+	self.is.rebuild()
+	self.rs.rebuild()
 	return nil
 }
 
+// ClearIntervals removes interval records for the address prefix a[:l], for prefixes that begin with it, and for prefixes that it begins with.
+// After that, claims from addresses with this prefix are allowed unless recipient interval applies.
+// Returns the number of removed records.
+func (self *RCDB) ClearIntervals(a [8]byte, l int) int {
+	self.m.Lock()
+	defer self.m.Unlock()
+	self.purgeIntervals(Now())
+	p := string(a[:l])
+	n := 0
+	for k, t := range self.is.m {
+		if strings.HasPrefix(k, p) || strings.HasPrefix(p, k) {
+			self.is.del(k, t)
+			n++
+		}
+	}
+	return n
+}
+
 // CheckAddIntervals atomically checks if the claim should be allowed now and if yes, adds corresponding interval records.
-// Returns time points of added records.
-// Returns nil if claiming should not be allowed.
-func (self *RCDB) CheckAddIntervals(a [8]byte) []time.Time {
+// Recipient is checked only if it is not empty and RecipientClaimInterval is set.
+// Returns time points of added records, IP prefix records from the longest prefix followed by recipient record,
+// and whether the recipient record was added. Returns nil if claiming should not be allowed.
+func (self *RCDB) CheckAddIntervals(a [8]byte, recipient string) ([]time.Time, bool) {
 	self.m.Lock()
 	defer self.m.Unlock()
 	ct := Now()
 	self.purgeIntervals(ct)
 	as := string(a[:])
 	for l := len(as); l > 0; l-- {
-		if ct.Before(self.ism[as[:l]]) {
-			return nil
+		if ct.Before(self.is.get(as[:l])) {
+			return nil, false
 		}
 	}
-	if self.ism == nil {
-		self.ism = make(map[string]time.Time)
+	rci := len(recipient) > 0 && self.RecipientClaimInterval >= time.Second
+	if rci && ct.Before(self.rs.get(recipient)) {
+		return nil, false
 	}
 	var ts []time.Time
-	d := self.IPClaimInterval
-	for l := len(as); l > 0 && d > time.Second; l-- {
+	l, ds := self.Subnets(a)
+	for i, d := range ds {
 		it := ct.Add(d)
-		heap.Push(&self.ish, iRecord{
-			a: as[:l],
-			t: it,
-		})
-		self.ism[as[:l]] = it
+		self.is.add(as[:l-i], it)
+		ts = append(ts, it)
+	}
+	if rci {
+		it := ct.Add(self.RecipientClaimInterval)
+		self.rs.add(recipient, it)
 		ts = append(ts, it)
-		d /= 16
 	}
-	return ts
+	return ts, rci
+}
+
+// CheckAddRecipientInterval is like CheckAddIntervals, but checks only recipient interval.
+// Returns nil if claiming should not be allowed or RecipientClaimInterval is not set.
+func (self *RCDB) CheckAddRecipientInterval(recipient string) []time.Time {
+	self.m.Lock()
+	defer self.m.Unlock()
+	if len(recipient) == 0 || self.RecipientClaimInterval < time.Second {
+		return nil
+	}
+	ct := Now()
+	self.purgeIntervals(ct)
+	if ct.Before(self.rs.get(recipient)) {
+		return nil
+	}
+	it := ct.Add(self.RecipientClaimInterval)
+	self.rs.add(recipient, it)
+	return []time.Time{it}
 }
 
 // CheckInterval checks if claiming from this client address should be allowed now.
@@ -197,7 +298,7 @@ func (self *RCDB) CheckInterval(a [8]byte) time.Time {
 	as := string(a[:])
 	var nt time.Time
 	for l := len(as); l > 0; l-- {
-		it := self.ism[as[:l]]
+		it := self.is.get(as[:l])
 		if nt.Before(it) {
 			nt = it
 		}
@@ -205,32 +306,49 @@ func (self *RCDB) CheckInterval(a [8]byte) time.Time {
 	return nt
 }
 
-// DelIntervals removes records added by CheckAddIntervals.
-func (self *RCDB) DelIntervals(a [8]byte, ts []time.Time) {
+// CheckRecipientInterval checks if claiming to this recipient address should be allowed now.
+// Returns zero if claiming should be allowed, otherwise returns time of next claim.
+func (self *RCDB) CheckRecipientInterval(recipient string) time.Time {
 	self.m.Lock()
 	defer self.m.Unlock()
+	self.purgeIntervals(Now())
+	return self.rs.get(recipient)
+}
+
+// DelIntervals removes records added by CheckAddIntervals or CheckAddRecipientInterval.
+// Recipient must be empty if no recipient record was added.
+func (self *RCDB) DelIntervals(a [8]byte, recipient string, ts []time.Time) {
+	self.m.Lock()
+	defer self.m.Unlock()
+	if len(recipient) > 0 && len(ts) > 0 {
+		self.rs.del(recipient, ts[len(ts)-1])
+		ts = ts[:len(ts)-1]
+	}
 	a1 := string(a[:])
-	for i, t1 := range ts {
-		a2 := a1[:len(a)-i]
-		t2 := self.ism[a2]
-		if t2.IsZero() {
-			continue
-		}
-		if t1.Before(t2) {
-			continue
-		}
-		delete(self.ism, a2)
-		for j, r := range self.ish {
-			if r.a == a2 {
-				heap.Remove(&self.ish, j)
-				break
-			}
-		}
+	l, _ := self.Subnets(a)
+	for i, t := range ts {
+		self.is.del(a1[:l-i], t)
 	}
 }
 
+// IntervalCount returns the number of active interval records.
+func (self *RCDB) IntervalCount() int {
+	self.m.Lock()
+	defer self.m.Unlock()
+	self.purgeIntervals(Now())
+	return len(self.is.m) + len(self.rs.m)
+}
+
+// SetRateLimits replaces rate limits. Existing records are kept.
+// Claims older than the previous RatePeriod are not counted after it is extended.
+func (self *RCDB) SetRateLimits(rl *RateLimits) {
+	self.m.Lock()
+	defer self.m.Unlock()
+	self.RateLimits = *rl
+}
+
 // PeriodTotal returns total amount of claims during the set period.
-func (self *RCDB) PeriodTotal() float64 {
+func (self *RCDB) PeriodTotal() faucet.Amount {
 	self.m.Lock()
 	defer self.m.Unlock()
 	self.purgeClaims(Now().Add(-self.RatePeriod))
@@ -3,12 +3,13 @@
 package core_test
 
 import (
-	"math"
+	"net"
 	"testing"
 	"time"
 )
 
 import (
+	"faucet"
 	"faucet/core"
 )
 
@@ -17,14 +18,14 @@ func TestIPIntervals(t *testing.T) {
 	tm.set(time.Now().Truncate(time.Second))
 	core.Now = tm.get
 	defer resetNow()
-	db := core.RCDB{IPClaimInterval: 256 * time.Minute}
+	db := core.RCDB{RateLimits: core.RateLimits{IPClaimInterval: 256 * time.Minute}}
 
 	t1 := tm.get()
 	gt := db.CheckInterval([8]byte{1, 2, 3, 4, 5, 6, 7, 8})
 	if !gt.IsZero() {
 		t.Error("check on empty DB:", gt, "want zero")
 	}
-	ts1 := db.CheckAddIntervals([8]byte{2, 3, 4, 5, 6, 7, 8, 9})
+	ts1, _ := db.CheckAddIntervals([8]byte{2, 3, 4, 5, 6, 7, 8, 9}, "")
 	if len(ts1) == 0 {
 		t.Fatal("check-add on empty DB:", ts1, "want non-empty")
 	}
@@ -39,7 +40,7 @@ func TestIPIntervals(t *testing.T) {
 	if !nt.Equal(gt) {
 		t.Error("check on same address:", gt, "want", nt)
 	}
-	ts1 = db.CheckAddIntervals([8]byte{2, 3, 4, 5, 6, 7, 8, 9})
+	ts1, _ = db.CheckAddIntervals([8]byte{2, 3, 4, 5, 6, 7, 8, 9}, "")
 	if len(ts1) > 0 {
 		t.Fatal("check-add on same address:", ts1, "want empty")
 	}
@@ -48,7 +49,7 @@ func TestIPIntervals(t *testing.T) {
 	if !nt.Equal(gt) {
 		t.Error("check on /56 subnet:", gt, "want", nt)
 	}
-	ts1 = db.CheckAddIntervals([8]byte{2, 3, 4, 5, 6, 7, 8, 6})
+	ts1, _ = db.CheckAddIntervals([8]byte{2, 3, 4, 5, 6, 7, 8, 6}, "")
 	if len(ts1) > 0 {
 		t.Fatal("check-add on /56 subnet:", ts1, "want empty")
 	}
@@ -57,7 +58,7 @@ func TestIPIntervals(t *testing.T) {
 	if !nt.Equal(gt) {
 		t.Error("check on /48 subnet:", gt, "want", nt)
 	}
-	ts1 = db.CheckAddIntervals([8]byte{2, 3, 4, 5, 6, 7, 6, 4})
+	ts1, _ = db.CheckAddIntervals([8]byte{2, 3, 4, 5, 6, 7, 6, 4}, "")
 	if len(ts1) > 0 {
 		t.Fatal("check-add on /48 subnet:", ts1, "want empty")
 	}
@@ -68,7 +69,7 @@ func TestIPIntervals(t *testing.T) {
 	if !nt.Equal(gt) {
 		t.Error("check on same address after 1/256 interval:", gt, "want", nt)
 	}
-	ts1 = db.CheckAddIntervals([8]byte{2, 3, 4, 5, 6, 7, 8, 9})
+	ts1, _ = db.CheckAddIntervals([8]byte{2, 3, 4, 5, 6, 7, 8, 9}, "")
 	if len(ts1) > 0 {
 		t.Fatal("check-add on same address after 1/256 interval:", ts1, "want empty")
 	}
@@ -77,7 +78,7 @@ func TestIPIntervals(t *testing.T) {
 	if !nt.Equal(gt) {
 		t.Error("check on /56 subnet after 1/256 interval:", gt, "want", nt)
 	}
-	ts1 = db.CheckAddIntervals([8]byte{2, 3, 4, 5, 6, 7, 8, 6})
+	ts1, _ = db.CheckAddIntervals([8]byte{2, 3, 4, 5, 6, 7, 8, 6}, "")
 	if len(ts1) > 0 {
 		t.Fatal("check-add on /56 subnet after 1/256 interval:", ts1, "want empty")
 	}
@@ -89,7 +90,7 @@ func TestIPIntervals(t *testing.T) {
 	tm.add(time.Second)
 	t2 := tm.get()
 	_ = t2
-	ts1 = db.CheckAddIntervals([8]byte{2, 3, 4, 5, 6, 7, 6, 4})
+	ts1, _ = db.CheckAddIntervals([8]byte{2, 3, 4, 5, 6, 7, 6, 4}, "")
 	if len(ts1) == 0 {
 		t.Fatal("check-add on /48 subnet after 1/256 interval:", ts1, "want non-empty")
 	}
@@ -108,13 +109,13 @@ func TestIPIntervals(t *testing.T) {
 	tm.add(time.Minute + time.Second)
 	t3 := tm.get()
 	_ = t3
-	ts2 := db.CheckAddIntervals([8]byte{2, 3, 4, 5, 6, 7, 6, 3})
+	ts2, _ := db.CheckAddIntervals([8]byte{2, 3, 4, 5, 6, 7, 6, 3}, "")
 	if len(ts2) == 0 {
 		t.Fatal("check-add on /56 subnet after 1/16 interval:", ts2, "want non-empty")
 	}
 
 	tm.add(time.Minute + time.Second)
-	db.DelIntervals([8]byte{2, 3, 4, 5, 6, 7, 6, 4}, ts1)
+	db.DelIntervals([8]byte{2, 3, 4, 5, 6, 7, 6, 4}, "", ts1)
 
 	tm.add(14 * time.Minute)
 	nt = t3.Add(16 * time.Minute)
@@ -146,27 +147,30 @@ func TestIPIntervals(t *testing.T) {
 	}
 }
 
+// amt returns i tenths of a coin. Such amounts are not exactly representable as binary fractions.
+func amt(i int) faucet.Amount { return faucet.Amount(i) * faucet.Coin / 10 }
+
 func TestRCAmount(t *testing.T) {
 	tm := new(timeMock)
 	tm.set(time.Now())
 	core.Now = tm.get
 	defer resetNow()
-	db := core.RCDB{RatePeriod: time.Hour}
+	db := core.RCDB{RateLimits: core.RateLimits{RatePeriod: time.Hour}}
 	ga := db.PeriodTotal()
-	if math.Abs(ga) > .25 {
+	if ga != 0 {
 		t.Fatal("initial total:", ga, "want", 0)
 	}
 	t0 := tm.get()
-	var ta float64
+	var ta faucet.Amount
 	for i := 1; i < 10; i++ {
-		db.AddClaim(t0.Add(time.Duration(i)*time.Minute), float64(i))
-		ta += float64(i)
+		db.AddClaim(t0.Add(time.Duration(i)*time.Minute), amt(i))
+		ta += amt(i)
 	}
 	tm.add(time.Hour)
 	for i := 0; i < 10; i++ {
-		ta -= float64(i)
+		ta -= amt(i)
 		ga = db.PeriodTotal()
-		if math.Abs(ga-ta) > .25 {
+		if ga != ta {
 			t.Fatal("full period: total", ga, "want", ta)
 		}
 		tm.add(time.Minute + time.Second)
@@ -175,30 +179,271 @@ func TestRCAmount(t *testing.T) {
 	ta = 0
 	for i := 0; i < 3; i++ {
 		for j := i*8 + 1; j < i*8+9; j++ {
-			db.AddClaim(t0.Add(time.Duration(j)*time.Minute), float64(j))
-			ta += float64(j)
+			db.AddClaim(t0.Add(time.Duration(j)*time.Minute), amt(j))
+			ta += amt(j)
 		}
 		if i == 0 {
 			tm.add(time.Hour)
 		}
 		for j := i * 5; j < i*5+5; j++ {
-			ta -= float64(j)
+			ta -= amt(j)
 			ga = db.PeriodTotal()
-			if math.Abs(ga-ta) > .25 {
+			if ga != ta {
 				t.Fatal("partial period: total", ga, "want", ta)
 			}
 			tm.add(time.Minute + time.Second)
 		}
 	}
 	for i := 5 * 3; i < 8*3+1; i++ {
-		ta -= float64(i)
+		ta -= amt(i)
 		ga = db.PeriodTotal()
-		if math.Abs(ga-ta) > .25 {
+		if ga != ta {
 			t.Fatal("final purge: total", ga, "want", ta)
 		}
 		tm.add(time.Minute + time.Second)
 	}
-	if math.Abs(ta) > .25 {
+	if ta != 0 {
 		t.Fatal("test error: final total:", ta, "want 0")
 	}
 }
+
+// claimLog is ClaimLogIter over a slice.
+type claimLog struct {
+	i  int
+	rs []claimLogRecord
+}
+
+type claimLogRecord struct {
+	t         time.Time
+	client    net.IP
+	recipient string
+	amount    faucet.Amount
+}
+
+func (self *claimLog) Close() error { return nil }
+
+func (self *claimLog) Get(t *time.Time, client *net.IP, recipient *string, amount *faucet.Amount) error {
+	r := &self.rs[self.i-1]
+	*t = r.t
+	*client = r.client
+	*recipient = r.recipient
+	*amount = r.amount
+	return nil
+}
+
+func (self *claimLog) Next() bool {
+	if self.i >= len(self.rs) {
+		return false
+	}
+	self.i++
+	return true
+}
+
+func TestRecipientIntervals(t *testing.T) {
+	tm := new(timeMock)
+	tm.set(time.Now().Truncate(time.Second))
+	core.Now = tm.get
+	defer resetNow()
+	db := core.RCDB{RateLimits: core.RateLimits{
+		IPClaimInterval:        16 * time.Second,
+		RecipientClaimInterval: time.Hour,
+	}}
+	a1 := [8]byte{1, 2, 3, 4, 5, 6, 7, 8}
+	a2 := [8]byte{9, 10, 11, 12, 13, 14, 15, 16}
+	t1 := tm.get()
+	ts, _ := db.CheckAddIntervals(a1, "r1")
+	if len(ts) != 2 {
+		t.Fatal("check-add on empty DB:", ts, "want 2 records")
+	}
+	ts, _ = db.CheckAddIntervals(a2, "r1")
+	if len(ts) > 0 {
+		t.Fatal("check-add on same recipient:", ts, "want empty")
+	}
+	nt := t1.Add(time.Hour)
+	gt := db.CheckRecipientInterval("r1")
+	if !nt.Equal(gt) {
+		t.Error("check on same recipient:", gt, "want", nt)
+	}
+	if gt = db.CheckInterval(a2); !gt.IsZero() {
+		t.Error("IP interval added on rejected claim:", gt)
+	}
+
+	tm.add(2 * time.Minute)
+	ts, _ = db.CheckAddIntervals(a1, "r1")
+	if len(ts) > 0 {
+		t.Fatal("check-add on same recipient after IP interval:", ts, "want empty")
+	}
+	ts, _ = db.CheckAddIntervals(a1, "r2")
+	if len(ts) != 2 {
+		t.Fatal("check-add on different recipient:", ts, "want 2 records")
+	}
+	db.DelIntervals(a1, "r2", ts)
+	if gt = db.CheckRecipientInterval("r2"); !gt.IsZero() {
+		t.Error("check on recipient after del:", gt, "want zero")
+	}
+	if gt = db.CheckInterval(a1); !gt.IsZero() {
+		t.Error("check on address after del:", gt, "want zero")
+	}
+
+	tm.add(time.Hour)
+	if gt = db.CheckRecipientInterval("r1"); !gt.IsZero() {
+		t.Error("check on recipient after full interval:", gt, "want zero")
+	}
+
+	// Records are removed as they were added, even if recipient interval was changed since.
+	ts, rr := db.CheckAddIntervals(a1, "r3")
+	db.SetRateLimits(&core.RateLimits{IPClaimInterval: 16 * time.Second})
+	db.DelIntervals(a1, "r3", ts)
+	if n := db.IntervalCount(); !rr || n != 0 {
+		t.Error(n, "interval records after del with recipient interval turned off, recipient record", rr)
+	}
+	ts, rr = db.CheckAddIntervals(a1, "r3")
+	db.SetRateLimits(&core.RateLimits{IPClaimInterval: 16 * time.Second, RecipientClaimInterval: time.Hour})
+	db.DelIntervals(a1, "", ts)
+	if n := db.IntervalCount(); rr || n != 0 {
+		t.Error(n, "interval records after del with recipient interval turned on, recipient record", rr)
+	}
+
+	db = core.RCDB{RateLimits: core.RateLimits{
+		IPClaimInterval:        16 * time.Second,
+		RecipientClaimInterval: time.Hour,
+	}}
+	t2 := tm.get().Add(-30 * time.Minute)
+	err := db.AddFromLog(&claimLog{rs: []claimLogRecord{
+		{t: t2.Add(-time.Hour), client: net.ParseIP("1.2.3.4"), recipient: "r1", amount: faucet.Coin},
+		{t: t2, client: net.ParseIP("1.2.3.4"), recipient: "r2", amount: faucet.Coin},
+	}})
+	if err != nil {
+		t.Fatal("AddFromLog failed:", err)
+	}
+	if gt = db.CheckRecipientInterval("r1"); !gt.IsZero() {
+		t.Error("check on expired recipient from log:", gt, "want zero")
+	}
+	nt = t2.Add(time.Hour)
+	if gt = db.CheckRecipientInterval("r2"); !nt.Equal(gt) {
+		t.Error("check on recipient from log:", gt, "want", nt)
+	}
+	if ts, _ = db.CheckAddIntervals(a2, "r2"); len(ts) > 0 {
+		t.Error("check-add on recipient from log:", ts, "want empty")
+	}
+}
+
+func TestClearIntervals(t *testing.T) {
+	tm := new(timeMock)
+	tm.set(time.Now().Truncate(time.Second))
+	core.Now = tm.get
+	defer resetNow()
+	db := core.RCDB{RateLimits: core.RateLimits{IPClaimInterval: 256 * time.Minute}}
+	ips := []string{"1.2.3.4", "1.2.3.5", "1.2.4.6", "2001:db8:1:2::1"}
+	for i, s := range ips {
+		ip, err := core.ParseClientAddr(s)
+		if err != nil {
+			t.Fatal("ParseClientAddr failed:", err)
+		}
+		if ts, _ := db.CheckAddIntervals(core.ClientRLAddr(ip), ""); len(ts) == 0 {
+			t.Fatal("check-add", s, "failed")
+		}
+		tm.add(time.Duration(i+1) * 20 * time.Minute)
+	}
+	for _, c := range [...]struct {
+		p     string
+		clear []string
+		ok    bool
+	}{
+		{"1.2.3.0/25", nil, false},
+		{"2001:db8::/96", nil, false},
+		{"not an address", nil, false},
+		{"1.2.3.0/24", []string{"1.2.3.4", "1.2.3.5"}, true},
+		{"1.2.4.6", []string{"1.2.4.6"}, true},
+		{"2001:db8:1::/48", []string{"2001:db8:1:2::1"}, true},
+	} {
+		a, l, err := core.ParseClientPrefix(c.p)
+		if !c.ok {
+			if err == nil {
+				t.Error("ParseClientPrefix", c.p, "succeeded")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal("ParseClientPrefix", c.p, "failed:", err)
+		}
+		for _, s := range c.clear {
+			ip, _ := core.ParseClientAddr(s)
+			if gt := db.CheckInterval(core.ClientRLAddr(ip)); gt.IsZero() {
+				t.Error("check on", s, "before clearing", c.p, ": zero")
+			}
+		}
+		db.ClearIntervals(a, l)
+		for _, s := range c.clear {
+			ip, _ := core.ParseClientAddr(s)
+			if gt := db.CheckInterval(core.ClientRLAddr(ip)); !gt.IsZero() {
+				t.Error("check on", s, "after clearing", c.p, ":", gt, "want zero")
+			}
+		}
+	}
+}
+
+func TestSubnetIntervals(t *testing.T) {
+	tm := new(timeMock)
+	tm.set(time.Now().Truncate(time.Second))
+	core.Now = tm.get
+	defer resetNow()
+	db := core.RCDB{RateLimits: core.RateLimits{
+		IPClaimInterval: time.Hour,
+		IPv4Prefix:      24,
+		IPv6Prefix:      48,
+		IntervalDivisor: 4,
+		IntervalDepth:   2,
+	}}
+	want := []time.Duration{time.Hour, 15 * time.Minute}
+	for _, s := range []string{"192.0.2.1", "2001:db8::1"} {
+		ds := db.Intervals(net.ParseIP(s))
+		if len(ds) != len(want) || ds[0] != want[0] || ds[1] != want[1] {
+			t.Error(s, "intervals", ds, "want", want)
+		}
+	}
+	rla := func(s string) [8]byte { return core.ClientRLAddr(net.ParseIP(s)) }
+	for _, c := range []struct {
+		a1, a2 string
+		d      time.Duration
+	}{
+		{"192.0.2.1", "192.0.2.200", time.Hour},
+		{"192.0.2.1", "192.0.3.1", 15 * time.Minute},
+		{"192.0.2.1", "192.1.2.1", 0},
+		{"2001:db8:1:2::1", "2001:db8:1:ff::1", time.Hour},
+		{"2001:db8:1::1", "2001:db8:2::1", 15 * time.Minute},
+		{"2001:db8:1::1", "2001:db9:1::1", 0},
+	} {
+		db.ClearIntervals([8]byte{}, 0)
+		t1 := tm.get()
+		ts, _ := db.CheckAddIntervals(rla(c.a1), "")
+		if len(ts) != 2 {
+			t.Fatal("check-add", c.a1, "added", len(ts), "records")
+		}
+		nt := db.CheckInterval(rla(c.a2))
+		if c.d == 0 && !nt.IsZero() || c.d != 0 && !nt.Equal(t1.Add(c.d)) {
+			t.Error("check", c.a2, "after", c.a1, "returned", nt, "want", c.d)
+		}
+		db.DelIntervals(rla(c.a1), "", ts)
+		if nt = db.CheckInterval(rla(c.a2)); !nt.IsZero() {
+			t.Error("check", c.a2, "after del returned", nt)
+		}
+	}
+
+	// Limits rebuilt from claim log are the same.
+	err := db.AddFromLog(&claimLog{rs: []claimLogRecord{
+		{t: tm.get(), client: net.ParseIP("2001:db8:1:2::1"), amount: faucet.Coin},
+	}})
+	if err != nil {
+		t.Fatal("AddFromLog failed:", err)
+	}
+	if nt := db.CheckInterval(rla("2001:db8:1:ff::1")); !nt.Equal(tm.get().Add(time.Hour)) {
+		t.Error("check on subnet from log returned", nt)
+	}
+	if nt := db.CheckInterval(rla("2001:db8:2::1")); !nt.Equal(tm.get().Add(15 * time.Minute)) {
+		t.Error("check on larger subnet from log returned", nt)
+	}
+	if nt := db.CheckInterval(rla("2001:db9::1")); !nt.IsZero() {
+		t.Error("check on other subnet from log returned", nt)
+	}
+}
@@ -0,0 +1,340 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+import (
+	"faucet"
+)
+
+// MaxKeyLength is the maximum length of claim idempotency key.
+const MaxKeyLength = 64
+
+// ReconcileInterval is the interval of looking up claims with unknown outcome in the wallet.
+var ReconcileInterval = time.Minute
+
+// ReconcileDelay is the time after sending a claim during which its transaction may not yet be listed by the wallet.
+// A claim with unknown outcome is considered failed if its transaction is not found after this delay.
+var ReconcileDelay = time.Minute
+
+// reconcileSlack allows for difference between clocks of the faucet and the wallet.
+const reconcileSlack = time.Minute
+
+// uClaim is a send request with unknown outcome: the wallet may have paid the amount to the recipient or not.
+type uClaim struct {
+	amount    faucet.Amount
+	cs        []qClaim
+	comment   string // comment of the send request, empty if unknown
+	recipient string
+	t         time.Time // when sending started
+}
+
+// unresolved holds send requests with unknown outcome.
+type unresolved struct {
+	m  sync.Mutex
+	rm sync.Mutex // serializes reconciliation
+	us []uClaim
+}
+
+// newKey generates idempotency key for a claim that was submitted without one.
+func newKey() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// finder returns the bank as SentTxFinder, or nil if it cannot look up transactions.
+func (self *Faucet) finder() faucet.SentTxFinder {
+	f, _ := self.bank.(faucet.SentTxFinder)
+	return f
+}
+
+// commentPrefix starts comments of transactions sent by the faucet.
+const commentPrefix = "faucet "
+
+// sendComment returns comment that identifies the send request of claims.
+// Logged claims are listed by their identifiers, so that the transaction can be found after restart;
+// otherwise a random key is used.
+func sendComment(cs []qClaim) string {
+	ids := make([]string, len(cs))
+	for i, c := range cs {
+		if c.cr.ID == 0 {
+			return commentPrefix + newKey()
+		}
+		ids[i] = strconv.FormatInt(c.cr.ID, 10)
+	}
+	return commentPrefix + "claims " + strings.Join(ids, " ")
+}
+
+// commentIDs returns identifiers of claims listed in the comment, or nil if the comment does not list claims.
+func commentIDs(comment string) map[int64]bool {
+	if !strings.HasPrefix(comment, commentPrefix+"claims ") {
+		return nil
+	}
+	ids := make(map[int64]bool)
+	for _, s := range strings.Fields(comment[len(commentPrefix+"claims "):]) {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil
+		}
+		ids[id] = true
+	}
+	return ids
+}
+
+// matches reports whether the output may be the payment of the send request.
+// Outputs with comment match only the comment of the request or a list of its claims.
+// Outputs without comment, sent by a bank that cannot store comments, match by amount.
+func (self *uClaim) matches(st *faucet.SentTx) bool {
+	if len(st.Comment) == 0 {
+		return st.Amount == self.amount
+	}
+	if st.Comment == self.comment {
+		return true
+	}
+	ids := commentIDs(st.Comment)
+	if ids == nil {
+		return false
+	}
+	for _, c := range self.cs {
+		if !ids[c.cr.ID] {
+			return false
+		}
+	}
+	return true
+}
+
+// findSent looks up the transaction of the send request with unknown outcome. Returns empty string if it is not found.
+// Outputs without comment in transactions already recorded in the claim log belong to other claims, so they are skipped.
+func (self *Faucet) findSent(ctx context.Context, f faucet.SentTxFinder, u *uClaim) (string, error) {
+	sts, err := f.FindSent(ctx, u.recipient, u.t.Add(-reconcileSlack))
+	for i := range sts {
+		st := &sts[i]
+		if !u.matches(st) {
+			continue
+		}
+		if len(st.Comment) == 0 && self.fdb != nil {
+			btx, herr := hex.DecodeString(st.TX)
+			if herr != nil {
+				continue
+			}
+			crs, ferr := self.fdb.ClaimsByTX(btx)
+			if ferr != nil {
+				return "", ferr
+			}
+			if len(crs) > 0 {
+				continue
+			}
+		}
+		return st.TX, nil
+	}
+	return "", err
+}
+
+// send sends coins to the recipient with the comment if the bank can store it.
+func (self *Faucet) send(recipient string, amount faucet.Amount, comment string) (string, error) {
+	if s, ok := self.bank.(faucet.CommentSender); ok {
+		return s.SendComment(nil, recipient, amount, comment)
+	}
+	return self.bank.Send(nil, recipient, amount)
+}
+
+// sendMany sends coins to several recipients with the comment if the bank can store it.
+func (self *Faucet) sendMany(amounts map[string]faucet.Amount, comment string) (string, error) {
+	if s, ok := self.bank.(faucet.CommentSender); ok {
+		return s.SendManyComment(nil, amounts, comment)
+	}
+	return self.bank.SendMany(nil, amounts)
+}
+
+// keyedClaim returns the outcome of the logged claim with the same idempotency key as the claim to recipient.
+func (self *Faucet) keyedClaim(cr *faucet.ClaimRecord, recipient string) (faucet.Amount, int64, string, error) {
+	if cr.Recipient != recipient {
+		return 0, 0, "", faucet.ErrInvalidKey
+	}
+	switch cr.Status {
+	case faucet.ClaimSent:
+		return cr.Amount, cr.ID, hex.EncodeToString(cr.TX), nil
+	case faucet.ClaimPending:
+		return cr.Amount, cr.ID, "", nil
+	case faucet.ClaimSending:
+		if self.batching() {
+			return cr.Amount, cr.ID, "", nil
+		}
+		return 0, 0, "", faucet.SendError{Err: faucet.ErrClaimUnresolved}
+	}
+	return 0, 0, "", faucet.SendError{Err: faucet.ErrClaimFailed}
+}
+
+// logPending adds log record of a claim with the idempotency key.
+// If the record cannot be added because a concurrent request with the same key has added one, returns that record.
+func (self *Faucet) logPending(t time.Time, client net.IP, recipient string, amount faucet.Amount, key string, status faucet.ClaimStatus) (int64, *faucet.ClaimRecord, error) {
+	id, err := self.fdb.LogPendingClaim(t, client, recipient, amount, key, status)
+	if err != nil {
+		cr, kerr := self.fdb.ClaimByKey(key)
+		if kerr == nil && cr != nil {
+			return 0, cr, nil
+		}
+		self.dbFailed(err)
+		return 0, nil, err
+	}
+	return id, nil, nil
+}
+
+// setClaimStatus updates status of logged claims. Amounts of sent claims are added to dispensed coins metric.
+func (self *Faucet) setClaimStatus(cs []qClaim, status faucet.ClaimStatus, tx string) {
+	if status == faucet.ClaimSent {
+		for _, c := range cs {
+			self.dc.Add("", c.cr.Amount.Coins())
+		}
+	}
+	if self.fdb == nil {
+		return
+	}
+	var ids []int64
+	for _, c := range cs {
+		if c.cr.ID != 0 {
+			ids = append(ids, c.cr.ID)
+		}
+	}
+	var btx []byte
+	if status == faucet.ClaimSent {
+		var err error
+		btx, err = hex.DecodeString(tx)
+		if err != nil {
+			log.Printf("failed to decode transactin identifier %q: %v", tx, err)
+			btx = []byte{}
+		}
+	}
+	err := self.fdb.SetClaimStatus(ids, status, btx)
+	if err != nil {
+		log.Println("failed to update status of claims", ids, status, tx, err)
+		self.dbFailed(err)
+	}
+}
+
+// unresolve records claims sent in a request with the comment that failed with unknown outcome,
+// so that they are reconciled later. Their interval records are kept until then.
+func (self *Faucet) unresolve(cs []qClaim, t time.Time, comment string, err error) {
+	ids := make([]int64, len(cs))
+	for i, c := range cs {
+		ids[i] = c.cr.ID
+	}
+	if self.finder() == nil {
+		log.Println("outcome of sending claims", ids, "is unknown, check the wallet and pay manually if needed:", err)
+		return
+	}
+	log.Println("outcome of sending claims", ids, "is unknown, will look up the transaction:", err)
+	rcs := make(map[string]*uClaim)
+	var rs []string
+	for _, c := range cs {
+		u := rcs[c.cr.Recipient]
+		if u == nil {
+			u = &uClaim{
+				comment:   comment,
+				recipient: c.cr.Recipient,
+				t:         t,
+			}
+			rcs[c.cr.Recipient] = u
+			rs = append(rs, c.cr.Recipient)
+		}
+		u.amount += c.cr.Amount
+		u.cs = append(u.cs, c)
+	}
+	ur := &self.ur
+	ur.m.Lock()
+	defer ur.m.Unlock()
+	for _, r := range rs {
+		ur.us = append(ur.us, *rcs[r])
+	}
+}
+
+// resolve records the outcome of a claim found by reconciliation. Empty tx means that the claim was not sent.
+func (self *Faucet) resolve(u *uClaim, tx string) {
+	if len(tx) == 0 {
+		log.Println("claims to", u.recipient, "were not sent")
+		self.setClaimStatus(u.cs, faucet.ClaimFailed, "")
+		for _, c := range u.cs {
+			if len(c.ts) > 0 {
+				self.delIntervals(c.a, c.rr, c.ts)
+			}
+		}
+		return
+	}
+	log.Println("claims to", u.recipient, "were sent in transaction", tx)
+	self.setClaimStatus(u.cs, faucet.ClaimSent, tx)
+	t := Now()
+	for _, c := range u.cs {
+		self.ev.Publish(faucet.Event{
+			Kind:      faucet.EventClaim,
+			Time:      t,
+			Amount:    c.cr.Amount,
+			Recipient: TruncateRecipient(c.cr.Recipient),
+			TX:        tx,
+		})
+	}
+}
+
+// Reconcile looks up transactions of claims with unknown outcome in the wallet.
+// Claims are marked sent if the transaction is found, or failed if it is not found after ReconcileDelay;
+// interval records of failed claims are removed. Returns the number of claims that remain unresolved.
+func (self *Faucet) Reconcile(ctx context.Context) int {
+	f := self.finder()
+	if f == nil {
+		return 0
+	}
+	ur := &self.ur
+	ur.rm.Lock()
+	defer ur.rm.Unlock()
+	ur.m.Lock()
+	us := ur.us
+	ur.us = nil
+	ur.m.Unlock()
+	var keep []uClaim
+	for i := range us {
+		u := &us[i]
+		tx, err := self.findSent(ctx, f, u)
+		switch {
+		case err != nil:
+			log.Println("failed to look up transaction of claims to", u.recipient, err)
+			keep = append(keep, *u)
+		case len(tx) > 0 || Now().Sub(u.t) >= ReconcileDelay:
+			self.resolve(u, tx)
+		default:
+			keep = append(keep, *u)
+		}
+	}
+	ur.m.Lock()
+	defer ur.m.Unlock()
+	ur.us = append(keep, ur.us...)
+	n := 0
+	for _, u := range ur.us {
+		n += len(u.cs)
+	}
+	return n
+}
+
+// watchUnresolved periodically reconciles claims with unknown outcome until the faucet is closed.
+func (self *Faucet) watchUnresolved() {
+	t := time.NewTicker(ReconcileInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			self.Reconcile(context.Background())
+		case <-self.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,286 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package core_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	"faucet"
+	"faucet/core"
+)
+
+// lossyBank is bankMock that can fail send requests with unknown outcome and look up sent transactions.
+type lossyBank struct {
+	bankMock
+	fail, pay bool     // fail send requests, pay failed requests
+	drop      bool     // do not store comments
+	cms       []string // comments of transactions
+}
+
+func (self *lossyBank) Send(ctx context.Context, recipient string, amount faucet.Amount) (string, error) {
+	return self.SendManyComment(ctx, map[string]faucet.Amount{recipient: amount}, "")
+}
+
+func (self *lossyBank) SendMany(ctx context.Context, amounts map[string]faucet.Amount) (string, error) {
+	return self.SendManyComment(ctx, amounts, "")
+}
+
+func (self *lossyBank) SendComment(ctx context.Context, recipient string, amount faucet.Amount, comment string) (string, error) {
+	return self.SendManyComment(ctx, map[string]faucet.Amount{recipient: amount}, comment)
+}
+
+func (self *lossyBank) SendManyComment(ctx context.Context, amounts map[string]faucet.Amount, comment string) (string, error) {
+	if self.fail && !self.pay {
+		return "", errors.New("connection reset")
+	}
+	tx, err := self.bankMock.SendMany(ctx, amounts)
+	if err != nil {
+		return "", err
+	}
+	self.m.Lock()
+	if self.drop {
+		comment = ""
+	}
+	self.cms = append(self.cms, comment)
+	self.m.Unlock()
+	if self.fail {
+		return "", errors.New("connection reset")
+	}
+	return tx, nil
+}
+
+func (self *lossyBank) FindSent(ctx context.Context, recipient string, since time.Time) ([]faucet.SentTx, error) {
+	self.m.Lock()
+	defer self.m.Unlock()
+	var sts []faucet.SentTx
+	for i := len(self.txs) - 1; i >= 0; i-- {
+		if a, ok := self.txs[i][recipient]; ok {
+			sts = append(sts, faucet.SentTx{TX: fmt.Sprintf("%016x", i+1), Amount: a, Comment: self.cms[i]})
+		}
+	}
+	return sts, nil
+}
+
+func TestIdempotentClaim(t *testing.T) {
+	cfg := &core.FaucetConfig{
+		Amount:          faucet.Coin,
+		MinAmount:       faucet.Coin,
+		IPClaimInterval: time.Hour,
+	}
+	bank := &bankMock{bal: 100 * faucet.Coin}
+	db := new(fdbMock)
+	f, err := core.NewFaucet(cfg, nil, bank, db)
+	if err != nil {
+		t.Fatal("NewFaucet failed:", err)
+	}
+	defer f.Close()
+	ctx := context.Background()
+	req := &faucet.ClaimRequest{Client: "192.0.2.1", Recipient: "r1", Key: "k1"}
+	amt, id, tx, err := f.Claim(ctx, req)
+	if err != nil {
+		t.Fatal("Claim failed:", err)
+	}
+	for i := 0; i < 2; i++ {
+		amt2, id2, tx2, err := f.Claim(ctx, req)
+		if err != nil || amt2 != amt || id2 != id || tx2 != tx {
+			t.Error("repeated claim returned", amt2, id2, tx2, err, "want", amt, id, tx)
+		}
+	}
+	if len(bank.txs) != 1 {
+		t.Error("sent", len(bank.txs), "transactions, want 1")
+	}
+	for _, r := range []*faucet.ClaimRequest{
+		{Client: "192.0.2.1", Recipient: "r2", Key: "k1"},
+		{Client: "198.51.100.1", Recipient: "r2", Key: strings.Repeat("k", core.MaxKeyLength+1)},
+	} {
+		_, _, _, err = f.Claim(ctx, r)
+		if err != faucet.ErrInvalidKey {
+			t.Error("claim with key", r.Key, "returned", err)
+		}
+	}
+	_, _, _, err = f.Claim(ctx, &faucet.ClaimRequest{Client: "192.0.2.1", Recipient: "r2", Key: "k2"})
+	if _, ok := err.(faucet.MustWait); !ok {
+		t.Error("claim with new key returned", err)
+	}
+	// Claims without key get generated keys.
+	_, _, _, err = f.Claim(ctx, &faucet.ClaimRequest{Client: "198.51.100.1", Recipient: "r2"})
+	if err != nil {
+		t.Fatal("claim without key failed:", err)
+	}
+	checkClaims(t, db, []faucet.ClaimStatus{faucet.ClaimSent, faucet.ClaimSent}, []string{"0000000000000001", "0000000000000002"})
+	if len(db.keys) != 2 {
+		t.Error(len(db.keys), "logged keys, want 2")
+	}
+
+	// Keys cannot be honored without the claim log.
+	f, err = core.NewFaucet(cfg, nil, bank, nil)
+	if err != nil {
+		t.Fatal("NewFaucet without claim log failed:", err)
+	}
+	defer f.Close()
+	_, _, _, err = f.Claim(ctx, &faucet.ClaimRequest{Client: "203.0.113.1", Recipient: "r3", Key: "k3"})
+	if err != faucet.ErrNoClaimLog {
+		t.Error("keyed claim without claim log returned", err)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	tm := new(timeMock)
+	tm.set(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	core.Now = tm.get
+	defer resetNow()
+	cfg := &core.FaucetConfig{
+		Amount:          faucet.Coin,
+		MinAmount:       faucet.Coin,
+		IPClaimInterval: time.Hour,
+	}
+	bank := &lossyBank{bankMock: bankMock{bal: 100 * faucet.Coin}}
+	db := new(fdbMock)
+	f, err := core.NewFaucet(cfg, nil, bank, db)
+	if err != nil {
+		t.Fatal("NewFaucet failed:", err)
+	}
+	defer f.Close()
+	ctx := context.Background()
+
+	// The transaction is found right after the failure.
+	bank.fail, bank.pay = true, true
+	_, _, tx, err := f.Claim(ctx, &faucet.ClaimRequest{Client: "192.0.2.1", Recipient: "r1", Key: "k1"})
+	if err != nil || tx != "0000000000000001" {
+		t.Error("claim paid with unknown outcome returned", tx, err)
+	}
+
+	// The transaction is not found.
+	bank.pay = false
+	req := &faucet.ClaimRequest{Client: "198.51.100.1", Recipient: "r2", Key: "k2"}
+	_, _, _, err = f.Claim(ctx, req)
+	if _, ok := err.(faucet.SendError); !ok {
+		t.Fatal("claim with unknown outcome returned", err)
+	}
+	bank.fail = false
+	_, _, _, err = f.Claim(ctx, req)
+	if !errors.Is(err, faucet.ErrClaimUnresolved) {
+		t.Error("repeated claim with unknown outcome returned", err)
+	}
+	_, _, _, err = f.Claim(ctx, &faucet.ClaimRequest{Client: "198.51.100.1", Recipient: "r3"})
+	if _, ok := err.(faucet.MustWait); !ok {
+		t.Error("claim from client with unknown outcome returned", err)
+	}
+	if n := f.Reconcile(ctx); n != 1 {
+		t.Error("Reconcile before delay left", n, "unresolved claims, want 1")
+	}
+	tm.add(core.ReconcileDelay)
+	if n := f.Reconcile(ctx); n != 0 {
+		t.Error("Reconcile after delay left", n, "unresolved claims")
+	}
+	checkClaims(t, db, []faucet.ClaimStatus{faucet.ClaimSent, faucet.ClaimFailed}, []string{"0000000000000001", ""})
+	_, _, _, err = f.Claim(ctx, req)
+	if !errors.Is(err, faucet.ErrClaimFailed) {
+		t.Error("repeated failed claim returned", err)
+	}
+	_, _, tx, err = f.Claim(ctx, &faucet.ClaimRequest{Client: "198.51.100.1", Recipient: "r2", Key: "k3"})
+	if err != nil || tx != "0000000000000002" {
+		t.Error("claim after failure returned", tx, err)
+	}
+}
+
+func TestReconcileRestart(t *testing.T) {
+	tm := new(timeMock)
+	tm.set(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	core.Now = tm.get
+	defer resetNow()
+	cfg := &core.FaucetConfig{
+		Amount:    faucet.Coin,
+		MinAmount: faucet.Coin,
+	}
+	bank := &lossyBank{bankMock: bankMock{bal: 100 * faucet.Coin}}
+	// The transaction was sent by an earlier version without comment.
+	bank.SendMany(nil, map[string]faucet.Amount{"r1": faucet.Coin})
+	db := new(fdbMock)
+	for i, r := range []string{"r1", "r2"} {
+		db.LogPendingClaim(tm.get(), net.ParseIP("192.0.2.1"), r, faucet.Coin, fmt.Sprint("k", i), faucet.ClaimSending)
+	}
+	f, err := core.NewFaucet(cfg, nil, bank, db)
+	if err != nil {
+		t.Fatal("NewFaucet failed:", err)
+	}
+	defer f.Close()
+	ctx := context.Background()
+	if n := f.Reconcile(ctx); n != 1 {
+		t.Error("Reconcile left", n, "unresolved claims, want 1")
+	}
+	tm.add(core.ReconcileDelay)
+	if n := f.Reconcile(ctx); n != 0 {
+		t.Error("Reconcile after delay left", n, "unresolved claims")
+	}
+	checkClaims(t, db, []faucet.ClaimStatus{faucet.ClaimSent, faucet.ClaimFailed}, []string{"0000000000000001", ""})
+}
+
+func TestReconcileSameAmount(t *testing.T) {
+	tm := new(timeMock)
+	tm.set(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	core.Now = tm.get
+	defer resetNow()
+	cfg := &core.FaucetConfig{
+		Amount:          faucet.Coin,
+		MinAmount:       faucet.Coin,
+		IPClaimInterval: time.Hour,
+	}
+	cfg.Subnets.Depth = 1
+	bank := &lossyBank{bankMock: bankMock{bal: 100 * faucet.Coin}}
+	db := new(fdbMock)
+	f, err := core.NewFaucet(cfg, nil, bank, db)
+	if err != nil {
+		t.Fatal("NewFaucet failed:", err)
+	}
+	defer f.Close()
+	ctx := context.Background()
+	claim := func(client string) (string, error) {
+		_, _, tx, err := f.Claim(ctx, &faucet.ClaimRequest{Client: client, Recipient: "r"})
+		return tx, err
+	}
+
+	// Transactions of other claims to the same recipient and amount are not taken as payment.
+	if tx, err := claim("192.0.2.1"); err != nil || tx != "0000000000000001" {
+		t.Fatal("claim returned", tx, err)
+	}
+	bank.fail = true
+	if _, err = claim("192.0.2.2"); err == nil {
+		t.Error("unpaid claim with unknown outcome was accepted")
+	}
+	// Without comments, transactions recorded in the claim log are skipped.
+	bank.fail, bank.drop = false, true
+	if tx, err := claim("192.0.2.3"); err != nil || tx != "0000000000000002" {
+		t.Fatal("claim without comment returned", tx, err)
+	}
+	bank.fail = true
+	if _, err = claim("192.0.2.4"); err == nil {
+		t.Error("unpaid claim without comment was accepted")
+	}
+	if n := f.Reconcile(ctx); n != 2 {
+		t.Error("Reconcile left", n, "unresolved claims, want 2")
+	}
+	tm.add(core.ReconcileDelay)
+	if n := f.Reconcile(ctx); n != 0 {
+		t.Error("Reconcile after delay left", n, "unresolved claims")
+	}
+	// A paid claim without comment is still found by amount.
+	bank.pay = true
+	if tx, err := claim("192.0.2.5"); err != nil || tx != "0000000000000003" {
+		t.Error("paid claim without comment returned", tx, err)
+	}
+	checkClaims(t, db,
+		[]faucet.ClaimStatus{faucet.ClaimSent, faucet.ClaimFailed, faucet.ClaimSent, faucet.ClaimFailed, faucet.ClaimSent},
+		[]string{"0000000000000001", "", "0000000000000002", "", "0000000000000003"})
+	if bank.cms[0] != "faucet claims 1" {
+		t.Errorf("transaction comment %q, want %q", bank.cms[0], "faucet claims 1")
+	}
+}
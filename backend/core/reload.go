@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+import (
+	"faucet"
+)
+
+// settings are parameters of the faucet that are replaced together by Reload.
+type settings struct {
+	cfg     FaucetConfig
+	alerter faucet.Alerter
+	ws      []window
+}
+
+func (self *Faucet) settings() *settings { return self.st.Load().(*settings) }
+
+// conf returns configuration in effect.
+func (self *Faucet) conf() *FaucetConfig { return &self.settings().cfg }
+
+// alerter returns alerter in effect, or nil if there is none.
+func (self *Faucet) alerter() faucet.Alerter { return self.settings().alerter }
+
+// windows returns policy windows in effect.
+func (self *Faucet) windows() []window { return self.settings().ws }
+
+// startWatchWallet starts checking the wallet for alerts once they are configured.
+func (self *Faucet) startWatchWallet() {
+	if self.alerter() != nil && self.conf().Alerts.WalletDown > 0 {
+		self.mo.watch.Do(func() { go self.watchWallet() })
+	}
+}
+
+func restartNeeded(param string) {
+	log.Println("warning: change of", param, "requires restart, old value is kept")
+}
+
+// Reload replaces configuration and alerter of the running faucet. Records of recent claims and intervals are kept.
+// Parameters that cannot be changed without restart keep their values, and warnings about their changes are logged.
+// If the new configuration is invalid, it returns an error and nothing is changed.
+func (self *Faucet) Reload(cfg *FaucetConfig, alerter faucet.Alerter) error {
+	self.rm.Lock()
+	defer self.rm.Unlock()
+	old := self.conf()
+	nc := *cfg
+	if !bytes.Equal(nc.TokenKey, old.TokenKey) {
+		restartNeeded("tokenkey")
+		nc.TokenKey = old.TokenKey
+	}
+	if (nc.PoW.Difficulty > 0) != (old.PoW.Difficulty > 0) {
+		restartNeeded("pow/difficulty from or to 0")
+		nc.PoW = old.PoW
+	}
+	if (nc.Batch.Interval >= time.Second) != (old.Batch.Interval >= time.Second) {
+		restartNeeded("batch/interval from or to 0")
+		nc.Batch.Interval = old.Batch.Interval
+	}
+	if nc.Snapshot != old.Snapshot {
+		restartNeeded("snapshot")
+		nc.Snapshot = old.Snapshot
+	}
+	if nc.Tracking.Interval != old.Tracking.Interval {
+		restartNeeded("tracking/interval")
+		nc.Tracking.Interval = old.Tracking.Interval
+	}
+	if nc.IPRules != old.IPRules {
+		restartNeeded("iprules")
+		nc.IPRules = old.IPRules
+	}
+	if nc.PoW.Difficulty > MaxDifficulty || nc.PoW.RateDifficulty > MaxDifficulty {
+		return fmt.Errorf("proof-of-work difficulty must not exceed %v", MaxDifficulty)
+	}
+	err := checkSchedule(&nc)
+	if err != nil {
+		return err
+	}
+	rl, err := NewRateLimits(&nc)
+	if err != nil {
+		return err
+	}
+	self.rl.SetRateLimits(rl)
+	self.st.Store(&settings{cfg: nc, alerter: alerter, ws: rl.ws})
+	self.startWatchWallet()
+	if self.ev.Subscribers() > 0 {
+		go self.Amount(context.Background())
+	}
+	return nil
+}
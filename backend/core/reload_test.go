@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package core_test
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+import (
+	"faucet"
+	"faucet/core"
+)
+
+func TestReload(t *testing.T) {
+	tm := new(timeMock)
+	tm.set(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	core.Now = tm.get
+	defer resetNow()
+	cfg := &core.FaucetConfig{
+		Amount:          faucet.Coin,
+		MinAmount:       faucet.Coin,
+		IPClaimInterval: 24 * time.Hour,
+	}
+	cfg.Subnets.Depth = 1
+	f, err := core.NewFaucet(cfg, nil, &bankMock{bal: 100 * faucet.Coin}, nil)
+	if err != nil {
+		t.Fatal("NewFaucet failed:", err)
+	}
+	defer f.Close()
+	ctx := context.Background()
+	claim := func(client string) (faucet.Amount, error) {
+		a, _, _, err := f.Claim(ctx, &faucet.ClaimRequest{Client: client, Recipient: "r"})
+		return a, err
+	}
+	if a, err := claim("192.0.2.1"); err != nil || a != faucet.Coin {
+		t.Fatal("claim returned", a, err)
+	}
+
+	nc := *cfg
+	nc.Amount = 2 * faucet.Coin
+	nc.IPClaimInterval = time.Hour
+	// Proof-of-work cannot be enabled without restart.
+	nc.PoW.Difficulty = 10
+	err = f.Reload(&nc, nil)
+	if err != nil {
+		t.Fatal("Reload failed:", err)
+	}
+	if a, err := f.Amount(ctx); err != nil || a != 2*faucet.Coin {
+		t.Error("amount after reload", a, err)
+	}
+	// Intervals of earlier claims are kept.
+	if _, err := claim("192.0.2.1"); err == nil {
+		t.Error("claim during interval after reload was accepted")
+	}
+	if wt, _ := f.WaitTime(ctx, "192.0.2.1"); !wt.Equal(core.Now().Add(24 * time.Hour)) {
+		t.Error("wait time after reload", wt)
+	}
+	if a, err := claim("192.0.2.2"); err != nil || a != 2*faucet.Coin {
+		t.Error("claim after reload returned", a, err)
+	}
+	if wt, _ := f.WaitTime(ctx, "192.0.2.2"); !wt.Equal(core.Now().Add(time.Hour)) {
+		t.Error("wait time of claim after reload", wt)
+	}
+
+	bad := nc
+	bad.Amount = 3 * faucet.Coin
+	bad.Windows = []faucet.PolicyWindow{{}}
+	if err = f.Reload(&bad, nil); err == nil {
+		t.Error("invalid configuration was accepted")
+	}
+	if a, _ := f.Amount(ctx); a != 2*faucet.Coin {
+		t.Error("amount after failed reload", a)
+	}
+}
@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package core
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+import (
+	"faucet"
+)
+
+// scheduled returns amount of the curve at the level.
+func scheduled(ps []faucet.SchedulePoint, level faucet.Amount, tiered bool) faucet.Amount {
+	if level <= ps[0].Level {
+		return ps[0].Amount
+	}
+	for i := 1; i < len(ps); i++ {
+		p0, p1 := &ps[i-1], &ps[i]
+		if level >= p1.Level {
+			continue
+		}
+		if tiered {
+			return p0.Amount
+		}
+		d := float64(p1.Amount-p0.Amount) * float64(level-p0.Level) / float64(p1.Level-p0.Level)
+		return p0.Amount + faucet.Amount(math.Round(d))
+	}
+	return ps[len(ps)-1].Amount
+}
+
+// checkCurve checks that the curve has increasing levels and non-negative amounts.
+func checkCurve(ps []faucet.SchedulePoint) error {
+	for i := range ps {
+		if ps[i].Level < 0 || ps[i].Amount < 0 {
+			return errors.New("amount schedule must not have negative levels or amounts")
+		}
+		if i > 0 && ps[i].Level <= ps[i-1].Level {
+			return errors.New("levels of amount schedule must be increasing")
+		}
+	}
+	return nil
+}
+
+// checkSchedule checks that amount schedule of the configuration can be used.
+func checkSchedule(cfg *FaucetConfig) error {
+	s := &cfg.Schedule
+	if len(s.Rate) > 0 && (cfg.RateLimit.Amount <= 0 || cfg.RateLimit.Period < time.Second) {
+		return errors.New("rate schedule requires rate limit")
+	}
+	err := checkCurve(s.Balance)
+	if err != nil {
+		return err
+	}
+	return checkCurve(s.Rate)
+}
+
+func (self *Faucet) Schedule() *faucet.AmountSchedule {
+	cfg := self.conf()
+	if !cfg.Schedule.Configured() {
+		return nil
+	}
+	return &cfg.Schedule
+}
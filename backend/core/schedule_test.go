@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package core_test
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+import (
+	"faucet"
+	"faucet/core"
+)
+
+func TestBalanceSchedule(t *testing.T) {
+	cfg := &core.FaucetConfig{
+		Amount:    10 * faucet.Coin,
+		MinAmount: faucet.Coin,
+	}
+	cfg.Schedule.Balance = []faucet.SchedulePoint{
+		{Level: 10 * faucet.Coin, Amount: 0},
+		{Level: 100 * faucet.Coin, Amount: 5 * faucet.Coin},
+		{Level: 200 * faucet.Coin, Amount: 20 * faucet.Coin},
+	}
+	bank := &bankMock{}
+	f, err := core.NewFaucet(cfg, nil, bank, nil)
+	if err != nil {
+		t.Fatal("NewFaucet failed:", err)
+	}
+	defer f.Close()
+	if s := f.Schedule(); s == nil || len(s.Balance) != 3 {
+		t.Error("Schedule returned", s)
+	}
+	ctx := context.Background()
+	check := func(tiered bool, bal, want faucet.Amount) {
+		t.Helper()
+		bank.m.Lock()
+		bank.bal = bal
+		bank.m.Unlock()
+		a, err := f.Amount(ctx)
+		if err != nil {
+			t.Fatal("Amount failed:", err)
+		}
+		if a != want {
+			t.Errorf("tiered %v balance %v amount %v, want %v", tiered, bal, a, want)
+		}
+	}
+	for _, c := range []struct{ bal, amt faucet.Amount }{
+		{0, 0},
+		{10 * faucet.Coin, 0},
+		// Amounts below MinAmount are not paid.
+		{20 * faucet.Coin, 0},
+		{28 * faucet.Coin, faucet.Coin},
+		{55 * faucet.Coin, 5 * faucet.Coin / 2},
+		{100 * faucet.Coin, 5 * faucet.Coin},
+		{130 * faucet.Coin, 19 * faucet.Coin / 2},
+		// Amount is still limited by configured Amount.
+		{180 * faucet.Coin, 10 * faucet.Coin},
+		{1000 * faucet.Coin, 10 * faucet.Coin},
+	} {
+		check(false, c.bal, c.amt)
+	}
+	cfg.Schedule.Tiered = true
+	f, err = core.NewFaucet(cfg, nil, bank, nil)
+	if err != nil {
+		t.Fatal("NewFaucet failed:", err)
+	}
+	defer f.Close()
+	for _, c := range []struct{ bal, amt faucet.Amount }{
+		{55 * faucet.Coin, 0},
+		{100 * faucet.Coin, 5 * faucet.Coin},
+		{199 * faucet.Coin, 5 * faucet.Coin},
+		{200 * faucet.Coin, 10 * faucet.Coin},
+	} {
+		check(true, c.bal, c.amt)
+	}
+}
+
+func TestRateSchedule(t *testing.T) {
+	tm := new(timeMock)
+	tm.set(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	core.Now = tm.get
+	defer resetNow()
+	cfg := &core.FaucetConfig{
+		Amount:    4 * faucet.Coin,
+		MinAmount: faucet.Coin,
+	}
+	cfg.RateLimit.Amount = 20 * faucet.Coin
+	cfg.RateLimit.Period = time.Hour
+	cfg.Schedule.Rate = []faucet.SchedulePoint{
+		{Level: 8 * faucet.Coin, Amount: 4 * faucet.Coin},
+		{Level: 16 * faucet.Coin, Amount: 0},
+	}
+	f, err := core.NewFaucet(cfg, nil, &bankMock{bal: 1000 * faucet.Coin}, nil)
+	if err != nil {
+		t.Fatal("NewFaucet failed:", err)
+	}
+	defer f.Close()
+	ctx := context.Background()
+	// The amount decreases as claims add up during rate limit period.
+	for i, want := range []faucet.Amount{4, 4, 4, 2, 1, 0} {
+		a, _, _, err := f.Claim(ctx, &faucet.ClaimRequest{Client: "192.0.2.1", Recipient: "r"})
+		if want == 0 {
+			if err != faucet.ErrPaused {
+				t.Error("claim", i, "returned", err)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal("Claim failed:", err)
+		}
+		if a != want*faucet.Coin {
+			t.Error("claim", i, "amount", a, "want", want*faucet.Coin)
+		}
+		tm.add(time.Minute)
+	}
+	tm.add(time.Hour)
+	if a, _ := f.Amount(ctx); a != 4*faucet.Coin {
+		t.Error("amount after rate limit period", a)
+	}
+}
+
+func TestInvalidSchedule(t *testing.T) {
+	for i, s := range []faucet.AmountSchedule{
+		{Balance: []faucet.SchedulePoint{{Level: faucet.Coin}, {Level: faucet.Coin}}},
+		{Balance: []faucet.SchedulePoint{{Level: faucet.Coin, Amount: -1}}},
+		// Rate schedule requires rate limit.
+		{Rate: []faucet.SchedulePoint{{Level: faucet.Coin}}},
+	} {
+		cfg := &core.FaucetConfig{Amount: faucet.Coin, Schedule: s}
+		_, err := core.NewFaucet(cfg, nil, &bankMock{}, nil)
+		if err == nil {
+			t.Error("schedule", i, "was accepted")
+		}
+	}
+}
@@ -0,0 +1,244 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+import (
+	"faucet"
+)
+
+// DefSnapshotInterval is the default interval of saving snapshot.
+const DefSnapshotInterval = 5 * time.Minute
+
+// Snapshot file format: magic, version, then claim records, IP interval records and recipient interval records,
+// each section beginning with the number of records. Integers are varints, strings are prefixed with length,
+// times are Unix time in nanoseconds.
+const (
+	SnapshotVersion    = 1
+	snapshotMagic      = "FaucetRC"
+	maxSnapshotRecords = 1 << 30
+)
+
+// ErrSnapshotFormat is returned when a snapshot file is invalid or has unsupported version.
+var ErrSnapshotFormat = errors.New("invalid snapshot format")
+
+type snapshotWriter struct {
+	w   *bufio.Writer
+	b   [binary.MaxVarintLen64]byte
+	err error
+}
+
+func (self *snapshotWriter) int(x int64) {
+	if self.err == nil {
+		_, self.err = self.w.Write(self.b[:binary.PutVarint(self.b[:], x)])
+	}
+}
+
+func (self *snapshotWriter) string(s string) {
+	self.int(int64(len(s)))
+	if self.err == nil {
+		_, self.err = self.w.WriteString(s)
+	}
+}
+
+func (self *snapshotWriter) iSet(is *iSet) {
+	self.int(int64(len(is.m)))
+	for a, t := range is.m {
+		self.string(a)
+		self.int(t.UnixNano())
+	}
+}
+
+type snapshotReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (self *snapshotReader) int() int64 {
+	if self.err != nil {
+		return 0
+	}
+	var x int64
+	x, self.err = binary.ReadVarint(self.r)
+	return x
+}
+
+// count reads number of records and checks that it is plausible.
+func (self *snapshotReader) count() int {
+	n := self.int()
+	if n < 0 || n > maxSnapshotRecords {
+		self.err = ErrSnapshotFormat
+		return 0
+	}
+	return int(n)
+}
+
+func (self *snapshotReader) string() string {
+	l := self.int()
+	if self.err != nil {
+		return ""
+	}
+	if l < 0 || l > 256 {
+		self.err = ErrSnapshotFormat
+		return ""
+	}
+	b := make([]byte, l)
+	_, self.err = io.ReadFull(self.r, b)
+	return string(b)
+}
+
+func (self *snapshotReader) time() time.Time { return time.Unix(0, self.int()) }
+
+// WriteSnapshot writes claim and interval records in snapshot format.
+func (self *RCDB) WriteSnapshot(w io.Writer) error {
+	self.m.Lock()
+	defer self.m.Unlock()
+	ct := Now()
+	self.purgeClaims(ct.Add(-self.RatePeriod))
+	self.purgeIntervals(ct)
+	var h [len(snapshotMagic) + 2]byte
+	copy(h[:], snapshotMagic)
+	binary.BigEndian.PutUint16(h[len(snapshotMagic):], SnapshotVersion)
+	sw := &snapshotWriter{w: bufio.NewWriter(w)}
+	_, sw.err = sw.w.Write(h[:])
+	cs := self.cs[self.csp:]
+	sw.int(int64(len(cs)))
+	for _, c := range cs {
+		sw.int(c.t.UnixNano())
+		sw.int(int64(c.a))
+	}
+	sw.iSet(&self.is)
+	sw.iSet(&self.rs)
+	if sw.err == nil {
+		sw.err = sw.w.Flush()
+	}
+	return sw.err
+}
+
+// ReadSnapshot adds records from a snapshot written by WriteSnapshot. Expired records are skipped.
+func (self *RCDB) ReadSnapshot(r io.Reader) error {
+	sr := &snapshotReader{r: bufio.NewReader(r)}
+	var h [len(snapshotMagic) + 2]byte
+	_, err := io.ReadFull(sr.r, h[:])
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(h[:len(snapshotMagic)], []byte(snapshotMagic)) {
+		return ErrSnapshotFormat
+	}
+	if v := binary.BigEndian.Uint16(h[len(snapshotMagic):]); v != SnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %v", v)
+	}
+	var (
+		cs     cRecords
+		is, rs []iRecord
+	)
+	for n := sr.count(); n > 0 && sr.err == nil; n-- {
+		t := sr.time()
+		a := faucet.Amount(sr.int())
+		cs = append(cs, cRecord{a: a, t: t})
+	}
+	for _, p := range []*[]iRecord{&is, &rs} {
+		for n := sr.count(); n > 0 && sr.err == nil; n-- {
+			a := sr.string()
+			t := sr.time()
+			*p = append(*p, iRecord{a: a, t: t})
+		}
+	}
+	if sr.err == io.EOF {
+		sr.err = io.ErrUnexpectedEOF
+	}
+	if sr.err != nil {
+		return sr.err
+	}
+	self.m.Lock()
+	defer self.m.Unlock()
+	ct := Now()
+	rt := ct.Add(-self.RatePeriod)
+	self.purgeClaims(rt)
+	self.compactClaims()
+	self.purgeIntervals(ct)
+	for _, c := range cs {
+		if rt.Before(c.t) {
+			self.cs = append(self.cs, c)
+			self.ta += c.a
+		}
+	}
+	for _, r := range is {
+		if !ct.After(r.t) {
+			self.is.set(r.a, r.t)
+		}
+	}
+	for _, r := range rs {
+		if !ct.After(r.t) {
+			self.rs.set(r.a, r.t)
+		}
+	}
+	sort.Sort(self.cs)
+	self.is.rebuild()
+	self.rs.rebuild()
+	return nil
+}
+
+// SaveSnapshot writes a snapshot to the file. The snapshot is written to a temporary file in the same directory,
+// which then replaces the file, so the file contains either previous or new complete snapshot.
+func (self *RCDB) SaveSnapshot(path string) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if f != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	err = self.WriteSnapshot(f)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if err != nil {
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return err
+	}
+	f = nil
+	return nil
+}
+
+// LoadSnapshot adds records from the snapshot file. It is not an error if the file does not exist.
+func (self *RCDB) LoadSnapshot(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = self.ReadSnapshot(f)
+	if err != nil {
+		return fmt.Errorf("%v: %w", path, err)
+	}
+	return nil
+}
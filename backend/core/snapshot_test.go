@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package core_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+import (
+	"faucet"
+	"faucet/core"
+)
+
+func TestSnapshot(t *testing.T) {
+	tm := new(timeMock)
+	tm.set(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	core.Now = tm.get
+	defer resetNow()
+	newDB := func() *core.RCDB {
+		return &core.RCDB{RateLimits: core.RateLimits{
+			IPClaimInterval:        time.Hour,
+			RatePeriod:             time.Hour,
+			RecipientClaimInterval: 2 * time.Hour,
+		}}
+	}
+	db := newDB()
+	a1 := [8]byte{1, 2, 3, 4, 5, 6, 7, 8}
+	db.CheckAddIntervals(a1, "r1")
+	db.AddClaim(tm.get(), faucet.Coin)
+	tm.add(30 * time.Minute)
+	db.AddClaim(tm.get(), 2*faucet.Coin)
+
+	var b bytes.Buffer
+	err := db.WriteSnapshot(&b)
+	if err != nil {
+		t.Fatal("WriteSnapshot failed:", err)
+	}
+	sb := b.Bytes()
+	db2 := newDB()
+	err = db2.ReadSnapshot(bytes.NewReader(sb))
+	if err != nil {
+		t.Fatal("ReadSnapshot failed:", err)
+	}
+	if pt := db2.PeriodTotal(); pt != 3*faucet.Coin {
+		t.Error("period total", pt, "want", 3*faucet.Coin)
+	}
+	if ic := db2.IntervalCount(); ic != db.IntervalCount() {
+		t.Error("interval count", ic, "want", db.IntervalCount())
+	}
+	if it, it0 := db2.CheckInterval(a1), db.CheckInterval(a1); !it.Equal(it0) {
+		t.Error("interval", it, "want", it0)
+	}
+	if rt := db2.CheckRecipientInterval("r1"); !rt.Equal(tm.get().Add(90 * time.Minute)) {
+		t.Error("recipient interval", rt)
+	}
+
+	tm.add(time.Hour)
+	db2 = newDB()
+	err = db2.ReadSnapshot(bytes.NewReader(sb))
+	if err != nil {
+		t.Fatal("ReadSnapshot failed:", err)
+	}
+	if pt := db2.PeriodTotal(); pt != 0 {
+		t.Error("period total of expired claims", pt)
+	}
+	if ic := db2.IntervalCount(); ic != 1 {
+		t.Error("interval count after IP interval expired", ic, "want 1")
+	}
+
+	for i := 0; i < len(sb); i++ {
+		err = newDB().ReadSnapshot(bytes.NewReader(sb[:i]))
+		if err == nil {
+			t.Fatal("ReadSnapshot accepted truncated snapshot of length", i)
+		}
+	}
+	sb[len("FaucetRC")+1]++
+	if err = newDB().ReadSnapshot(bytes.NewReader(sb)); err == nil {
+		t.Error("ReadSnapshot accepted unsupported version")
+	}
+}
+
+func TestSnapshotRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal("TempDir failed:", err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &core.FaucetConfig{
+		Amount:          faucet.Coin,
+		MinAmount:       faucet.Coin,
+		IPClaimInterval: time.Hour,
+	}
+	cfg.Snapshot.File = filepath.Join(dir, "rcdb")
+	bank := &bankMock{bal: 100 * faucet.Coin}
+	ctx := context.Background()
+	f, err := core.NewFaucet(cfg, nil, bank, nil)
+	if err != nil {
+		t.Fatal("NewFaucet failed:", err)
+	}
+	_, _, _, err = f.Claim(ctx, &faucet.ClaimRequest{Client: "192.0.2.1", Recipient: "r"})
+	if err != nil {
+		t.Fatal("Claim failed:", err)
+	}
+	f.Close()
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal("ReadDir failed:", err)
+	}
+	if len(fis) != 1 {
+		t.Error("files in snapshot directory:", len(fis), "want 1")
+	}
+
+	f, err = core.NewFaucet(cfg, nil, bank, nil)
+	if err != nil {
+		t.Fatal("NewFaucet after restart failed:", err)
+	}
+	defer f.Close()
+	_, _, _, err = f.Claim(ctx, &faucet.ClaimRequest{Client: "192.0.2.1", Recipient: "r"})
+	if _, ok := err.(faucet.MustWait); !ok {
+		t.Error("claim after restart returned", err)
+	}
+
+	err = ioutil.WriteFile(cfg.Snapshot.File, []byte("garbage"), 0644)
+	if err != nil {
+		t.Fatal("WriteFile failed:", err)
+	}
+	_, err = core.NewFaucet(cfg, nil, bank, nil)
+	if err == nil {
+		t.Error("NewFaucet accepted invalid snapshot")
+	}
+}
@@ -0,0 +1,162 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package core
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+import (
+	"faucet"
+)
+
+// Defaults of transaction tracking configuration.
+const (
+	DefTrackConfirmations = 6
+	DefTrackPeriod        = 24 * time.Hour
+	DefTrackStuck         = time.Hour
+)
+
+// tracker holds state of transaction tracking between rounds.
+type tracker struct {
+	m     sync.Mutex // serializes rounds
+	stuck map[string]bool
+}
+
+// tracking returns whether confirmations of sent transactions are tracked.
+func (self *Faucet) tracking() bool { return self.conf().Tracking.Interval >= time.Second }
+
+// checker returns the bank as TxChecker, or nil if it cannot report the state of transactions.
+func (self *Faucet) checker() faucet.TxChecker {
+	c, _ := self.bank.(faucet.TxChecker)
+	return c
+}
+
+// trackCfg returns transaction tracking parameters with defaults applied.
+func (self *Faucet) trackCfg() (confirmations int, period, stuck time.Duration) {
+	c := &self.conf().Tracking
+	confirmations, period, stuck = c.Confirmations, c.Period, c.Stuck
+	if confirmations <= 0 {
+		confirmations = DefTrackConfirmations
+	}
+	if period <= 0 {
+		period = DefTrackPeriod
+	}
+	if stuck <= 0 {
+		stuck = DefTrackStuck
+	}
+	return
+}
+
+// txState returns the state of a transaction reported by the wallet.
+func txState(ti *faucet.TxInfo, confirmations int) faucet.TxState {
+	switch {
+	case ti.Abandoned:
+		return faucet.TxAbandoned
+	case ti.Confirmations < 0:
+		return faucet.TxConflicted
+	case ti.Confirmations >= confirmations:
+		return faucet.TxConfirmed
+	}
+	return faucet.TxUnconfirmed
+}
+
+func (self *Faucet) txAlert(tx string, state faucet.TxState, sent time.Time) {
+	if self.alerter() != nil {
+		self.alerter().TxAlert(tx, state, sent)
+	}
+}
+
+// TrackTxs checks the state of unconfirmed transactions sent during tracking period and records changes in the claim log.
+// Sends alerts about transactions that are double-spent or abandoned, and once about each transaction
+// that stays unconfirmed longer than the stuck time. Returns the number of transactions that remain unconfirmed.
+func (self *Faucet) TrackTxs(ctx context.Context) int {
+	c := self.checker()
+	if c == nil || self.fdb == nil {
+		return 0
+	}
+	tr := &self.tr
+	tr.m.Lock()
+	defer tr.m.Unlock()
+	need, period, stuck := self.trackCfg()
+	crs, err := self.fdb.UnconfirmedClaims(Now().Add(-period))
+	if err != nil {
+		log.Println("failed to get unconfirmed claims:", err)
+		return 0
+	}
+	// Records are ordered from oldest, so the first record of a transaction tells when it was sent.
+	var txs []*faucet.ClaimRecord
+	seen := make(map[string]bool)
+	for i := range crs {
+		tx := string(crs[i].TX)
+		if len(tx) > 0 && !seen[tx] {
+			seen[tx] = true
+			txs = append(txs, &crs[i])
+		}
+	}
+	n := 0
+	st := make(map[string]bool)
+	for _, cr := range txs {
+		tx := hex.EncodeToString(cr.TX)
+		ti, err := c.CheckTx(ctx, tx)
+		if err != nil {
+			log.Println("failed to check transaction", tx, err)
+			n++
+			st[tx] = tr.stuck[tx]
+			continue
+		}
+		s := txState(ti, need)
+		if s != faucet.TxUnconfirmed || ti.Confirmations != cr.Confirmations {
+			err = self.fdb.SetTxState(cr.TX, s, ti.Confirmations)
+			if err != nil {
+				log.Println("failed to record state of transaction", tx, s, err)
+				self.dbFailed(err)
+			}
+		}
+		switch s {
+		case faucet.TxUnconfirmed:
+			n++
+			st[tx] = tr.stuck[tx]
+			if !st[tx] && Now().Sub(cr.Time) >= stuck {
+				log.Println("transaction", tx, "is not confirmed since", cr.Time)
+				self.txAlert(tx, s, cr.Time)
+				st[tx] = true
+			}
+		case faucet.TxConflicted, faucet.TxAbandoned:
+			log.Println("transaction", tx, "is", s)
+			self.txAlert(tx, s, cr.Time)
+		}
+	}
+	tr.stuck = st
+	return n
+}
+
+// watchTxs periodically tracks sent transactions until the faucet is closed.
+func (self *Faucet) watchTxs() {
+	t := time.NewTicker(self.conf().Tracking.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			self.TrackTxs(context.Background())
+		case <-self.done:
+			return
+		}
+	}
+}
+
+// initTracking checks that transaction tracking can be done.
+func (self *Faucet) initTracking() error {
+	if self.fdb == nil {
+		return errors.New("tracking transactions requires database")
+	}
+	if self.checker() == nil {
+		return errors.New("tracking transactions requires a bank that can check transactions")
+	}
+	return nil
+}
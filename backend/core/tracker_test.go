@@ -0,0 +1,186 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package core_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	"faucet"
+	"faucet/core"
+	"faucet/rpc"
+)
+
+// walletMock is a fake Dogecoin Core wallet RPC server that reports states of sent transactions set by the test.
+type walletMock struct {
+	m   sync.Mutex
+	txs map[string]*faucet.TxInfo
+}
+
+func (self *walletMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+		ID     uint32            `json:"id"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	self.m.Lock()
+	defer self.m.Unlock()
+	var res interface{}
+	switch req.Method {
+	case "getbalance":
+		res = 100
+	case "sendtoaddress":
+		tx := fmt.Sprintf("%064x", len(self.txs)+1)
+		self.txs[tx] = new(faucet.TxInfo)
+		res = tx
+	case "gettransaction":
+		var tx string
+		json.Unmarshal(req.Params[0], &tx)
+		ti := self.txs[tx]
+		if ti == nil {
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": -5, "message": "Invalid or non-wallet transaction id"}, "id": req.ID})
+			return
+		}
+		res = map[string]interface{}{
+			"confirmations": ti.Confirmations,
+			"details":       []map[string]interface{}{{"abandoned": ti.Abandoned, "category": "send"}},
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"result": res, "id": req.ID})
+}
+
+func (self *walletMock) set(tx string, confirmations int, abandoned bool) {
+	self.m.Lock()
+	defer self.m.Unlock()
+	self.txs[tx] = &faucet.TxInfo{Confirmations: confirmations, Abandoned: abandoned}
+}
+
+// txAlert is an alert sent by TxAlert.
+type txAlert struct {
+	tx    string
+	state faucet.TxState
+}
+
+// alerterMock records transaction alerts in txs and kinds of other alerts in kinds.
+type alerterMock struct {
+	m     sync.Mutex
+	txs   []txAlert
+	kinds []string
+}
+
+func (self *alerterMock) add(kind string) {
+	self.m.Lock()
+	defer self.m.Unlock()
+	self.kinds = append(self.kinds, kind)
+}
+
+func (self *alerterMock) BalanceAlert(balance faucet.Amount)                   { self.add("balance") }
+func (self *alerterMock) BalanceRecovered(balance faucet.Amount)               { self.add("balance-recovered") }
+func (self *alerterMock) DBAlert(err error)                                    { self.add("db") }
+func (self *alerterMock) RateAlert(amount faucet.Amount, period time.Duration) { self.add("rate") }
+func (self *alerterMock) SendAlert(n int, err error)                           { self.add(fmt.Sprint("send ", n)) }
+func (self *alerterMock) TokenAlert(n int, period time.Duration)               { self.add(fmt.Sprint("token ", n)) }
+func (self *alerterMock) WalletAlert(since time.Time, err error)               { self.add("wallet") }
+func (self *alerterMock) RateRecovered(amount faucet.Amount, period time.Duration) {
+	self.add("rate-recovered")
+}
+
+func (self *alerterMock) TxAlert(tx string, state faucet.TxState, sent time.Time) {
+	self.m.Lock()
+	defer self.m.Unlock()
+	self.txs = append(self.txs, txAlert{tx, state})
+}
+
+// take returns alerts sent since the previous call.
+func (self *alerterMock) take() []txAlert {
+	self.m.Lock()
+	defer self.m.Unlock()
+	txs := self.txs
+	self.txs = nil
+	return txs
+}
+
+func TestTrackTxs(t *testing.T) {
+	tm := new(timeMock)
+	tm.set(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	core.Now = tm.get
+	defer resetNow()
+	wm := &walletMock{txs: make(map[string]*faucet.TxInfo)}
+	srv := httptest.NewServer(wm)
+	defer srv.Close()
+	bank, err := rpc.NewRPCClient(&rpc.RPCConfig{RPCEndpoint: rpc.RPCEndpoint{URL: srv.URL}})
+	if err != nil {
+		t.Fatal("NewRPCClient failed:", err)
+	}
+	cfg := &core.FaucetConfig{
+		Amount:    faucet.Coin,
+		MinAmount: faucet.Coin,
+	}
+	cfg.Tracking.Interval = time.Hour
+	cfg.Tracking.Confirmations = 2
+	cfg.Tracking.Stuck = 30 * time.Minute
+	al := new(alerterMock)
+	db := new(fdbMock)
+	f, err := core.NewFaucet(cfg, al, bank, db)
+	if err != nil {
+		t.Fatal("NewFaucet failed:", err)
+	}
+	defer f.Close()
+	ctx := context.Background()
+	var txs []string
+	for _, r := range []string{"r1", "r2", "r3"} {
+		_, _, tx, err := f.Claim(ctx, &faucet.ClaimRequest{Client: "192.0.2.1", Recipient: r})
+		if err != nil {
+			t.Fatal("Claim failed:", err)
+		}
+		txs = append(txs, tx)
+	}
+	check := func(n int, as []txAlert) {
+		t.Helper()
+		if m := f.TrackTxs(ctx); m != n {
+			t.Error("TrackTxs left", m, "unconfirmed transactions, want", n)
+		}
+		if a := al.take(); fmt.Sprint(a) != fmt.Sprint(as) {
+			t.Error("sent alerts", a, "want", as)
+		}
+	}
+	check(3, nil)
+
+	wm.set(txs[0], 1, false)
+	wm.set(txs[1], -1, false)
+	wm.set(txs[2], 0, true)
+	check(1, []txAlert{{txs[1], faucet.TxConflicted}, {txs[2], faucet.TxAbandoned}})
+	// Conflicted and abandoned transactions are not checked again.
+	check(1, nil)
+
+	tm.add(time.Hour)
+	check(1, []txAlert{{txs[0], faucet.TxUnconfirmed}})
+	// Stuck alert is sent once.
+	check(1, nil)
+
+	wm.set(txs[0], 2, false)
+	check(0, nil)
+
+	for i, s := range []faucet.TxState{faucet.TxConfirmed, faucet.TxConflicted, faucet.TxAbandoned} {
+		crs, err := f.ClaimsByTX(ctx, txs[i])
+		if err != nil || len(crs) != 1 || crs[0].TxState != s {
+			t.Errorf("ClaimsByTX(%v) returned %+v %v, want state %v", txs[i], crs, err, s)
+		}
+	}
+	if crs, err := f.ClaimsByTX(ctx, fmt.Sprintf("%064x", 4)); err != faucet.ErrNoClaim {
+		t.Error("ClaimsByTX of unknown transaction returned", crs, err)
+	}
+}
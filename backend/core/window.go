@@ -0,0 +1,198 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+import (
+	"faucet"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// window is a parsed policy window.
+type window struct {
+	*faucet.PolicyWindow
+	days       uint8 // bit mask of weekdays, zero means every day
+	start, end int   // minutes of day; equal values mean all day
+}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	d, ok := weekdays[strings.ToLower(strings.TrimSpace(s))]
+	if !ok {
+		return 0, fmt.Errorf("invalid day of week %q", s)
+	}
+	return d, nil
+}
+
+// parseDays parses days of week and ranges of them, like "sat" or "mon-fri".
+func parseDays(ds []string) (uint8, error) {
+	var m uint8
+	for _, s := range ds {
+		i := strings.IndexByte(s, '-')
+		if i < 0 {
+			d, err := parseWeekday(s)
+			if err != nil {
+				return 0, err
+			}
+			m |= 1 << d
+			continue
+		}
+		d1, err := parseWeekday(s[:i])
+		if err != nil {
+			return 0, err
+		}
+		d2, err := parseWeekday(s[i+1:])
+		if err != nil {
+			return 0, err
+		}
+		for d := d1; ; d = (d + 1) % 7 {
+			m |= 1 << d
+			if d == d2 {
+				break
+			}
+		}
+	}
+	return m, nil
+}
+
+// parseHours parses time of day range like "09:00-17:00" and returns minutes of day.
+func parseHours(s string) (start, end int, err error) {
+	if len(s) == 0 {
+		return
+	}
+	ps := strings.Split(s, "-")
+	if len(ps) != 2 {
+		return 0, 0, fmt.Errorf("invalid hours %q", s)
+	}
+	var ms [2]int
+	for i, p := range ps {
+		t, err := time.Parse("15:04", strings.TrimSpace(p))
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid hours %q", s)
+		}
+		ms[i] = t.Hour()*60 + t.Minute()
+	}
+	if ms[0] == ms[1] {
+		return 0, 0, fmt.Errorf("hours %q are empty", s)
+	}
+	return ms[0], ms[1], nil
+}
+
+// parseWindows checks policy windows and prepares them for matching.
+func parseWindows(ws []faucet.PolicyWindow) ([]window, error) {
+	var res []window
+	for i := range ws {
+		pw := &ws[i]
+		if len(pw.Name) == 0 {
+			return nil, errors.New("policy window must have a name")
+		}
+		if !pw.To.IsZero() && !pw.From.Before(pw.To) {
+			return nil, fmt.Errorf("policy window %v ends before it starts", pw.Name)
+		}
+		if pw.Amount < 0 || pw.IPClaimInterval < 0 || pw.RateLimit.Amount < 0 {
+			return nil, fmt.Errorf("policy window %v must not have negative values", pw.Name)
+		}
+		w := window{PolicyWindow: pw}
+		var err error
+		w.days, err = parseDays(pw.Days)
+		if err == nil {
+			w.start, w.end, err = parseHours(pw.Hours)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("policy window %v: %w", pw.Name, err)
+		}
+		res = append(res, w)
+	}
+	return res, nil
+}
+
+// active returns whether the window is active at the time.
+func (self *window) active(t time.Time) bool {
+	if t.Before(self.From) || (!self.To.IsZero() && !t.Before(self.To)) {
+		return false
+	}
+	t = t.UTC()
+	d := t.Weekday()
+	m := t.Hour()*60 + t.Minute()
+	switch {
+	case self.start < self.end:
+		if m < self.start || m >= self.end {
+			return false
+		}
+	case self.start > self.end:
+		if m < self.end {
+			// Hours that cross midnight belong to the day when they start.
+			d = (d + 6) % 7
+		} else if m < self.start {
+			return false
+		}
+	}
+	return self.days == 0 || self.days&(1<<d) != 0
+}
+
+// activeWindow returns the first of the windows that is active at the time, or nil if there is none.
+func activeWindow(ws []window, t time.Time) *window {
+	for i := range ws {
+		if ws[i].active(t) {
+			return &ws[i]
+		}
+	}
+	return nil
+}
+
+// policy returns giveaway amount and rate limit amount in effect at the time, and whether giveaway is paused by a policy window.
+func (self *settings) policy(t time.Time) (amount, rate faucet.Amount, pause bool) {
+	amount, rate = self.cfg.Amount, self.cfg.RateLimit.Amount
+	w := activeWindow(self.ws, t)
+	if w == nil {
+		return
+	}
+	if w.Amount > 0 {
+		amount = w.Amount
+	}
+	if w.RateLimit.Amount > 0 {
+		rate = w.RateLimit.Amount
+	}
+	return amount, rate, w.Pause
+}
+
+// ipInterval returns the interval between claims from the same IP address in effect at the time.
+func (self *settings) ipInterval(t time.Time) time.Duration {
+	if w := activeWindow(self.ws, t); w != nil && w.IPClaimInterval > 0 {
+		return w.IPClaimInterval
+	}
+	return self.cfg.IPClaimInterval
+}
+
+// maxIPInterval returns the longest interval between claims from the same IP address in the configuration and windows.
+func (self *settings) maxIPInterval() time.Duration {
+	iv := self.cfg.IPClaimInterval
+	for i := range self.ws {
+		if iv < self.ws[i].IPClaimInterval {
+			iv = self.ws[i].IPClaimInterval
+		}
+	}
+	return iv
+}
+
+func (self *Faucet) Window() *faucet.PolicyWindow {
+	w := activeWindow(self.windows(), Now())
+	if w == nil {
+		return nil
+	}
+	return w.PolicyWindow
+}
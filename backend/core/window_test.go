@@ -0,0 +1,139 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package core_test
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+import (
+	"faucet"
+	"faucet/core"
+)
+
+func TestPolicyWindows(t *testing.T) {
+	tm := new(timeMock)
+	core.Now = tm.get
+	defer resetNow()
+	cfg := &core.FaucetConfig{
+		Amount:          faucet.Coin,
+		MinAmount:       faucet.Coin,
+		IPClaimInterval: 24 * time.Hour,
+		Windows: []faucet.PolicyWindow{
+			{
+				Name:            "hackathon",
+				From:            time.Date(2020, 1, 3, 9, 0, 0, 0, time.UTC),
+				To:              time.Date(2020, 1, 5, 18, 0, 0, 0, time.UTC),
+				Amount:          5 * faucet.Coin,
+				IPClaimInterval: time.Hour,
+			},
+			{
+				Name:  "maintenance",
+				Days:  []string{"sat-sun"},
+				Hours: "23:00-01:00",
+				Pause: true,
+			},
+		},
+	}
+	// Only intervals of single addresses are enforced.
+	cfg.Subnets.Depth = 1
+	f, err := core.NewFaucet(cfg, nil, &bankMock{bal: 100 * faucet.Coin}, nil)
+	if err != nil {
+		t.Fatal("NewFaucet failed:", err)
+	}
+	defer f.Close()
+	ctx := context.Background()
+	for i, c := range []struct {
+		t      time.Time
+		client string
+		window string
+		amount faucet.Amount
+		err    error
+		wait   time.Duration
+	}{
+		// Wednesday.
+		{time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC), "192.0.2.1", "", faucet.Coin, nil, 24 * time.Hour},
+		{time.Date(2020, 1, 3, 8, 59, 0, 0, time.UTC), "192.0.2.2", "", faucet.Coin, nil, 24 * time.Hour},
+		{time.Date(2020, 1, 3, 9, 0, 0, 0, time.UTC), "192.0.2.3", "hackathon", 5 * faucet.Coin, nil, time.Hour},
+		// The first window that is active applies.
+		{time.Date(2020, 1, 4, 23, 30, 0, 0, time.UTC), "192.0.2.4", "hackathon", 5 * faucet.Coin, nil, time.Hour},
+		// Maintenance hours that start on Sunday end on Monday.
+		{time.Date(2020, 1, 5, 23, 0, 0, 0, time.UTC), "192.0.2.5", "maintenance", 0, faucet.ErrPaused, 0},
+		{time.Date(2020, 1, 6, 0, 59, 0, 0, time.UTC), "192.0.2.5", "maintenance", 0, faucet.ErrPaused, 0},
+		{time.Date(2020, 1, 6, 1, 0, 0, 0, time.UTC), "192.0.2.5", "", faucet.Coin, nil, 24 * time.Hour},
+		{time.Date(2020, 1, 6, 23, 0, 0, 0, time.UTC), "192.0.2.6", "", faucet.Coin, nil, 24 * time.Hour},
+	} {
+		tm.set(c.t)
+		w := f.Window()
+		if (w == nil && len(c.window) > 0) || (w != nil && w.Name != c.window) {
+			t.Errorf("case %v window %+v, want %q", i, w, c.window)
+		}
+		if a, err := f.Amount(ctx); err != nil || a != c.amount {
+			t.Errorf("case %v amount %v, %v, want %v", i, a, err, c.amount)
+		}
+		a, _, _, err := f.Claim(ctx, &faucet.ClaimRequest{Client: c.client, Recipient: "r"})
+		if err != c.err || (err == nil && a != c.amount) {
+			t.Errorf("case %v claim returned %v, %v", i, a, err)
+		}
+		wt, err := f.WaitTime(ctx, c.client)
+		if err != nil {
+			t.Fatal("WaitTime failed:", err)
+		}
+		if want := c.t.Add(c.wait); c.wait > 0 && !wt.Equal(want) {
+			t.Errorf("case %v wait time %v, want %v", i, wt, want)
+		}
+	}
+}
+
+func TestWindowInterval(t *testing.T) {
+	tm := new(timeMock)
+	tm.set(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+	core.Now = tm.get
+	defer resetNow()
+	// The window enforces an interval on a faucet that has none.
+	cfg := &core.FaucetConfig{
+		Amount:    faucet.Coin,
+		MinAmount: faucet.Coin,
+		Windows:   []faucet.PolicyWindow{{Name: "hackathon", IPClaimInterval: time.Hour}},
+	}
+	cfg.Subnets.Depth = 1
+	f, err := core.NewFaucet(cfg, nil, &bankMock{bal: 100 * faucet.Coin}, nil)
+	if err != nil {
+		t.Fatal("NewFaucet failed:", err)
+	}
+	defer f.Close()
+	ctx := context.Background()
+	req := &faucet.ClaimRequest{Client: "192.0.2.1", Recipient: "r"}
+	if _, _, _, err = f.Claim(ctx, req); err != nil {
+		t.Fatal("claim failed:", err)
+	}
+	want := core.Now().Add(time.Hour)
+	_, _, _, err = f.Claim(ctx, req)
+	if mw, ok := err.(faucet.MustWait); !ok || !mw.Until.Equal(want) {
+		t.Error("repeated claim returned", err, "want to wait until", want)
+	}
+	if wt, err := f.WaitTime(ctx, req.Client); err != nil || !wt.Equal(want) {
+		t.Error("wait time", wt, err, "want", want)
+	}
+}
+
+func TestInvalidWindows(t *testing.T) {
+	for i, w := range []faucet.PolicyWindow{
+		{},
+		{Name: "w", From: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC), To: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Name: "w", Days: []string{"monday"}},
+		{Name: "w", Days: []string{"mon-"}},
+		{Name: "w", Hours: "9-17"},
+		{Name: "w", Hours: "09:00"},
+		{Name: "w", Hours: "09:00-09:00"},
+		{Name: "w", Amount: -1},
+	} {
+		cfg := &core.FaucetConfig{Amount: faucet.Coin, Windows: []faucet.PolicyWindow{w}}
+		_, err := core.NewFaucet(cfg, nil, &bankMock{}, nil)
+		if err == nil {
+			t.Error("window", i, "was accepted")
+		}
+	}
+}
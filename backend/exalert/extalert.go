@@ -12,56 +12,103 @@ import (
 	"time"
 )
 
+import (
+	"faucet"
+	"faucet/alert"
+)
+
 type ExAlerterConfig struct{ AlertProgram string }
 
 func (self *ExAlerterConfig) Configured() bool { return len(self.AlertProgram) > 0 }
 
 // ExAlerter implements Alerter interface.
+// The program is executed with alert kind as the first argument, followed by alert parameters.
+// After successful execution, further alerts of the same kind are ignored according to alert.DebounceConfig.
 type ExAlerter struct {
-	m  sync.Mutex
-	p  string
-	rt time.Time
+	d alert.Debounce
+	m sync.Mutex
+	p string
 }
 
-// BalanceAlert executes the program with arguments "balance" and the balance.
-// For example, given balance 1000:
-//  program balance 1000
-func (self *ExAlerter) BalanceAlert(balance float64) {
+func seconds(d time.Duration) string { return strconv.FormatInt(int64(d/time.Second), 10) }
+
+func errText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// run executes the program with alert kind and the arguments.
+func (self *ExAlerter) run(a *alert.Alert, args ...string) {
 	self.m.Lock()
 	defer self.m.Unlock()
-	c := exec.Command(self.p, "balance", strconv.FormatFloat(balance, 'f', -1, 64))
+	if self.d.Suppress(a) {
+		return
+	}
+	c := exec.Command(self.p, append([]string{a.Kind}, args...)...)
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
 	err := c.Run()
 	if err != nil {
-		log.Println("failed to send balance", balance, "alert:", err)
+		log.Println("failed to send", a.Kind, args, "alert:", err)
+		return
 	}
+	self.d.Sent(a)
+}
+
+// BalanceAlert executes the program with arguments "balance" and the balance.
+// For example, given balance 1000:
+//  program balance 1000
+func (self *ExAlerter) BalanceAlert(balance faucet.Amount) {
+	self.run(&alert.Alert{Kind: alert.KindBalance}, balance.String())
 }
 
+// BalanceRecovered executes the program with arguments "balance-recovered" and the balance.
+func (self *ExAlerter) BalanceRecovered(balance faucet.Amount) {
+	self.run(&alert.Alert{Kind: alert.KindBalanceRecovered}, balance.String())
+}
+
+// DBAlert executes the program with arguments "db" and error message.
+func (self *ExAlerter) DBAlert(err error) { self.run(&alert.Alert{Kind: alert.KindDB}, errText(err)) }
+
 // RateAlert executes the program with arguments "rate", the amount and period in seconds.
 // For example, given amount 1000 and period 1 hour:
 //  program rate 1000 3600
 // After successful execution, further alerts of this type will be ignored until there will be a full period with no alerts.
-func (self *ExAlerter) RateAlert(amount float64, period time.Duration) {
-	self.m.Lock()
-	defer self.m.Unlock()
-	ct := time.Now()
-	nt := ct.Add(period)
-	if ct.Before(self.rt) {
-		if nt.After(self.rt) {
-			self.rt = nt
-		}
-		return
-	}
-	c := exec.Command(self.p, "rate", strconv.FormatFloat(amount, 'f', -1, 64), strconv.FormatInt(int64(period/time.Second), 10))
-	c.Stdout = os.Stdout
-	c.Stderr = os.Stderr
-	err := c.Run()
-	if err != nil {
-		log.Println("failed to send rate", amount, period, "alert:", err)
-	} else {
-		self.rt = nt
-	}
+func (self *ExAlerter) RateAlert(amount faucet.Amount, period time.Duration) {
+	self.run(&alert.Alert{Kind: alert.KindRate, Period: period}, amount.String(), seconds(period))
+}
+
+// RateRecovered executes the program with arguments "rate-recovered", the amount and period in seconds.
+func (self *ExAlerter) RateRecovered(amount faucet.Amount, period time.Duration) {
+	self.run(&alert.Alert{Kind: alert.KindRateRecovered}, amount.String(), seconds(period))
+}
+
+// SendAlert executes the program with arguments "send", the number of failures and error message.
+// For example, after 3 failures:
+//  program send 3 "RPC error -4 \"Transaction too large\""
+func (self *ExAlerter) SendAlert(n int, err error) {
+	self.run(&alert.Alert{Kind: alert.KindSend}, strconv.Itoa(n), errText(err))
+}
+
+// TokenAlert executes the program with arguments "token", the number of rejected claims and period in seconds.
+func (self *ExAlerter) TokenAlert(n int, period time.Duration) {
+	self.run(&alert.Alert{Kind: alert.KindToken}, strconv.Itoa(n), seconds(period))
 }
 
-func NewExAlerter(cfg *ExAlerterConfig) *ExAlerter { return &ExAlerter{p: cfg.AlertProgram} }
+// TxAlert executes the program with arguments "tx", the transaction identifier, its state and seconds since it was sent.
+// For example, given a transaction that was double-spent 2 hours after sending:
+//  program tx 62a626a004273e0c4e7f526e2381de8a36591feb72b8019d16a75c44e606ea15 conflicted 7200
+func (self *ExAlerter) TxAlert(tx string, state faucet.TxState, sent time.Time) {
+	self.run(&alert.Alert{Kind: alert.KindTx}, tx, state.String(), seconds(time.Since(sent)))
+}
+
+// WalletAlert executes the program with arguments "wallet", seconds since the wallet became unreachable and error message.
+func (self *ExAlerter) WalletAlert(since time.Time, err error) {
+	self.run(&alert.Alert{Kind: alert.KindWallet}, seconds(time.Since(since)), errText(err))
+}
+
+func NewExAlerter(cfg *ExAlerterConfig, dc *alert.DebounceConfig) *ExAlerter {
+	return &ExAlerter{d: alert.Debounce{Periods: *dc}, p: cfg.AlertProgram}
+}
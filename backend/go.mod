@@ -3,6 +3,8 @@ module faucet
 go 1.14
 
 require (
+	github.com/go-sql-driver/mysql v1.5.0
+	github.com/lib/pq v1.7.0
 	github.com/mattn/go-sqlite3 v1.14.0
 	gopkg.in/yaml.v3 v3.0.0-20200615113413-eeeca48fe776
 )
@@ -10,13 +10,31 @@ import (
 )
 
 var (
+	ErrCaptchaFailed        = errors.New("CAPTCHA verification failed")
+	ErrClaimFailed          = errors.New("claim with this idempotency key failed")
+	ErrClaimUnresolved      = errors.New("outcome of sending the claim is not known yet")
+	ErrHighRisk             = errors.New("client IP address has high abuse risk")
 	ErrInvalidClientAddress = errors.New("invalid client IP address")
+	ErrInvalidKey           = errors.New("invalid idempotency key")
 	ErrInvalidRecipient     = errors.New("invalid recipient address")
+	ErrInvalidSolution      = errors.New("invalid or missing proof-of-work solution")
 	ErrInvalidToken         = errors.New("invalid or missing token")
+	ErrNoClaim              = errors.New("claim not found")
+	ErrNoClaimLog           = errors.New("claim log is not available")
 	ErrNoFunds              = errors.New("no funds in the bank")
 	ErrPaused               = errors.New("service paused")
 )
 
+// Blocked is returned for clients denied by IP rules. Until is zero if the rule does not expire.
+type Blocked struct{ Until time.Time }
+
+func (self Blocked) Error() string {
+	if self.Until.IsZero() {
+		return "this client is blocked"
+	}
+	return "this client is blocked until " + self.Until.String()
+}
+
 type MustWait struct{ Until time.Time }
 
 func (self MustWait) Error() string { return "this client must wait until " + self.Until.String() }
@@ -34,10 +52,94 @@ func (self ServiceUnavailableError) Unwrap() error { return self.Err }
 // Bank provides funds for the faucet.
 type Bank interface {
 	// Balance available for giveaway.
-	Balance(ctx context.Context) (float64, error)
+	Balance(ctx context.Context) (Amount, error)
 
 	// Send coins. Returns cryptocurrency transaction identifier.
-	Send(ctx context.Context, recipient string, amount float64) (string, error)
+	Send(ctx context.Context, recipient string, amount Amount) (string, error)
+
+	// SendMany sends coins to several recipients in one transaction. Returns cryptocurrency transaction identifier.
+	SendMany(ctx context.Context, amounts map[string]Amount) (string, error)
+}
+
+// CommentSender is implemented by banks that can store a comment with sent transactions.
+// The comment identifies the send request, so that SentTxFinder can tell its transaction apart
+// from other transactions that pay the same amount to the same recipient.
+type CommentSender interface {
+	// SendComment is like Bank.Send and stores the comment with the transaction.
+	SendComment(ctx context.Context, recipient string, amount Amount, comment string) (string, error)
+
+	// SendManyComment is like Bank.SendMany and stores the comment with the transaction.
+	SendManyComment(ctx context.Context, amounts map[string]Amount, comment string) (string, error)
+}
+
+// SentTx is an output of a transaction sent by the bank.
+type SentTx struct {
+	TX      string
+	Amount  Amount
+	Comment string // Empty if the transaction was sent without comment.
+}
+
+// SentTxFinder is implemented by banks that can look up transactions they have sent.
+// It is used to find out whether coins were sent when a send request failed with unknown outcome.
+type SentTxFinder interface {
+	// FindSent returns outputs of transactions sent since given time that pay to the recipient, from the newest.
+	// If some transactions could not be checked, it returns the outputs found together with the error.
+	FindSent(ctx context.Context, recipient string, since time.Time) ([]SentTx, error)
+}
+
+// TxInfo is the state of a transaction reported by the wallet.
+type TxInfo struct {
+	// Confirmations is the number of confirmations. It is negative if a conflicting transaction is confirmed.
+	Confirmations int
+
+	// Abandoned is whether the transaction was abandoned in the wallet.
+	Abandoned bool
+}
+
+// TxChecker is implemented by banks that can report the state of transactions they have sent.
+type TxChecker interface {
+	// CheckTx returns the state of the transaction with given identifier.
+	CheckTx(ctx context.Context, tx string) (*TxInfo, error)
+}
+
+// SchedulePoint is a point of amount schedule where Amount is paid at Level of bank balance or giveaway rate.
+type SchedulePoint struct {
+	Level, Amount Amount
+}
+
+// AmountSchedule maps bank balance and total giveaway amount during rate limit period to giveaway amount.
+// Points of each curve are ordered by increasing level. Between points, the amount is interpolated linearly,
+// or if Tiered is true, it is the amount of the nearest point below. Outside of points, it is the amount of the nearest point.
+// The smaller of amounts given by Balance and Rate curves applies. An empty curve does not limit the amount.
+type AmountSchedule struct {
+	Balance, Rate []SchedulePoint
+	Tiered        bool
+}
+
+// Configured returns whether the schedule has any points.
+func (self *AmountSchedule) Configured() bool { return len(self.Balance) > 0 || len(self.Rate) > 0 }
+
+// PolicyWindow is a scheduled period when giveaway parameters are overridden.
+// Dates, days and hours are in UTC. Each condition that is set must match.
+type PolicyWindow struct {
+	Name string
+
+	// From and To limit the window to a date range. Zero values mean no limit.
+	From, To time.Time
+
+	// Days of week when the window is active, like "sat" or "mon-fri". Empty means every day.
+	Days []string
+
+	// Hours is time of day range when the window is active, like "09:00-17:00". It may cross midnight. Empty means all day.
+	Hours string
+
+	// Pause stops giveaway during the window.
+	Pause bool
+
+	// Non-zero values override faucet configuration.
+	Amount          Amount
+	IPClaimInterval time.Duration
+	RateLimit       struct{ Amount Amount }
 }
 
 // Faucet implements core logic.
@@ -48,19 +150,58 @@ type Faucet interface {
 	AddressVersions() []uint
 
 	// Amount returns expected giveaway amount.
-	Amount(ctx context.Context) (float64, error)
+	Amount(ctx context.Context) (Amount, error)
+
+	// ClaimByID returns claim log record with given identifier.
+	// Returns ErrNoClaimLog if claims are not logged, ErrNoClaim if there is no such record.
+	ClaimByID(ctx context.Context, id int64) (*ClaimRecord, error)
+
+	// Claims returns records of recent claims selected by the query.
+	// Returns ErrNoClaimLog if claims are not logged.
+	Claims(ctx context.Context, q *ClaimQuery) ([]ClaimRecord, error)
+
+	// ClaimsByTX returns records of claims sent in the transaction with given identifier, from oldest to newest.
+	// Returns ErrNoClaimLog if claims are not logged, ErrNoClaim if there are no such records.
+	ClaimsByTX(ctx context.Context, tx string) ([]ClaimRecord, error)
 
 	// Claim checks validity of claim request and sends coins.
-	// Returns actual amount of coins sent and cryptocurrency transaction identifier.
-	Claim(ctx context.Context, client, recipient, token string) (amount float64, tx string, err error)
+	// Returns actual amount of coins sent, claim log record identifier and cryptocurrency transaction identifier.
+	// If claims are sent in batches, the claim is queued, and transaction identifier is empty.
+	// Claim log record identifier is zero if the claim is not logged.
+	// Returns ErrNoClaimLog if the request has idempotency key and claims are not logged.
+	Claim(ctx context.Context, req *ClaimRequest) (amount Amount, id int64, tx string, err error)
+
+	// Schedule returns giveaway amount schedule, or nil if it is not configured.
+	Schedule() *AmountSchedule
 
 	// Token that must be supplied when claiming.
 	// If empty then token is not required.
-	Token(ctx context.Context, client string) (string, error)
+	// If difficulty is not zero, the token is a proof-of-work challenge that must be solved with this difficulty.
+	Token(ctx context.Context, client string) (token string, difficulty uint, err error)
 
 	// WaitTime returns time point after which this client can claim again.
 	// Returns zero if this client can claim now.
 	WaitTime(ctx context.Context, client string) (time.Time, error)
+
+	// Window returns active policy window, or nil if there is none.
+	Window() *PolicyWindow
+}
+
+// ClaimRequest contains parameters of a claim.
+type ClaimRequest struct {
+	Client    string // Client IP address with optional TCP port number.
+	Recipient string // Cryptocurrency recipient address.
+	Token     string // Token obtained from Faucet.Token.
+	Solution  string // Proof-of-work solution for the token if its difficulty is not zero.
+	Captcha   string // CAPTCHA response if CAPTCHA verification is enabled.
+	Key       string // Idempotency key. Repeated requests with the same key return the outcome of the first one.
+}
+
+// CaptchaVerifier verifies CAPTCHA responses.
+type CaptchaVerifier interface {
+	// Verify checks CAPTCHA response submitted by the client with given IP address.
+	// Returns ErrCaptchaFailed if the response is not valid, or other error if verification could not be done.
+	Verify(ctx context.Context, response, client string) error
 }
 
 // ClaimLogIter enumerates claim log records.
@@ -68,29 +209,272 @@ type ClaimLogIter interface {
 	// Close should be called after using the iterator.
 	Close() error
 
-	// Get reads time and client IP address of current record.
-	Get(t *time.Time, client *net.IP, amount *float64) error
+	// Get reads time, client IP address, recipient address and amount of current record.
+	Get(t *time.Time, client *net.IP, recipient *string, amount *Amount) error
 
 	// Next advances to the next record. It should also be called before reading the first record.
 	// Returns whether there is a record.
 	Next() bool
 }
 
+// ClaimQuery selects claim log records.
+type ClaimQuery struct {
+	// Before selects records with identifiers less than this. Zero means no limit.
+	// It can be set to the identifier of the last record of previous page.
+	Before int64
+
+	// Client selects records of claims from this network. Nil means any client.
+	Client *net.IPNet
+
+	// Limit is the maximum number of records to return.
+	Limit int
+
+	// Recipient selects records of claims to this address. Empty means any recipient.
+	Recipient string
+}
+
+// ClaimStatus is the state of a logged claim.
+type ClaimStatus int
+
+const (
+	ClaimSent    ClaimStatus = iota // Coins were sent.
+	ClaimPending                    // Claim is queued for sending in a batch.
+	ClaimSending                    // Claim is being sent, or the outcome of sending is not known yet.
+	ClaimFailed                     // Sending failed.
+)
+
+func (self ClaimStatus) String() string {
+	switch self {
+	case ClaimSent:
+		return "sent"
+	case ClaimPending:
+		return "pending"
+	case ClaimSending:
+		return "sending"
+	case ClaimFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// TxState is the state of a sent transaction.
+type TxState int
+
+const (
+	TxUnconfirmed TxState = iota // Transaction does not have enough confirmations yet.
+	TxConfirmed                  // Transaction has enough confirmations.
+	TxConflicted                 // A conflicting transaction was confirmed, the coins were double-spent.
+	TxAbandoned                  // Transaction was abandoned in the wallet.
+)
+
+func (self TxState) String() string {
+	switch self {
+	case TxUnconfirmed:
+		return "unconfirmed"
+	case TxConfirmed:
+		return "confirmed"
+	case TxConflicted:
+		return "conflicted"
+	case TxAbandoned:
+		return "abandoned"
+	}
+	return "unknown"
+}
+
+// IPAction is the action of a client IP address rule.
+type IPAction int
+
+const (
+	IPAllow  IPAction = iota // Claims are allowed.
+	IPDeny                   // Claims are rejected.
+	IPExempt                 // Claims are allowed regardless of client IP claim interval.
+)
+
+func (self IPAction) String() string {
+	switch self {
+	case IPAllow:
+		return "allow"
+	case IPDeny:
+		return "deny"
+	case IPExempt:
+		return "exempt"
+	}
+	return "unknown"
+}
+
+// IPRule applies the action to clients with IP addresses in the network.
+type IPRule struct {
+	Action IPAction
+	Net    *net.IPNet
+	Until  time.Time // Expiry time. Zero means the rule does not expire.
+}
+
+// RiskLevel is estimated abuse risk of a client.
+type RiskLevel int
+
+const (
+	RiskLow    RiskLevel = iota // Claims are allowed.
+	RiskMedium                  // Claims get no more than stingy amount.
+	RiskHigh                    // Claims are rejected.
+)
+
+// RiskScorer estimates abuse risk of clients by IP address.
+type RiskScorer interface {
+	Risk(ip net.IP) RiskLevel
+}
+
+// ClaimRecord is a claim log record.
+type ClaimRecord struct {
+	ID        int64
+	Time      time.Time
+	Client    net.IP
+	Recipient string
+	Amount    Amount
+	TX        []byte
+	Status    ClaimStatus
+
+	// Confirmations and TxState are the state of the transaction when it was last checked.
+	Confirmations int
+	TxState       TxState
+}
+
 // FaucetDB stores persistent data for the faucet.
 type FaucetDB interface {
-	// ClaimsSince returns all claim records since given time.
+	// ClaimByID returns claim record with given identifier. Returns nil if there is no such record.
+	ClaimByID(id int64) (*ClaimRecord, error)
+
+	// ClaimsByTX returns records of claims with ClaimSent status sent in the transaction, from oldest to newest.
+	ClaimsByTX(tx []byte) ([]ClaimRecord, error)
+
+	// ClaimsSince returns all claim records since given time, except for failed claims.
 	ClaimsSince(t time.Time) (ClaimLogIter, error)
 
 	// LogClaim adds log record about successful claim.
-	LogClaim(t time.Time, client net.IP, recipient string, amount float64, tx []byte) error
+	LogClaim(t time.Time, client net.IP, recipient string, amount Amount, tx []byte) error
+
+	// ClaimByKey returns claim record with given idempotency key. Returns nil if there is no such record.
+	ClaimByKey(key string) (*ClaimRecord, error)
+
+	// LogPendingClaim adds log record about claim with ClaimPending or ClaimSending status. Returns record identifier.
+	// Key is idempotency key of the claim; records with empty key do not have one.
+	// Adding a record with the key of another record fails.
+	LogPendingClaim(t time.Time, client net.IP, recipient string, amount Amount, key string, status ClaimStatus) (int64, error)
+
+	// PendingClaims returns records of claims with ClaimPending or ClaimSending status, from oldest to newest.
+	PendingClaims() ([]ClaimRecord, error)
+
+	// QueryClaims returns claim records selected by the query, from newest to oldest.
+	QueryClaims(q *ClaimQuery) ([]ClaimRecord, error)
+
+	// SetClaimStatus changes status of claim records. Transaction identifier is stored if it is not nil.
+	SetClaimStatus(ids []int64, status ClaimStatus, tx []byte) error
+
+	// SetTxState records the state and the number of confirmations of the transaction in records of claims sent in it.
+	SetTxState(tx []byte, state TxState, confirmations int) error
+
+	// UnconfirmedClaims returns records of claims with ClaimSent status since given time
+	// whose transactions have TxUnconfirmed state, from oldest to newest.
+	UnconfirmedClaims(since time.Time) ([]ClaimRecord, error)
 }
 
 // Alerter sends notifications about important events.
 // Alert methods should be called once when the condition changes from false to true.
+// Recovery methods should be called once when the condition of a sent alert changes back to false.
 type Alerter interface {
 	// BalanceAlert sends a notification about low balance.
-	BalanceAlert(balance float64)
+	BalanceAlert(balance Amount)
+
+	// BalanceRecovered sends a notification that the balance is no longer low.
+	BalanceRecovered(balance Amount)
 
 	// RateAlert sends a notification about excessive total giveaway rate
-	RateAlert(amount float64, period time.Duration)
+	RateAlert(amount Amount, period time.Duration)
+
+	// RateRecovered sends a notification that total giveaway rate is no longer excessive.
+	RateRecovered(amount Amount, period time.Duration)
+
+	// WalletAlert sends a notification that the wallet has been unreachable since given time. Err is the last error.
+	WalletAlert(since time.Time, err error)
+
+	// SendAlert sends a notification about n consecutive failures to send coins. Err is the last error.
+	SendAlert(n int, err error)
+
+	// TokenAlert sends a notification about n claims rejected because of invalid token or proof-of-work solution
+	// during the period.
+	TokenAlert(n int, period time.Duration)
+
+	// DBAlert sends a notification about failure to write the claim log.
+	DBAlert(err error)
+
+	// TxAlert sends a notification about a transaction sent at given time that was double-spent (TxConflicted),
+	// abandoned (TxAbandoned) or is stuck without confirmations (TxUnconfirmed).
+	TxAlert(tx string, state TxState, sent time.Time)
+}
+
+// Event kinds.
+const (
+	EventAmount  = "amount"
+	EventBalance = "balance"
+	EventClaim   = "claim"
+)
+
+// Event is a notification about faucet activity.
+type Event struct {
+	// Kind is one of EventAmount, EventBalance or EventClaim.
+	Kind string
+
+	// Time when the event happened.
+	Time time.Time
+
+	// Amount is the amount sent for EventClaim or new giveaway amount for EventAmount.
+	Amount Amount
+
+	// Balance is new bank balance for EventBalance.
+	Balance Amount
+
+	// Recipient is truncated recipient address for EventClaim.
+	Recipient string
+
+	// TX is cryptocurrency transaction identifier for EventClaim.
+	TX string
+}
+
+// EventSource publishes notifications about faucet activity.
+type EventSource interface {
+	// Subscribe returns a channel that receives events and a function that cancels the subscription.
+	// The channel is closed when the subscription is cancelled or the subscriber falls behind.
+	Subscribe() (<-chan Event, func())
+}
+
+// Admin provides administrative control of the faucet.
+type Admin interface {
+	// Claims returns records of recent claims selected by the query, like Faucet.Claims.
+	Claims(ctx context.Context, q *ClaimQuery) ([]ClaimRecord, error)
+
+	// Ban temporarily denies claims from given client IP address or CIDR prefix until the given time.
+	Ban(prefix string, until time.Time) error
+
+	// Bans returns active temporary bans.
+	Bans() []IPRule
+
+	// ClearIntervals removes claim interval records that prevent claims from given client IP address or CIDR prefix.
+	ClearIntervals(prefix string) error
+
+	// Config returns effective configuration with secrets redacted in a form suitable for encoding in YAML.
+	Config() interface{}
+
+	// Paused returns whether giveaway is paused by SetPaused.
+	Paused() bool
+
+	// PeriodTotal returns total amount of claims during rate limit period.
+	PeriodTotal() Amount
+
+	// SetPaused pauses or resumes giveaway. While paused, Claim returns ErrPaused.
+	SetPaused(paused bool)
+
+	// Unban removes temporary ban of given client IP address or CIDR prefix.
+	Unban(prefix string) error
+
+	// Window returns active policy window, like Faucet.Window.
+	Window() *PolicyWindow
 }
@@ -0,0 +1,267 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package metrics exposes metrics in Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metric is a named metric that can be written in text exposition format.
+type Metric interface {
+	// Write writes all samples of the metric including HELP and TYPE lines.
+	Write(w *bufio.Writer)
+}
+
+// DefBuckets are default histogram buckets for durations in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func writeHeader(w *bufio.Writer, name, help, typ string) {
+	w.WriteString("# HELP ")
+	w.WriteString(name)
+	w.WriteByte(' ')
+	w.WriteString(helpEscaper.Replace(help))
+	w.WriteString("\n# TYPE ")
+	w.WriteString(name)
+	w.WriteByte(' ')
+	w.WriteString(typ)
+	w.WriteByte('\n')
+}
+
+// writeSample writes a sample line. Labels are given as name and value pairs. Pairs with empty name are skipped.
+func writeSample(w *bufio.Writer, name string, v float64, labels ...string) {
+	w.WriteString(name)
+	n := 0
+	for i := 0; i+1 < len(labels); i += 2 {
+		if len(labels[i]) == 0 {
+			continue
+		}
+		if n == 0 {
+			w.WriteByte('{')
+		} else {
+			w.WriteByte(',')
+		}
+		w.WriteString(labels[i])
+		w.WriteString(`="`)
+		w.WriteString(labelEscaper.Replace(labels[i+1]))
+		w.WriteByte('"')
+		n++
+	}
+	if n > 0 {
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatValue(v))
+	w.WriteByte('\n')
+}
+
+// sortedKeys returns keys of the map in ascending order.
+func sortedKeys(m map[string]float64) []string {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+// Counter is a monotonically increasing value, optionally partitioned by one label.
+type Counter struct {
+	help, label, name string
+	m                 sync.Mutex
+	vs                map[string]float64
+}
+
+// Add adds v to the value with given label value. Label value is ignored if the counter has no label.
+func (self *Counter) Add(lv string, v float64) {
+	if len(self.label) == 0 {
+		lv = ""
+	}
+	self.m.Lock()
+	defer self.m.Unlock()
+	self.vs[lv] += v
+}
+
+// Inc adds 1 to the value with given label value.
+func (self *Counter) Inc(lv string) { self.Add(lv, 1) }
+
+// Init makes the value with given label value present in output before it is incremented.
+func (self *Counter) Init(lv ...string) {
+	self.m.Lock()
+	defer self.m.Unlock()
+	for _, v := range lv {
+		self.vs[v] += 0
+	}
+}
+
+func (self *Counter) Write(w *bufio.Writer) {
+	self.m.Lock()
+	defer self.m.Unlock()
+	writeHeader(w, self.name, self.help, "counter")
+	for _, lv := range sortedKeys(self.vs) {
+		writeSample(w, self.name, self.vs[lv], self.label, lv)
+	}
+}
+
+// NewCounter creates a counter. If label is empty, the counter has a single value.
+func NewCounter(name, help, label string) *Counter {
+	return &Counter{
+		help:  help,
+		label: label,
+		name:  name,
+		vs:    make(map[string]float64),
+	}
+}
+
+// GaugeFunc is a value that is obtained by calling a function when metrics are collected.
+type GaugeFunc struct {
+	Name, Help string
+	F          func() float64
+}
+
+func (self *GaugeFunc) Write(w *bufio.Writer) {
+	writeHeader(w, self.Name, self.Help, "gauge")
+	writeSample(w, self.Name, self.F())
+}
+
+type histogramData struct {
+	counts []uint64
+	n      uint64
+	sum    float64
+}
+
+// Histogram counts observed values in buckets, optionally partitioned by one label.
+type Histogram struct {
+	buckets           []float64
+	help, label, name string
+	hs                map[string]*histogramData
+	m                 sync.Mutex
+}
+
+// Observe adds a value to the histogram with given label value. Label value is ignored if the histogram has no label.
+func (self *Histogram) Observe(lv string, v float64) {
+	if len(self.label) == 0 {
+		lv = ""
+	}
+	self.m.Lock()
+	defer self.m.Unlock()
+	h := self.hs[lv]
+	if h == nil {
+		h = &histogramData{counts: make([]uint64, len(self.buckets))}
+		self.hs[lv] = h
+	}
+	for i, b := range self.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.n++
+	h.sum += v
+}
+
+func (self *Histogram) Write(w *bufio.Writer) {
+	self.m.Lock()
+	defer self.m.Unlock()
+	writeHeader(w, self.name, self.help, "histogram")
+	lvs := make([]string, 0, len(self.hs))
+	for lv := range self.hs {
+		lvs = append(lvs, lv)
+	}
+	sort.Strings(lvs)
+	for _, lv := range lvs {
+		h := self.hs[lv]
+		for i, b := range self.buckets {
+			writeSample(w, self.name+"_bucket", float64(h.counts[i]), self.label, lv, "le", formatValue(b))
+		}
+		writeSample(w, self.name+"_bucket", float64(h.n), self.label, lv, "le", "+Inf")
+		writeSample(w, self.name+"_sum", h.sum, self.label, lv)
+		writeSample(w, self.name+"_count", float64(h.n), self.label, lv)
+	}
+}
+
+// NewHistogram creates a histogram with given upper bounds of buckets in ascending order.
+// If label is empty, the histogram is not partitioned.
+func NewHistogram(name, help, label string, buckets []float64) *Histogram {
+	return &Histogram{
+		buckets: buckets,
+		help:    help,
+		hs:      make(map[string]*histogramData),
+		label:   label,
+		name:    name,
+	}
+}
+
+// Registry is a collection of metrics. It serves them via HTTP.
+type Registry struct {
+	m  sync.Mutex
+	ms []Metric
+}
+
+// Register adds metrics to the registry.
+func (self *Registry) Register(ms ...Metric) {
+	self.m.Lock()
+	defer self.m.Unlock()
+	self.ms = append(self.ms, ms...)
+}
+
+// WriteTo writes all registered metrics in text exposition format.
+func (self *Registry) WriteTo(w io.Writer) (int64, error) {
+	self.m.Lock()
+	ms := append([]Metric(nil), self.ms...)
+	self.m.Unlock()
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range ms {
+		m.Write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+func (self *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", "GET")
+	if r.Method != "GET" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, err := self.WriteTo(w)
+	if err != nil {
+		log.Println("failed to send metrics:", err)
+	}
+}
+
+type countingWriter struct {
+	n int64
+	w io.Writer
+}
+
+func (self *countingWriter) Write(b []byte) (int, error) {
+	n, err := self.w.Write(b)
+	self.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,53 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package metrics_test
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+import (
+	"faucet/metrics"
+)
+
+func TestExposition(t *testing.T) {
+	c := metrics.NewCounter("test_total", "Test\ncounter.", "outcome")
+	c.Init("b")
+	c.Inc("a\"x")
+	c.Add("b", 2.5)
+	h := metrics.NewHistogram("test_seconds", "Test histogram.", "method", []float64{.1, 1})
+	h.Observe("m", .05)
+	h.Observe("m", .5)
+	h.Observe("m", 2)
+	r := new(metrics.Registry)
+	r.Register(c, &metrics.GaugeFunc{
+		Name: "test_gauge",
+		Help: "Test gauge.",
+		F:    math.NaN,
+	}, h)
+	var sb strings.Builder
+	_, err := r.WriteTo(&sb)
+	if err != nil {
+		t.Fatal("WriteTo failed:", err)
+	}
+	want := `# HELP test_total Test\ncounter.
+# TYPE test_total counter
+test_total{outcome="a\"x"} 1
+test_total{outcome="b"} 2.5
+# HELP test_gauge Test gauge.
+# TYPE test_gauge gauge
+test_gauge NaN
+# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{method="m",le="0.1"} 1
+test_seconds_bucket{method="m",le="1"} 2
+test_seconds_bucket{method="m",le="+Inf"} 3
+test_seconds_sum{method="m"} 2.55
+test_seconds_count{method="m"} 3
+`
+	if sb.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", sb.String(), want)
+	}
+}
@@ -0,0 +1,193 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package risk estimates abuse risk of clients from offline IP prefix lists.
+package risk
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+import (
+	"faucet"
+)
+
+// List is a file with IP prefixes of the given risk level.
+type List struct {
+	File string
+
+	// Format is "list" for a list of IP addresses and CIDR prefixes (one per line, like Tor exit list),
+	// or "asn" for CSV with prefix and autonomous system number in the first two columns.
+	Format string
+
+	// ASNs selects prefixes of these autonomous systems from "asn" file.
+	ASNs []uint32
+
+	// Risk is "medium" or "high".
+	Risk string
+}
+
+// Config configures risk lists.
+type Config struct{ Lists []List }
+
+// Configured returns whether any risk lists are configured.
+func (self *Config) Configured() bool { return len(self.Lists) > 0 }
+
+func parseLevel(s string) (faucet.RiskLevel, error) {
+	switch s {
+	case "medium":
+		return faucet.RiskMedium, nil
+	case "high":
+		return faucet.RiskHigh, nil
+	}
+	return faucet.RiskLow, fmt.Errorf("invalid risk level %q", s)
+}
+
+func parsePrefix(s string) (*net.IPNet, error) {
+	if strings.ContainsRune(s, '/') {
+		_, n, err := net.ParseCIDR(s)
+		return n, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, &net.ParseError{Type: "IP address", Text: s}
+	}
+	return &net.IPNet{IP: ip.To16(), Mask: net.CIDRMask(addrBits, addrBits)}, nil
+}
+
+// torKeywords are keywords of Tor exit-addresses format lines that do not contain addresses.
+var torKeywords = map[string]bool{
+	"ExitNode":   true,
+	"LastStatus": true,
+	"Published":  true,
+}
+
+// readList reads IP addresses and CIDR prefixes, one per line. Empty lines and lines beginning with # are ignored.
+// Tor exit-addresses format is also accepted.
+func readList(r io.Reader, level faucet.RiskLevel, t *Tree) error {
+	s := bufio.NewScanner(r)
+	for ln := 1; s.Scan(); ln++ {
+		fs := strings.Fields(s.Text())
+		if len(fs) == 0 || strings.HasPrefix(fs[0], "#") || torKeywords[fs[0]] {
+			continue
+		}
+		a := fs[0]
+		if a == "ExitAddress" && len(fs) > 1 {
+			a = fs[1]
+		}
+		n, err := parsePrefix(a)
+		if err != nil {
+			return fmt.Errorf("line %v: %w", ln, err)
+		}
+		t.Insert(n, level)
+	}
+	return s.Err()
+}
+
+// readASN reads CSV with prefix and autonomous system number ("AS" prefix is optional) in the first two columns,
+// such as GeoLite2 ASN blocks, and selects prefixes of given autonomous systems. A header line is skipped.
+func readASN(r io.Reader, asns []uint32, level faucet.RiskLevel, t *Tree) error {
+	am := make(map[uint32]bool)
+	for _, a := range asns {
+		am[a] = true
+	}
+	c := csv.NewReader(r)
+	c.FieldsPerRecord = -1
+	c.ReuseRecord = true
+	for ln := 1; ; ln++ {
+		rec, err := c.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(rec) < 2 {
+			return fmt.Errorf("line %v: expected prefix and ASN", ln)
+		}
+		asn, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(rec[1]), "AS"), 10, 32)
+		if err != nil {
+			if ln == 1 {
+				continue
+			}
+			return fmt.Errorf("line %v: %w", ln, err)
+		}
+		if !am[uint32(asn)] {
+			continue
+		}
+		n, err := parsePrefix(strings.TrimSpace(rec[0]))
+		if err != nil {
+			return fmt.Errorf("line %v: %w", ln, err)
+		}
+		t.Insert(n, level)
+	}
+}
+
+// Scorer implements RiskScorer interface with prefixes loaded from lists.
+type Scorer struct {
+	ls []List
+	m  sync.RWMutex
+	t  *Tree
+}
+
+// Reload loads the lists again. If loading fails, previously loaded prefixes stay in effect.
+func (self *Scorer) Reload() error {
+	t := new(Tree)
+	for _, l := range self.ls {
+		level, err := parseLevel(l.Risk)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(l.File)
+		if err != nil {
+			return err
+		}
+		switch l.Format {
+		case "", "list":
+			err = readList(f, level, t)
+		case "asn":
+			if len(l.ASNs) == 0 {
+				err = errors.New("no ASNs selected")
+			} else {
+				err = readASN(f, l.ASNs, level, t)
+			}
+		default:
+			err = fmt.Errorf("invalid format %q", l.Format)
+		}
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%v: %w", l.File, err)
+		}
+	}
+	log.Println("loaded", t.Len(), "risk prefixes")
+	self.m.Lock()
+	self.t = t
+	self.m.Unlock()
+	return nil
+}
+
+// Risk returns the highest risk level of lists that contain the address.
+func (self *Scorer) Risk(ip net.IP) faucet.RiskLevel {
+	self.m.RLock()
+	defer self.m.RUnlock()
+	return self.t.Lookup(ip)
+}
+
+// NewScorer loads the lists.
+func NewScorer(cfg *Config) (*Scorer, error) {
+	self := &Scorer{ls: cfg.Lists}
+	err := self.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return self, nil
+}
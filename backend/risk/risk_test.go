@@ -0,0 +1,127 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package risk_test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+import (
+	"faucet"
+	"faucet/risk"
+)
+
+func TestTree(t *testing.T) {
+	var tr risk.Tree
+	for _, p := range []struct {
+		n string
+		l faucet.RiskLevel
+	}{
+		{"10.1.0.0/16", faucet.RiskMedium},
+		{"10.1.2.3/32", faucet.RiskHigh},
+		{"10.0.0.0/8", faucet.RiskMedium},
+		{"10.1.128.0/17", faucet.RiskHigh},
+		{"2001:db8::/32", faucet.RiskMedium},
+		{"2001:db8:8000::/33", faucet.RiskHigh},
+		{"10.1.0.0/16", faucet.RiskLow},
+		{"0.0.0.0/0", faucet.RiskLow},
+	} {
+		_, n, err := net.ParseCIDR(p.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr.Insert(n, p.l)
+	}
+	if tr.Len() != 7 {
+		t.Error("Len:", tr.Len())
+	}
+	for _, c := range []struct {
+		ip string
+		l  faucet.RiskLevel
+	}{
+		{"10.1.2.3", faucet.RiskHigh},
+		{"10.1.2.4", faucet.RiskMedium},
+		{"10.1.200.1", faucet.RiskHigh},
+		{"10.2.0.1", faucet.RiskMedium},
+		{"11.0.0.1", faucet.RiskLow},
+		{"2001:db8::1", faucet.RiskMedium},
+		{"2001:db8:8000::1", faucet.RiskHigh},
+		{"2001:db9::1", faucet.RiskLow},
+		{"::ffff:10.1.2.3", faucet.RiskHigh},
+	} {
+		if l := tr.Lookup(net.ParseIP(c.ip)); l != c.l {
+			t.Error(c.ip, "risk", l, "want", c.l)
+		}
+	}
+}
+
+func TestScorer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "risk")
+	if err != nil {
+		t.Fatal("TempDir failed:", err)
+	}
+	defer os.RemoveAll(dir)
+	tor := filepath.Join(dir, "tor")
+	asn := filepath.Join(dir, "asn.csv")
+	err = ioutil.WriteFile(tor, []byte(`ExitNode 0011BD2485AD45D984EC4159C88FC066E5E3300E
+Published 2020-01-01 00:00:00
+LastStatus 2020-01-01 01:00:00
+ExitAddress 192.0.2.1 2020-01-01 01:00:00
+# Plain list.
+192.0.2.2
+`), 0644)
+	if err != nil {
+		t.Fatal("WriteFile failed:", err)
+	}
+	err = ioutil.WriteFile(asn, []byte(`network,autonomous_system_number,autonomous_system_organization
+198.51.100.0/24,64500,"Hosting, Inc."
+203.0.113.0/24,64501,ISP
+2001:db8::/32,AS64500,Hosting
+`), 0644)
+	if err != nil {
+		t.Fatal("WriteFile failed:", err)
+	}
+	cfg := &risk.Config{Lists: []risk.List{
+		{File: tor, Risk: "high"},
+		{File: asn, Format: "asn", ASNs: []uint32{64500}, Risk: "medium"},
+	}}
+	s, err := risk.NewScorer(cfg)
+	if err != nil {
+		t.Fatal("NewScorer failed:", err)
+	}
+	for _, c := range []struct {
+		ip string
+		l  faucet.RiskLevel
+	}{
+		{"192.0.2.1", faucet.RiskHigh},
+		{"192.0.2.2", faucet.RiskHigh},
+		{"192.0.2.3", faucet.RiskLow},
+		{"198.51.100.7", faucet.RiskMedium},
+		{"203.0.113.7", faucet.RiskLow},
+		{"2001:db8::7", faucet.RiskMedium},
+	} {
+		if l := s.Risk(net.ParseIP(c.ip)); l != c.l {
+			t.Error(c.ip, "risk", l, "want", c.l)
+		}
+	}
+
+	// Invalid list does not replace loaded prefixes.
+	err = ioutil.WriteFile(tor, []byte("192.0.2.300\n"), 0644)
+	if err != nil {
+		t.Fatal("WriteFile failed:", err)
+	}
+	if s.Reload() == nil {
+		t.Error("Reload accepted invalid list")
+	}
+	if l := s.Risk(net.ParseIP("192.0.2.1")); l != faucet.RiskHigh {
+		t.Error("risk after failed reload", l)
+	}
+	cfg.Lists[0].Risk = "extreme"
+	if _, err = risk.NewScorer(cfg); err == nil {
+		t.Error("NewScorer accepted invalid risk level")
+	}
+}
@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package risk
+
+import (
+	"math/bits"
+	"net"
+)
+
+import (
+	"faucet"
+)
+
+const addrBits = 8 * net.IPv6len
+
+type node struct {
+	a     [net.IPv6len]byte // Prefix bits. Bits after the prefix are zero.
+	l     int               // Prefix length.
+	level faucet.RiskLevel
+	set   bool // Whether the prefix was inserted rather than created as a branch point.
+	c     [2]*node
+}
+
+// Tree is a path-compressed binary radix tree that maps IP prefixes to risk levels.
+// IPv4 prefixes are stored as IPv4-mapped IPv6 prefixes.
+// The zero value is an empty tree.
+type Tree struct {
+	n    int
+	root *node
+}
+
+func bit(a *[net.IPv6len]byte, i int) int { return int(a[i/8]>>(7-i%8)) & 1 }
+
+// commonLen returns the length of common prefix of a and b, but no more than max.
+func commonLen(a, b *[net.IPv6len]byte, max int) int {
+	for i := range a {
+		if x := a[i] ^ b[i]; x != 0 {
+			l := 8*i + bits.LeadingZeros8(x)
+			if l < max {
+				return l
+			}
+			break
+		}
+	}
+	return max
+}
+
+func maskBits(a [net.IPv6len]byte, l int) [net.IPv6len]byte {
+	for i := range a {
+		switch {
+		case l >= 8*(i+1):
+		case l <= 8*i:
+			a[i] = 0
+		default:
+			a[i] &= ^byte(0xFF >> (l - 8*i))
+		}
+	}
+	return a
+}
+
+// Insert adds the prefix with risk level. If the prefix is already present, the higher level is kept.
+func (self *Tree) Insert(n *net.IPNet, level faucet.RiskLevel) {
+	ones, nb := n.Mask.Size()
+	var a [net.IPv6len]byte
+	copy(a[:], n.IP.To16())
+	l := ones + addrBits - nb
+	a = maskBits(a, l)
+	p := &self.root
+	for {
+		nd := *p
+		if nd == nil {
+			*p = &node{a: a, l: l, level: level, set: true}
+			self.n++
+			return
+		}
+		c := commonLen(&nd.a, &a, nd.l)
+		if c > l {
+			c = l
+		}
+		switch {
+		case c == nd.l && c == l:
+			if !nd.set || nd.level < level {
+				nd.level = level
+			}
+			if !nd.set {
+				nd.set = true
+				self.n++
+			}
+			return
+		case c == nd.l:
+			p = &nd.c[bit(&a, c)]
+			continue
+		case c == l:
+			nn := &node{a: a, l: l, level: level, set: true}
+			nn.c[bit(&nd.a, c)] = nd
+			*p = nn
+		default:
+			b := &node{a: maskBits(a, c), l: c}
+			b.c[bit(&nd.a, c)] = nd
+			b.c[bit(&a, c)] = &node{a: a, l: l, level: level, set: true}
+			*p = b
+		}
+		self.n++
+		return
+	}
+}
+
+// Len returns the number of inserted prefixes.
+func (self *Tree) Len() int { return self.n }
+
+// Lookup returns the highest risk level of prefixes that contain the IP address, or RiskLow if there are none.
+func (self *Tree) Lookup(ip net.IP) faucet.RiskLevel {
+	var a [net.IPv6len]byte
+	if copy(a[:], ip.To16()) != net.IPv6len {
+		return faucet.RiskLow
+	}
+	r := faucet.RiskLow
+	for nd := self.root; nd != nil; nd = nd.c[bit(&a, nd.l)] {
+		if commonLen(&nd.a, &a, nd.l) < nd.l {
+			break
+		}
+		if nd.set && nd.level > r {
+			r = nd.level
+		}
+		if nd.l == addrBits {
+			break
+		}
+	}
+	return r
+}
@@ -0,0 +1,434 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+import (
+	"faucet"
+	"faucet/metrics"
+)
+
+// Wallet selection policies of Failover.
+const (
+	SelectPriority = "priority" // The first healthy wallet in configured order.
+	SelectBalance  = "balance"  // The healthy wallet with the highest balance.
+)
+
+// DefHealthInterval is the default interval of wallet health checks.
+const DefHealthInterval = time.Minute
+
+// HealthTimeout limits time of a wallet health check.
+var HealthTimeout = 10 * time.Second
+
+// rpcInWarmup is RPC error code returned while the node is starting.
+const rpcInWarmup = -28
+
+var errSyncing = errors.New("wallet node is in initial block download")
+
+// notProcessed returns whether the error means that the request certainly was not processed by the wallet,
+// so that it can be sent to another wallet.
+func notProcessed(err error) bool {
+	var oe *net.OpError
+	if errors.As(err, &oe) && oe.Op == "dial" {
+		return true
+	}
+	var re RPCError
+	if errors.As(err, &re) && re.Code == rpcInWarmup {
+		return true
+	}
+	var he HTTPStatusError
+	if errors.As(err, &he) {
+		switch he.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusServiceUnavailable:
+			return true
+		}
+	}
+	return false
+}
+
+// rejected returns whether the error is a reply of the wallet that refused the request.
+func rejected(err error) bool {
+	var re RPCError
+	return err == faucet.ErrInvalidRecipient || errors.As(err, &re)
+}
+
+// endpoint is a wallet with its health status.
+type endpoint struct {
+	bal     faucet.Amount
+	c       *RPCClient
+	checked time.Time
+	err     error
+}
+
+func (self *endpoint) healthy() bool { return !self.checked.IsZero() && self.err == nil }
+
+// Failover implements Bank interface using several Dogecoin Core wallets.
+// Wallets are checked periodically; a wallet is healthy if its node is not in initial block download and it reports balance.
+// Requests are sent to a healthy wallet selected by the policy. If a request fails without reaching the wallet,
+// the wallet is marked unhealthy and the request is sent to the next one.
+// Send requests that fail with unknown outcome are never sent again.
+// With a single wallet, health checks are not done.
+// Health checks run in background, so that an unresponsive wallet does not delay requests.
+type Failover struct {
+	done chan struct{}
+	eps  []*endpoint
+	fo   *metrics.Counter
+	hi   time.Duration
+	hm   sync.Mutex // serializes health checks
+	lat *metrics.Histogram
+	m   sync.Mutex
+	sel string
+}
+
+// checkHealth checks health of a wallet and returns its balance.
+func checkHealth(ctx context.Context, c *RPCClient) (faucet.Amount, error) {
+	res, err := c.rpc(ctx, "getblockchaininfo")
+	if err != nil {
+		return 0, err
+	}
+	var bi struct {
+		InitialBlockDownload bool `json:"initialblockdownload"`
+	}
+	err = res.decodeResult(&bi)
+	if err != nil {
+		return 0, err
+	}
+	if bi.InitialBlockDownload {
+		return 0, errSyncing
+	}
+	c.uncacheBalance()
+	return c.Balance(ctx)
+}
+
+// setStatus records health status of the endpoint.
+func (self *Failover) setStatus(ep *endpoint, bal faucet.Amount, err error) {
+	self.m.Lock()
+	defer self.m.Unlock()
+	if err != nil && ep.err == nil {
+		log.Println("wallet", ep.c.cfg.URL, "is unavailable:", err)
+	} else if err == nil && ep.err != nil {
+		log.Println("wallet", ep.c.cfg.URL, "is available again")
+	}
+	ep.checked = time.Now()
+	ep.err = err
+	if err == nil {
+		ep.bal = bal
+	}
+}
+
+// wallets returns the configured wallets.
+func (self *Failover) wallets() []*endpoint {
+	self.m.Lock()
+	defer self.m.Unlock()
+	return self.eps
+}
+
+// check checks health of the wallets concurrently. Each check is limited by HealthTimeout.
+func (self *Failover) check(eps []*endpoint) {
+	self.hm.Lock()
+	defer self.hm.Unlock()
+	var wg sync.WaitGroup
+	for _, ep := range eps {
+		wg.Add(1)
+		go func(ep *endpoint) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), HealthTimeout)
+			defer cancel()
+			bal, err := checkHealth(ctx, ep.c)
+			self.setStatus(ep, bal, err)
+		}(ep)
+	}
+	wg.Wait()
+}
+
+// watchHealth checks health of wallets every health interval until the failover is closed.
+func (self *Failover) watchHealth() {
+	for {
+		self.m.Lock()
+		hi := self.hi
+		self.m.Unlock()
+		t := time.NewTimer(hi)
+		select {
+		case <-t.C:
+		case <-self.done:
+			t.Stop()
+			return
+		}
+		if eps := self.wallets(); len(eps) > 1 {
+			self.check(eps)
+		}
+	}
+}
+
+// candidates returns wallets in order of preference: healthy ones ordered by the policy, then unhealthy ones in configured order.
+func (self *Failover) candidates() []*endpoint {
+	self.m.Lock()
+	defer self.m.Unlock()
+	eps := make([]*endpoint, 0, len(self.eps))
+	for _, ep := range self.eps {
+		if ep.healthy() || len(self.eps) == 1 {
+			eps = append(eps, ep)
+		}
+	}
+	if self.sel == SelectBalance {
+		// Stable insertion sort by balance, descending.
+		for i := 1; i < len(eps); i++ {
+			for j := i; j > 0 && eps[j].bal > eps[j-1].bal; j-- {
+				eps[j], eps[j-1] = eps[j-1], eps[j]
+			}
+		}
+	}
+	for _, ep := range self.eps {
+		if !ep.healthy() && len(self.eps) > 1 {
+			eps = append(eps, ep)
+		}
+	}
+	return eps
+}
+
+func (self *Failover) Balance(ctx context.Context) (faucet.Amount, error) {
+	var err error
+	eps := self.candidates()
+	for i, ep := range eps {
+		if i > 0 {
+			self.fo.Inc("")
+		}
+		var bal faucet.Amount
+		bal, err = ep.c.Balance(ctx)
+		if err == nil {
+			self.m.Lock()
+			ep.bal = bal
+			self.m.Unlock()
+			return bal, nil
+		}
+		if len(eps) > 1 {
+			self.setStatus(ep, 0, err)
+		}
+	}
+	return 0, err
+}
+
+// send sends a request to wallets in order of preference until it is processed.
+// Wallets that reply with insufficient funds are skipped too.
+func (self *Failover) send(ctx context.Context, f func(c *RPCClient) (string, error)) (string, error) {
+	var (
+		err   error
+		funds bool
+	)
+	eps := self.candidates()
+	for i, ep := range eps {
+		if i > 0 {
+			self.fo.Inc("")
+		}
+		var tx string
+		tx, err = f(ep.c)
+		switch {
+		case err == faucet.ErrNoFunds:
+			funds = true
+			self.m.Lock()
+			ep.bal = 0
+			self.m.Unlock()
+			continue
+		case err != nil && notProcessed(err) && len(eps) > 1:
+			self.setStatus(ep, 0, err)
+			continue
+		case err != nil && !rejected(err) && len(eps) > 1:
+			// The outcome is unknown, so the request is not sent again.
+			self.setStatus(ep, 0, err)
+		}
+		return tx, err
+	}
+	if funds {
+		return "", faucet.ErrNoFunds
+	}
+	return "", err
+}
+
+func (self *Failover) Send(ctx context.Context, recipient string, amount faucet.Amount) (string, error) {
+	return self.SendComment(ctx, recipient, amount, "")
+}
+
+func (self *Failover) SendMany(ctx context.Context, amounts map[string]faucet.Amount) (string, error) {
+	return self.SendManyComment(ctx, amounts, "")
+}
+
+func (self *Failover) SendComment(ctx context.Context, recipient string, amount faucet.Amount, comment string) (string, error) {
+	return self.send(ctx, func(c *RPCClient) (string, error) { return c.SendComment(ctx, recipient, amount, comment) })
+}
+
+func (self *Failover) SendManyComment(ctx context.Context, amounts map[string]faucet.Amount, comment string) (string, error) {
+	return self.send(ctx, func(c *RPCClient) (string, error) { return c.SendManyComment(ctx, amounts, comment) })
+}
+
+// FindSent looks up outputs in all wallets.
+// Returns an error together with the outputs found if some wallet could not be checked.
+func (self *Failover) FindSent(ctx context.Context, recipient string, since time.Time) ([]faucet.SentTx, error) {
+	var sts []faucet.SentTx
+	var ferr error
+	for _, ep := range self.wallets() {
+		ts, err := ep.c.FindSent(ctx, recipient, since)
+		if err != nil {
+			ferr = err
+		}
+		sts = append(sts, ts...)
+	}
+	return sts, ferr
+}
+
+// CheckTx gets the state of the transaction from the first wallet that knows it.
+func (self *Failover) CheckTx(ctx context.Context, tx string) (*faucet.TxInfo, error) {
+	var err error
+	for _, ep := range self.wallets() {
+		var ti *faucet.TxInfo
+		ti, err = ep.c.CheckTx(ctx, tx)
+		if err == nil {
+			return ti, nil
+		}
+	}
+	return nil, err
+}
+
+// Healthy returns the number of healthy wallets.
+func (self *Failover) Healthy() int {
+	self.m.Lock()
+	defer self.m.Unlock()
+	n := 0
+	for _, ep := range self.eps {
+		if ep.healthy() {
+			n++
+		}
+	}
+	return n
+}
+
+// RegisterMetrics registers RPC latency and wallet health metrics.
+func (self *Failover) RegisterMetrics(r *metrics.Registry) {
+	r.Register(self.lat)
+	if len(self.wallets()) > 1 {
+		r.Register(
+			self.fo,
+			&metrics.GaugeFunc{
+				Name: "faucet_rpc_healthy_wallets",
+				Help: "Number of healthy wallets.",
+				F:    func() float64 { return float64(self.Healthy()) },
+			},
+		)
+	}
+}
+
+// endpoints returns configured wallets.
+func (self *RPCConfig) endpoints() []RPCEndpoint {
+	if len(self.Endpoints) == 0 {
+		return []RPCEndpoint{self.RPCEndpoint}
+	}
+	return self.Endpoints
+}
+
+// Check checks that wallets and selection policy of the configuration are valid.
+// Failover can be created or reloaded with any configuration that passes the check.
+func (self *RPCConfig) Check() error {
+	switch self.Select {
+	case "", SelectPriority, SelectBalance:
+	default:
+		return fmt.Errorf("invalid wallet selection policy %q", self.Select)
+	}
+	for _, ep := range self.endpoints() {
+		_, err := url.Parse(ep.URL)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configure sets wallets and selection policy of the configuration.
+// Wallets that stay configured keep their health status. Returns wallets that were not checked yet.
+func (self *Failover) configure(cfg *RPCConfig) ([]*endpoint, error) {
+	err := cfg.Check()
+	if err != nil {
+		return nil, err
+	}
+	ecs := cfg.endpoints()
+	sel := cfg.Select
+	if len(sel) == 0 {
+		sel = SelectPriority
+	}
+	hi := cfg.HealthInterval
+	if hi <= 0 {
+		hi = DefHealthInterval
+	}
+	self.m.Lock()
+	defer self.m.Unlock()
+	old := append([]*endpoint(nil), self.eps...)
+	eps := make([]*endpoint, len(ecs))
+	for i := range ecs {
+		for j, ep := range old {
+			if ep != nil && ep.c.cfg == ecs[i] {
+				eps[i], old[j] = ep, nil
+				break
+			}
+		}
+		if eps[i] == nil {
+			c, err := newRPCClient(&ecs[i], self.lat)
+			if err != nil {
+				return nil, err
+			}
+			eps[i] = &endpoint{c: c}
+		}
+	}
+	self.eps, self.hi, self.sel = eps, hi, sel
+	if len(eps) < 2 {
+		return nil, nil
+	}
+	var unchecked []*endpoint
+	for _, ep := range eps {
+		if ep.checked.IsZero() {
+			unchecked = append(unchecked, ep)
+		}
+	}
+	return unchecked, nil
+}
+
+// Reload replaces wallets and selection policy. Requests in progress are completed by the wallets they were sent to.
+// Wallets that were not checked yet, like the added ones, are checked before it returns.
+// It fails only if the configuration does not pass Check; then nothing is changed.
+func (self *Failover) Reload(cfg *RPCConfig) error {
+	unchecked, err := self.configure(cfg)
+	if err != nil {
+		return err
+	}
+	self.check(unchecked)
+	return nil
+}
+
+// NewFailover creates clients of configured wallets, checks their health and starts periodic health checks.
+func NewFailover(cfg *RPCConfig) (*Failover, error) {
+	self := &Failover{
+		done: make(chan struct{}),
+		fo:   metrics.NewCounter("faucet_rpc_failovers_total", "Number of requests sent to another wallet after a failure.", ""),
+		lat:  newLatency(),
+	}
+	unchecked, err := self.configure(cfg)
+	if err != nil {
+		return nil, err
+	}
+	self.check(unchecked)
+	go self.watchHealth()
+	return self, nil
+}
+
+// Close stops health checks.
+func (self *Failover) Close() {
+	close(self.done)
+}
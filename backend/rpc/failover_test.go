@@ -0,0 +1,353 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package rpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	"faucet"
+	"faucet/rpc"
+)
+
+// walletMock is a fake Dogecoin Core wallet RPC server.
+type walletMock struct {
+	m     sync.Mutex
+	bal   float64
+	ibd   bool
+	drop  bool // Close connection on send requests without replying.
+	sends int
+	tx    string
+	txs   []map[string]interface{} // listtransactions result
+	srv   *httptest.Server
+
+	// gettransaction result of sent transaction
+	confs     int
+	abandoned bool
+}
+
+func newWalletMock(bal float64, tx string) *walletMock {
+	self := &walletMock{bal: bal, tx: tx}
+	self.srv = httptest.NewServer(self)
+	return self
+}
+
+func (self *walletMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+		ID     uint32            `json:"id"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	self.m.Lock()
+	defer self.m.Unlock()
+	var res interface{}
+	switch req.Method {
+	case "getblockchaininfo":
+		res = map[string]bool{"initialblockdownload": self.ibd}
+	case "getbalance":
+		res = self.bal
+	case "sendtoaddress", "sendmany":
+		self.sends++
+		if self.drop {
+			c, _, _ := w.(http.Hijacker).Hijack()
+			c.Close()
+			return
+		}
+		if self.bal < 1 {
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": -6, "message": "Insufficient funds"}, "id": req.ID})
+			return
+		}
+		self.bal--
+		res = self.tx
+		if req.Method == "sendtoaddress" {
+			var (
+				r   string
+				amt faucet.Amount
+			)
+			json.Unmarshal(req.Params[0], &r)
+			json.Unmarshal(req.Params[1], &amt)
+			tx := map[string]interface{}{
+				"address":  r,
+				"amount":   -amt,
+				"category": "send",
+				"time":     time.Now().Unix(),
+				"txid":     self.tx,
+			}
+			// The wallet omits empty comments.
+			if len(req.Params) > 2 {
+				var c string
+				json.Unmarshal(req.Params[2], &c)
+				tx["comment"] = c
+			}
+			self.txs = append(self.txs, tx)
+		}
+	case "listtransactions":
+		res = self.txs
+	case "gettransaction":
+		var tx string
+		json.Unmarshal(req.Params[0], &tx)
+		if len(self.txs) == 0 || tx != self.tx {
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": -5, "message": "Invalid or non-wallet transaction id"}, "id": req.ID})
+			return
+		}
+		res = map[string]interface{}{
+			"confirmations": self.confs,
+			"details":       []map[string]interface{}{{"abandoned": self.abandoned, "category": "send"}},
+			"txid":          self.tx,
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"result": res, "id": req.ID})
+}
+
+// do calls f with the mock locked.
+func (self *walletMock) do(f func()) {
+	self.m.Lock()
+	defer self.m.Unlock()
+	f()
+}
+
+// sendCount returns the number of received send requests.
+func (self *walletMock) sendCount() (n int) {
+	self.do(func() { n = self.sends })
+	return
+}
+
+// closedURL returns URL of a port that refuses connections.
+func closedURL(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Listen failed:", err)
+	}
+	u := "http://" + l.Addr().String()
+	l.Close()
+	return u
+}
+
+func TestFailover(t *testing.T) {
+	ctx := context.Background()
+	w1, w2 := newWalletMock(10, "tx1"), newWalletMock(20, "tx2")
+	defer w1.srv.Close()
+	defer w2.srv.Close()
+	cfg := &rpc.RPCConfig{Endpoints: []rpc.RPCEndpoint{{URL: closedURL(t)}, {URL: w1.srv.URL}, {URL: w2.srv.URL}}}
+
+	f, err := rpc.NewFailover(cfg)
+	if err != nil {
+		t.Fatal("NewFailover failed:", err)
+	}
+	tx, err := f.Send(ctx, "r", faucet.Coin)
+	if err != nil || tx != "tx1" {
+		t.Error("Send with priority returned", tx, err, "want tx1")
+	}
+	if n := f.Healthy(); n != 2 {
+		t.Error(n, "healthy wallets, want 2")
+	}
+
+	cfg.Select = rpc.SelectBalance
+	f, err = rpc.NewFailover(cfg)
+	if err != nil {
+		t.Fatal("NewFailover failed:", err)
+	}
+	tx, err = f.Send(ctx, "r", faucet.Coin)
+	if err != nil || tx != "tx2" {
+		t.Error("Send with balance selection returned", tx, err, "want tx2")
+	}
+	if bal, err := f.Balance(ctx); err != nil || bal != 19*faucet.Coin {
+		t.Error("Balance returned", bal, err)
+	}
+
+	// Insufficient funds in the preferred wallet.
+	cfg.Select = rpc.SelectPriority
+	cfg.Endpoints = cfg.Endpoints[1:]
+	w1.do(func() { w1.bal = 0 })
+	f, err = rpc.NewFailover(cfg)
+	if err != nil {
+		t.Fatal("NewFailover failed:", err)
+	}
+	tx, err = f.Send(ctx, "r", faucet.Coin)
+	if err != nil || tx != "tx2" {
+		t.Error("Send after insufficient funds returned", tx, err, "want tx2")
+	}
+
+	// Wallet in initial block download is not used.
+	w1.do(func() {
+		w1.bal = 10
+		w1.ibd = true
+	})
+	f, err = rpc.NewFailover(cfg)
+	if err != nil {
+		t.Fatal("NewFailover failed:", err)
+	}
+	s1, s2 := w1.sendCount(), w2.sendCount()
+	tx, err = f.SendMany(ctx, map[string]faucet.Amount{"r": faucet.Coin})
+	if err != nil || tx != "tx2" || w1.sendCount() != s1 || w2.sendCount() != s2+1 {
+		t.Error("SendMany with syncing wallet returned", tx, err)
+	}
+
+	if _, err = rpc.NewFailover(&rpc.RPCConfig{Select: "random"}); err == nil {
+		t.Error("NewFailover accepted invalid selection policy")
+	}
+}
+
+func TestFailoverReload(t *testing.T) {
+	ctx := context.Background()
+	w1, w2 := newWalletMock(10, "tx1"), newWalletMock(20, "tx2")
+	defer w1.srv.Close()
+	defer w2.srv.Close()
+	cfg := &rpc.RPCConfig{Endpoints: []rpc.RPCEndpoint{{URL: closedURL(t)}, {URL: w1.srv.URL}}}
+	f, err := rpc.NewFailover(cfg)
+	if err != nil {
+		t.Fatal("NewFailover failed:", err)
+	}
+	tx, err := f.Send(ctx, "r", faucet.Coin)
+	if err != nil || tx != "tx1" {
+		t.Error("Send returned", tx, err, "want tx1")
+	}
+
+	cfg.Endpoints = []rpc.RPCEndpoint{cfg.Endpoints[0], {URL: w2.srv.URL}, {URL: w1.srv.URL}}
+	cfg.Select = rpc.SelectBalance
+	err = f.Reload(cfg)
+	if err != nil {
+		t.Fatal("Reload failed:", err)
+	}
+	// The added wallet is checked, the others keep their health status.
+	if n := f.Healthy(); n != 2 {
+		t.Error(n, "healthy wallets after reload, want 2")
+	}
+	tx, err = f.Send(ctx, "r", faucet.Coin)
+	if err != nil || tx != "tx2" {
+		t.Error("Send after reload returned", tx, err, "want tx2")
+	}
+	if n := f.Healthy(); n != 2 {
+		t.Error(n, "healthy wallets, want 2")
+	}
+
+	if err = f.Reload(&rpc.RPCConfig{Select: "random"}); err == nil {
+		t.Error("Reload accepted invalid selection policy")
+	}
+	tx, err = f.Send(ctx, "r", faucet.Coin)
+	if err != nil || tx != "tx2" {
+		t.Error("Send after failed reload returned", tx, err, "want tx2")
+	}
+}
+
+func TestFailoverHang(t *testing.T) {
+	defer func(d time.Duration) { rpc.HealthTimeout = d }(rpc.HealthTimeout)
+	rpc.HealthTimeout = 100 * time.Millisecond
+	release := make(chan struct{})
+	hang := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release }))
+	defer hang.Close()
+	defer close(release)
+	w1 := newWalletMock(10, "tx1")
+	defer w1.srv.Close()
+	ctx := context.Background()
+	t0 := time.Now()
+	f, err := rpc.NewFailover(&rpc.RPCConfig{
+		Endpoints:      []rpc.RPCEndpoint{{URL: hang.URL}, {URL: w1.srv.URL}},
+		HealthInterval: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal("NewFailover failed:", err)
+	}
+	defer f.Close()
+	if n := f.Healthy(); n != 1 {
+		t.Error(n, "healthy wallets, want 1")
+	}
+	// Requests are not delayed by health checks of the wallet that never answers.
+	for i := 0; i < 5; i++ {
+		if tx, err := f.Send(nil, "r", faucet.Coin); err != nil || tx != "tx1" {
+			t.Error("Send returned", tx, err, "want tx1")
+		}
+		if _, err := f.Balance(ctx); err != nil {
+			t.Error("Balance failed:", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if d := time.Since(t0); d > 2*time.Second {
+		t.Error("requests took", d)
+	}
+}
+
+func TestFailoverUnknownOutcome(t *testing.T) {
+	ctx := context.Background()
+	w1, w2 := newWalletMock(10, "tx1"), newWalletMock(10, "tx2")
+	defer w1.srv.Close()
+	defer w2.srv.Close()
+	w1.drop = true
+	f, err := rpc.NewFailover(&rpc.RPCConfig{Endpoints: []rpc.RPCEndpoint{{URL: w1.srv.URL}, {URL: w2.srv.URL}}})
+	if err != nil {
+		t.Fatal("NewFailover failed:", err)
+	}
+	tx, err := f.Send(ctx, "r", faucet.Coin)
+	if err == nil {
+		t.Error("Send with unknown outcome returned", tx)
+	}
+	if n1, n2 := w1.sendCount(), w2.sendCount(); n1 != 1 || n2 != 0 {
+		t.Error("send requests", n1, n2, "want 1 0")
+	}
+	if n := f.Healthy(); n != 1 {
+		t.Error(n, "healthy wallets after unknown outcome, want 1")
+	}
+
+	t0 := time.Now().Add(-time.Minute)
+	if sts, err := f.FindSent(ctx, "r", t0); err != nil || len(sts) != 0 {
+		t.Error("FindSent of unsent transaction returned", sts, err)
+	}
+
+	// The next request goes to the other wallet.
+	tx, err = f.SendComment(ctx, "r", faucet.Coin, "faucet claims 1")
+	if err != nil || tx != "tx2" {
+		t.Error("Send after unknown outcome returned", tx, err, "want tx2")
+	}
+	want := []faucet.SentTx{{TX: "tx2", Amount: faucet.Coin, Comment: "faucet claims 1"}}
+	if sts, err := f.FindSent(ctx, "r", t0); err != nil || !reflect.DeepEqual(sts, want) {
+		t.Errorf("FindSent returned %+v %v, want %+v", sts, err, want)
+	}
+	if sts, err := f.FindSent(ctx, "s", t0); err != nil || len(sts) != 0 {
+		t.Error("FindSent of another recipient returned", sts, err)
+	}
+	if sts, err := f.FindSent(ctx, "r", time.Now().Add(time.Minute)); err != nil || len(sts) != 0 {
+		t.Error("FindSent of later transactions returned", sts, err)
+	}
+}
+
+func TestFailoverCheckTx(t *testing.T) {
+	ctx := context.Background()
+	w1, w2 := newWalletMock(0, "tx1"), newWalletMock(10, "tx2")
+	defer w1.srv.Close()
+	defer w2.srv.Close()
+	f, err := rpc.NewFailover(&rpc.RPCConfig{Endpoints: []rpc.RPCEndpoint{{URL: w1.srv.URL}, {URL: w2.srv.URL}}})
+	if err != nil {
+		t.Fatal("NewFailover failed:", err)
+	}
+	tx, err := f.Send(ctx, "r", faucet.Coin)
+	if err != nil || tx != "tx2" {
+		t.Fatal("Send returned", tx, err, "want tx2")
+	}
+	if ti, err := f.CheckTx(ctx, "tx1"); err == nil {
+		t.Error("CheckTx of unknown transaction returned", ti)
+	}
+	for _, c := range []struct {
+		confs     int
+		abandoned bool
+	}{{0, false}, {3, false}, {-1, false}, {0, true}} {
+		w2.do(func() { w2.confs, w2.abandoned = c.confs, c.abandoned })
+		ti, err := f.CheckTx(ctx, tx)
+		if err != nil || ti.Confirmations != c.confs || ti.Abandoned != c.abandoned {
+			t.Errorf("CheckTx returned %+v %v, want %+v", ti, err, c)
+		}
+	}
+}
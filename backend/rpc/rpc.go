@@ -11,7 +11,6 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"math"
 	"net/http"
 	"net/url"
 	"os"
@@ -23,12 +22,32 @@ import (
 
 import (
 	"faucet"
+	"faucet/metrics"
 )
 
-type RPCConfig struct{ URL, Username, Password, CookieFile string }
+// RPCEndpoint is wallet RPC server address and credentials.
+type RPCEndpoint struct{ URL, Username, Password, CookieFile string }
+
+type RPCConfig struct {
+	RPCEndpoint `yaml:",inline"`
+
+	// Endpoints are wallets used by Failover. When it is not empty, the inline endpoint is ignored.
+	Endpoints []RPCEndpoint
+
+	// Select is SelectPriority or SelectBalance.
+	Select string
+
+	// HealthInterval is the interval of wallet health checks.
+	HealthInterval time.Duration
+}
 
 var errInvalidCookie = errors.New("invalid RPC cookie")
 
+// HTTPStatusError is returned when RPC server replies with unexpected HTTP status and no RPC error.
+type HTTPStatusError struct{ StatusCode int }
+
+func (self HTTPStatusError) Error() string { return fmt.Sprintf("RPC HTTP status %v", self.StatusCode) }
+
 type RPCError struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
@@ -37,9 +56,21 @@ type RPCError struct {
 func (self RPCError) Error() string { return fmt.Sprintf("RPC error %v %q", self.Code, self.Message) }
 
 type rpcReply struct {
-	Result interface{} `json:"result"`
-	Error  RPCError    `json:"error"`
-	ID     uint32      `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  RPCError        `json:"error"`
+	ID     uint32          `json:"id"`
+}
+
+// decodeResult decodes successful reply result into v.
+func (self *rpcReply) decodeResult(v interface{}) error {
+	if self.Error.Code != 0 {
+		return self.Error
+	}
+	err := json.Unmarshal(self.Result, v)
+	if err != nil {
+		return fmt.Errorf("unexpected RPC result %s: %w", self.Result, err)
+	}
+	return nil
 }
 
 func pipeJSON(v interface{}, w *io.PipeWriter) {
@@ -53,31 +84,35 @@ func pipeJSON(v interface{}, w *io.PipeWriter) {
 
 // RPCClient implements Bank interface using Dogecoin Core wallet.
 type RPCClient struct {
-	bal  float64
+	bal  faucet.Amount
 	balx time.Time
-	cfg  RPCConfig
+	cfg  RPCEndpoint
 	id   uint32
+	lat  *metrics.Histogram
 	m    sync.Mutex
 }
 
-func (self *RPCClient) cacheBalance(b float64) {
-	var x time.Time
-	if !math.IsNaN(b) {
-		x = time.Now().Add(time.Minute)
-	}
+func (self *RPCClient) cacheBalance(b faucet.Amount) {
 	self.m.Lock()
 	defer self.m.Unlock()
 	self.bal = b
-	self.balx = x
+	self.balx = time.Now().Add(time.Minute)
 }
 
-func (self *RPCClient) cachedBalance() float64 {
+// cachedBalance returns cached balance and whether it is still valid.
+func (self *RPCClient) cachedBalance() (faucet.Amount, bool) {
 	self.m.Lock()
 	defer self.m.Unlock()
 	if time.Now().After(self.balx) {
-		return math.NaN()
+		return 0, false
 	}
-	return self.bal
+	return self.bal, true
+}
+
+func (self *RPCClient) uncacheBalance() {
+	self.m.Lock()
+	defer self.m.Unlock()
+	self.balx = time.Time{}
 }
 
 func (self *RPCClient) readCookie() (un, pw string, err error) {
@@ -116,6 +151,8 @@ func (self *RPCClient) readCookie() (un, pw string, err error) {
 }
 
 func (self *RPCClient) rpc(ctx context.Context, method string, params ...interface{}) (*rpcReply, error) {
+	t := time.Now()
+	defer func() { self.lat.Observe(method, time.Since(t).Seconds()) }()
 	rr, rw := io.Pipe()
 	var hreq *http.Request
 	var err error
@@ -160,7 +197,7 @@ func (self *RPCClient) rpc(ctx context.Context, method string, params ...interfa
 	jres := new(rpcReply)
 	err = json.NewDecoder(hres.Body).Decode(jres)
 	if jres.Error.Code == 0 && hres.StatusCode != http.StatusOK {
-		return jres, fmt.Errorf("RPC HTTP status %v", hres.StatusCode)
+		return jres, HTTPStatusError{StatusCode: hres.StatusCode}
 	}
 	if err != nil {
 		return nil, err
@@ -176,29 +213,120 @@ func (self *RPCClient) rpc(ctx context.Context, method string, params ...interfa
 	return jres, nil
 }
 
-func (self *RPCClient) Balance(ctx context.Context) (float64, error) {
-	bal := self.cachedBalance()
-	if !math.IsNaN(bal) {
+func (self *RPCClient) Balance(ctx context.Context) (faucet.Amount, error) {
+	bal, ok := self.cachedBalance()
+	if ok {
 		return bal, nil
 	}
 	res, err := self.rpc(ctx, "getbalance")
 	if err != nil {
 		return 0, err
 	}
-	if res.Error.Code != 0 {
-		return 0, res.Error
-	}
-	bal, ok := res.Result.(float64)
-	if !ok {
-		return 0, fmt.Errorf("unexpected RPC result type: %T", res.Result)
+	err = res.decodeResult(&bal)
+	if err != nil {
+		return 0, err
 	}
 	self.cacheBalance(bal)
 	return bal, nil
 }
 
-func (self *RPCClient) Send(ctx context.Context, recipient string, amount float64) (string, error) {
-	res, err := self.rpc(ctx, "sendtoaddress", recipient, amount)
-	self.cacheBalance(math.NaN())
+func (self *RPCClient) Send(ctx context.Context, recipient string, amount faucet.Amount) (string, error) {
+	return self.SendComment(ctx, recipient, amount, "")
+}
+
+func (self *RPCClient) SendMany(ctx context.Context, amounts map[string]faucet.Amount) (string, error) {
+	return self.SendManyComment(ctx, amounts, "")
+}
+
+// SendComment sends coins with sendtoaddress call. The comment is stored in the wallet and reported by listtransactions.
+func (self *RPCClient) SendComment(ctx context.Context, recipient string, amount faucet.Amount, comment string) (string, error) {
+	params := []interface{}{recipient, amount}
+	if len(comment) > 0 {
+		params = append(params, comment)
+	}
+	res, err := self.rpc(ctx, "sendtoaddress", params...)
+	return self.sendResult(res, err)
+}
+
+// SendManyComment sends coins with sendmany call. The comment is stored in the wallet and reported by listtransactions.
+func (self *RPCClient) SendManyComment(ctx context.Context, amounts map[string]faucet.Amount, comment string) (string, error) {
+	params := []interface{}{"", amounts}
+	if len(comment) > 0 {
+		params = append(params, 1, comment)
+	}
+	res, err := self.rpc(ctx, "sendmany", params...)
+	return self.sendResult(res, err)
+}
+
+// listTxPage is the number of transactions requested by one listtransactions call.
+const listTxPage = 100
+
+// FindSent looks up outputs in the wallet transaction list with listtransactions calls, from the newest transactions.
+func (self *RPCClient) FindSent(ctx context.Context, recipient string, since time.Time) ([]faucet.SentTx, error) {
+	var sts []faucet.SentTx
+	for skip := 0; ; skip += listTxPage {
+		res, err := self.rpc(ctx, "listtransactions", "*", listTxPage, skip)
+		if err != nil {
+			return sts, err
+		}
+		var txs []struct {
+			Address  string        `json:"address"`
+			Amount   faucet.Amount `json:"amount"`
+			Category string        `json:"category"`
+			Comment  string        `json:"comment"`
+			Time     int64         `json:"time"`
+			TXID     string        `json:"txid"`
+		}
+		err = res.decodeResult(&txs)
+		if err != nil {
+			return sts, err
+		}
+		old := len(txs) < listTxPage
+		// Transactions of a page are listed from the oldest.
+		for i := len(txs) - 1; i >= 0; i-- {
+			tx := &txs[i]
+			if tx.Time < since.Unix() {
+				old = true
+				continue
+			}
+			// Amounts of sent outputs are negative.
+			if tx.Category == "send" && tx.Address == recipient {
+				sts = append(sts, faucet.SentTx{TX: tx.TXID, Amount: -tx.Amount, Comment: tx.Comment})
+			}
+		}
+		if old {
+			return sts, nil
+		}
+	}
+}
+
+// CheckTx gets the state of the transaction with gettransaction call.
+// Dogecoin Core reports negative confirmations for transactions that conflict with confirmed ones.
+func (self *RPCClient) CheckTx(ctx context.Context, tx string) (*faucet.TxInfo, error) {
+	res, err := self.rpc(ctx, "gettransaction", tx)
+	if err != nil {
+		return nil, err
+	}
+	var t struct {
+		Confirmations int `json:"confirmations"`
+		Details       []struct {
+			Abandoned bool `json:"abandoned"`
+		} `json:"details"`
+	}
+	err = res.decodeResult(&t)
+	if err != nil {
+		return nil, err
+	}
+	ti := &faucet.TxInfo{Confirmations: t.Confirmations}
+	for _, d := range t.Details {
+		ti.Abandoned = ti.Abandoned || d.Abandoned
+	}
+	return ti, nil
+}
+
+// sendResult returns transaction identifier from reply to a send request.
+func (self *RPCClient) sendResult(res *rpcReply, err error) (string, error) {
+	self.uncacheBalance()
 	if err != nil {
 		return "", err
 	}
@@ -208,20 +336,35 @@ func (self *RPCClient) Send(ctx context.Context, recipient string, amount float6
 		return "", faucet.ErrInvalidRecipient
 	case -6:
 		return "", faucet.ErrNoFunds
-	default:
-		return "", res.Error
 	}
-	tx, ok := res.Result.(string)
-	if !ok {
-		return "", fmt.Errorf("unexpected RPC result type: %T", res.Result)
+	var tx string
+	err = res.decodeResult(&tx)
+	if err != nil {
+		return "", err
 	}
 	return tx, nil
 }
 
-func NewRPCClient(cfg *RPCConfig) (*RPCClient, error) {
+// RegisterMetrics registers RPC latency metrics.
+func (self *RPCClient) RegisterMetrics(r *metrics.Registry) { r.Register(self.lat) }
+
+// newLatency creates RPC latency metric.
+func newLatency() *metrics.Histogram {
+	return metrics.NewHistogram("faucet_rpc_duration_seconds", "Wallet RPC call latency.", "method", metrics.DefBuckets)
+}
+
+func newRPCClient(cfg *RPCEndpoint, lat *metrics.Histogram) (*RPCClient, error) {
 	_, err := url.Parse(cfg.URL)
 	if err != nil {
 		return nil, err
 	}
-	return &RPCClient{cfg: *cfg}, nil
+	return &RPCClient{
+		cfg: *cfg,
+		lat: lat,
+	}, nil
+}
+
+// NewRPCClient creates a client of the inline endpoint of the configuration.
+func NewRPCClient(cfg *RPCConfig) (*RPCClient, error) {
+	return newRPCClient(&cfg.RPCEndpoint, newLatency())
 }
@@ -0,0 +1,331 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Admin API handlers
+
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+import (
+	"faucet"
+)
+
+type AdminConfig struct {
+	Prefix      string
+	Tokens      []string // Accepted bearer tokens.
+	ClientCerts []string // SHA-256 fingerprints of accepted TLS client certificates in hex.
+}
+
+func (self *AdminConfig) Configured() bool { return len(self.Tokens) > 0 || len(self.ClientCerts) > 0 }
+
+// AdminStatus defines model for admin status response.
+type AdminStatus struct {
+
+	// Whether giveaway is paused by administrator.
+	Paused bool `json:"paused"`
+
+	// Total amount of claims during rate limit period.
+	PeriodTotal faucet.Amount `json:"periodTotal"`
+
+	// Active policy window. It is absent if there is none.
+	Window *PolicyWindow `json:"window,omitempty"`
+}
+
+// AdminBan defines model for temporary ban.
+type AdminBan struct {
+
+	// Client IP address or CIDR prefix.
+	Client string `json:"client"`
+
+	// Expiry time of the ban.
+	Until time.Time `json:"until"`
+}
+
+func writeJSON(w http.ResponseWriter, st int, v interface{}, what string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(st)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Println("failed to send", what, "response:", err)
+	}
+}
+
+// adminAuth passes authorized requests to the wrapped handler.
+type adminAuth struct {
+	certs  map[[sha256.Size]byte]bool
+	h      http.Handler
+	tokens [][]byte
+}
+
+func (self *adminAuth) authorized(r *http.Request) bool {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 && self.certs[sha256.Sum256(r.TLS.PeerCertificates[0].Raw)] {
+		return true
+	}
+	const bearer = "Bearer "
+	a := r.Header.Get("Authorization")
+	if len(a) <= len(bearer) || !strings.EqualFold(a[:len(bearer)], bearer) {
+		return false
+	}
+	t := []byte(strings.TrimSpace(a[len(bearer):]))
+	ok := false
+	for _, at := range self.tokens {
+		if subtle.ConstantTimeCompare(t, at) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+func (self *adminAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if self.authorized(r) {
+		self.h.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("WWW-Authenticate", `Bearer realm="faucet admin"`)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+type adminBansHandler struct{ a faucet.Admin }
+
+func (self adminBansHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", "DELETE,GET,POST")
+	switch r.Method {
+	case "GET":
+		bs := []AdminBan{}
+		for _, b := range self.a.Bans() {
+			bs = append(bs, AdminBan{
+				Client: b.Net.String(),
+				Until:  b.Until.UTC().Round(time.Second),
+			})
+		}
+		writeJSON(w, http.StatusOK, bs, "admin bans")
+	case "DELETE", "POST":
+		q := r.URL.Query()
+		c := q.Get("client")
+		if len(c) == 0 {
+			writeJSON(w, http.StatusBadRequest, &InvalidRequest{RequestErrors: []RequestError{{
+				Error:     "MissingValue",
+				Parameter: "client",
+			}}}, "admin bans")
+			return
+		}
+		var err error
+		if r.Method == "POST" {
+			d, e := time.ParseDuration(q.Get("duration"))
+			if e != nil || d <= 0 {
+				writeJSON(w, http.StatusBadRequest, &InvalidRequest{RequestErrors: []RequestError{{
+					Error:     "InvalidValue",
+					Parameter: "duration",
+				}}}, "admin bans")
+				return
+			}
+			err = self.a.Ban(c, time.Now().Add(d))
+			if err == nil {
+				log.Println("admin: banned", c, "for", d)
+			}
+		} else {
+			err = self.a.Unban(c)
+			if err == nil {
+				log.Println("admin: unbanned", c)
+			}
+		}
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, &InvalidRequest{RequestErrors: []RequestError{{
+				Error:     "InvalidValue",
+				Parameter: "client",
+			}}}, "admin bans")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+type adminClaimsHandler struct{ a faucet.Admin }
+
+func (self adminClaimsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", "GET")
+	if r.Method != "GET" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	q, res := parseClaimQuery(r)
+	if q != nil {
+		res = self.get(r, q)
+	}
+	var st int
+	switch res.(type) {
+	case *ClaimHistory:
+		st = 200
+	case *InvalidRequest:
+		st = 400
+	case *RequestFailed:
+		st = 500
+	case *ServiceUnavailable:
+		st = 503
+	default:
+		log.Printf("unexpected admin claims response type: %T", res)
+		res = &RequestFailed{Error: "InternalError"}
+		st = 500
+	}
+	writeJSON(w, st, res, "admin claims")
+}
+
+func (self adminClaimsHandler) get(r *http.Request, q *faucet.ClaimQuery) interface{} {
+	if c := r.URL.Query().Get("client"); len(c) > 0 {
+		if !strings.ContainsRune(c, '/') {
+			if strings.ContainsRune(c, ':') {
+				c += "/128"
+			} else {
+				c += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return &InvalidRequest{RequestErrors: []RequestError{{
+				Error:     "InvalidValue",
+				Parameter: "client",
+			}}}
+		}
+		q.Client = n
+	}
+	crs, err := self.a.Claims(r.Context(), q)
+	if err != nil {
+		return errorResponse("failed to query claims:", err)
+	}
+	return claimHistory(crs, q.Limit, true)
+}
+
+type adminConfigHandler struct{ a faucet.Admin }
+
+func (self adminConfigHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", "GET")
+	if r.Method != "GET" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	y := yaml.NewEncoder(w)
+	err := y.Encode(self.a.Config())
+	if err == nil {
+		err = y.Close()
+	}
+	if err != nil {
+		log.Println("failed to send admin config response:", err)
+	}
+}
+
+type adminIntervalsHandler struct{ a faucet.Admin }
+
+func (self adminIntervalsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", "DELETE")
+	if r.Method != "DELETE" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	c := r.URL.Query().Get("client")
+	if len(c) == 0 {
+		writeJSON(w, http.StatusBadRequest, &InvalidRequest{RequestErrors: []RequestError{{
+			Error:     "MissingValue",
+			Parameter: "client",
+		}}}, "admin intervals")
+		return
+	}
+	err := self.a.ClearIntervals(c)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, &InvalidRequest{RequestErrors: []RequestError{{
+			Error:     "InvalidValue",
+			Parameter: "client",
+		}}}, "admin intervals")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminStatusHandler reports status. If method is POST, it sets paused state first.
+type adminStatusHandler struct {
+	a      faucet.Admin
+	paused *bool
+}
+
+func (self adminStatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if self.paused != nil {
+		w.Header().Set("Allow", "POST")
+		if r.Method != "POST" {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		self.a.SetPaused(*self.paused)
+		log.Println("admin: paused:", *self.paused)
+	} else {
+		w.Header().Set("Allow", "GET")
+		if r.Method != "GET" {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, &AdminStatus{
+		Paused:      self.a.Paused(),
+		PeriodTotal: self.a.PeriodTotal(),
+		Window:      policyWindow(self.a.Window()),
+	}, "admin status")
+}
+
+// RegisterAdmin registers admin API handlers on the server.
+// Handlers are not registered if authentication is not configured.
+func RegisterAdmin(s *Server, cfg *AdminConfig, a faucet.Admin) error {
+	if !cfg.Configured() {
+		return nil
+	}
+	certs := make(map[[sha256.Size]byte]bool)
+	var tokens [][]byte
+	for _, t := range cfg.Tokens {
+		tokens = append(tokens, []byte(t))
+	}
+	for _, c := range cfg.ClientCerts {
+		b, err := hex.DecodeString(strings.ReplaceAll(c, ":", ""))
+		if err != nil {
+			return err
+		}
+		var fp [sha256.Size]byte
+		if len(b) != len(fp) {
+			return hex.ErrLength
+		}
+		copy(fp[:], b)
+		certs[fp] = true
+	}
+	paused, resumed := true, false
+	for p, h := range map[string]http.Handler{
+		"/bans":      adminBansHandler{a},
+		"/claims":    adminClaimsHandler{a},
+		"/config":    adminConfigHandler{a},
+		"/intervals": adminIntervalsHandler{a},
+		"/pause":     adminStatusHandler{a: a, paused: &paused},
+		"/resume":    adminStatusHandler{a: a, paused: &resumed},
+		"/status":    adminStatusHandler{a: a},
+	} {
+		if len(cfg.Prefix) > 0 {
+			p = path.Join(cfg.Prefix, p)
+		}
+		s.Handle(p, &adminAuth{
+			certs:  certs,
+			h:      h,
+			tokens: tokens,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package server_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+import (
+	"faucet"
+	"faucet/server"
+)
+
+// adminMock implements faucet.Admin with only paused state.
+type adminMock struct{ paused bool }
+
+func (self *adminMock) Claims(ctx context.Context, q *faucet.ClaimQuery) ([]faucet.ClaimRecord, error) {
+	return nil, nil
+}
+
+func (self *adminMock) Ban(prefix string, until time.Time) error { return nil }
+func (self *adminMock) Bans() []faucet.IPRule                    { return nil }
+func (self *adminMock) ClearIntervals(prefix string) error       { return nil }
+func (self *adminMock) Config() interface{}                      { return nil }
+func (self *adminMock) Paused() bool                             { return self.paused }
+func (self *adminMock) PeriodTotal() faucet.Amount               { return 0 }
+func (self *adminMock) SetPaused(paused bool)                    { self.paused = paused }
+func (self *adminMock) Unban(prefix string) error                { return nil }
+func (self *adminMock) Window() *faucet.PolicyWindow             { return nil }
+
+func TestAdminAuth(t *testing.T) {
+	cert := &x509.Certificate{Raw: []byte("client certificate")}
+	fp := sha256.Sum256(cert.Raw)
+	cfg := &server.ServerConfig{
+		CertFile: "server.crt",
+		KeyFile:  "server.key",
+		Admin: server.AdminConfig{
+			Prefix:      "/admin",
+			Tokens:      []string{"secret1", "secret2"},
+			ClientCerts: []string{hex.EncodeToString(fp[:])},
+		},
+	}
+	s, err := server.NewServer(cfg, nil)
+	if err != nil {
+		t.Fatal("NewServer failed:", err)
+	}
+	a := new(adminMock)
+	err = server.RegisterAdmin(s, &cfg.Admin, a)
+	if err != nil {
+		t.Fatal("RegisterAdmin failed:", err)
+	}
+	for i, c := range []struct {
+		auth string
+		cert *x509.Certificate
+		st   int
+	}{
+		{"Bearer secret1", nil, 200},
+		// Every token is compared.
+		{"Bearer secret2", nil, 200},
+		{"bearer  secret2 ", nil, 200},
+		{"", cert, 200},
+		{"", nil, 401},
+		{"Bearer", nil, 401},
+		{"Bearer secret3", nil, 401},
+		{"Bearer secret", nil, 401},
+		{"Bearer secret10", nil, 401},
+		{"Basic secret1", nil, 401},
+		{"secret1", nil, 401},
+		{"Bearer wrong", &x509.Certificate{Raw: []byte("other certificate")}, 401},
+	} {
+		a.paused = false
+		r := httptest.NewRequest("POST", "/admin/pause", nil)
+		if len(c.auth) > 0 {
+			r.Header.Set("Authorization", c.auth)
+		}
+		if c.cert != nil {
+			r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{c.cert}}
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != c.st {
+			t.Errorf("case %v status %v, want %v", i, w.Code, c.st)
+		}
+		if a.paused != (c.st == 200) {
+			t.Errorf("case %v paused %v", i, a.paused)
+		}
+		if c.st == 401 && len(w.Header().Get("WWW-Authenticate")) == 0 {
+			t.Errorf("case %v has no WWW-Authenticate header", i)
+		}
+	}
+
+	cfg.Admin.ClientCerts = []string{"00:11"}
+	if err = server.RegisterAdmin(s, &cfg.Admin, a); err == nil {
+		t.Error("RegisterAdmin accepted invalid fingerprint")
+	}
+	cfg.CertFile, cfg.KeyFile = "", ""
+	if _, err = server.NewServer(cfg, nil); err == nil {
+		t.Error("NewServer accepted client certificates without TLS")
+	}
+	cfg.Admin = server.AdminConfig{Prefix: "/admin"}
+	s, err = server.NewServer(cfg, nil)
+	if err != nil {
+		t.Fatal("NewServer failed:", err)
+	}
+	err = server.RegisterAdmin(s, &cfg.Admin, a)
+	if err != nil {
+		t.Fatal("RegisterAdmin failed:", err)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/admin/status", nil))
+	if w.Code != 404 {
+		t.Error("admin API without authentication returned status", w.Code)
+	}
+}
@@ -6,20 +6,31 @@ package server
 
 import (
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"log"
 	"mime"
 	"net/http"
 	"path"
+	"strconv"
+	"strings"
 	"time"
 )
 
 import (
 	"faucet"
+	"faucet/metrics"
 )
 
 func errorResponse(msg string, err error) interface{} {
 	switch e := err.(type) {
+	case faucet.Blocked:
+		res := &ClaimRejected{RejectReason: "Blocked"}
+		if !e.Until.IsZero() {
+			res.Wait = new(time.Time)
+			*res.Wait = e.Until.UTC().Round(time.Second)
+		}
+		return res
 	case faucet.SendError:
 		log.Println(msg, e.Err)
 		return &RequestFailed{Error: "FailedToSend"}
@@ -35,13 +46,31 @@ func errorResponse(msg string, err error) interface{} {
 		return &ServiceUnavailable{Error: "ServiceUnavailable"}
 	}
 	switch err {
+	case faucet.ErrNoClaim:
+		return &InvalidRequest{RequestErrors: []RequestError{{
+			Error:     "NotFound",
+			Parameter: "id",
+		}}}
+	case faucet.ErrNoClaimLog:
+		return &ServiceUnavailable{Error: "NoClaimLog"}
 	case faucet.ErrInvalidToken:
 		return &ClaimRejected{RejectReason: "InvalidToken"}
+	case faucet.ErrCaptchaFailed:
+		return &ClaimRejected{RejectReason: "CaptchaFailed"}
+	case faucet.ErrHighRisk:
+		return &ClaimRejected{RejectReason: "HighRisk"}
+	case faucet.ErrInvalidSolution:
+		return &ClaimRejected{RejectReason: "InvalidSolution"}
 	case faucet.ErrInvalidRecipient:
 		return &InvalidRequest{RequestErrors: []RequestError{{
 			Error:     "InvalidValue",
 			Parameter: "recipient",
 		}}}
+	case faucet.ErrInvalidKey:
+		return &InvalidRequest{RequestErrors: []RequestError{{
+			Error:     "InvalidValue",
+			Parameter: "key",
+		}}}
 	case faucet.ErrPaused:
 		return &ServiceUnavailable{Error: "ServicePaused"}
 	case faucet.ErrNoFunds:
@@ -51,33 +80,233 @@ func errorResponse(msg string, err error) interface{} {
 	return &RequestFailed{Error: "InternalError"}
 }
 
+type apiMetrics struct {
+	claims *metrics.Counter
+}
+
+func newAPIMetrics() *apiMetrics {
+	m := &apiMetrics{
+		claims: metrics.NewCounter("faucet_claims_total", "Claim requests by outcome.", "outcome"),
+	}
+	m.claims.Init("success", "MustWait", "InvalidToken", "InvalidSolution", "CaptchaFailed", "Blocked", "HighRisk", "NoFunds", "FailedToSend")
+	return m
+}
+
+// claimOutcome returns the label of claim response for metrics.
+func claimOutcome(res interface{}) string {
+	switch r := res.(type) {
+	case *ClaimSucceeded:
+		return "success"
+	case *ClaimRejected:
+		return r.RejectReason
+	case *InvalidRequest:
+		return "InvalidRequest"
+	case *RequestFailed:
+		return r.Error
+	case *ServiceUnavailable:
+		return r.Error
+	}
+	return "unknown"
+}
+
 type apiServer struct {
 	faucet faucet.Faucet
+	m      *apiMetrics
 }
 
 func (self apiServer) ClaimPost(ctx context.Context, client string, body *ClaimRequest) interface{} {
+	res := self.claimPost(ctx, client, body)
+	self.m.claims.Inc(claimOutcome(res))
+	return res
+}
+
+func (self apiServer) claimPost(ctx context.Context, client string, body *ClaimRequest) interface{} {
 	if len(body.Recipient) == 0 {
 		return &InvalidRequest{RequestErrors: []RequestError{{
 			Error:     "MissingValue",
 			Parameter: "recipient",
 		}}}
 	}
-	a, tx, err := self.faucet.Claim(ctx, client, body.Recipient, body.Token)
+	a, id, tx, err := self.faucet.Claim(ctx, &faucet.ClaimRequest{
+		Client:    client,
+		Recipient: body.Recipient,
+		Token:     body.Token,
+		Solution:  body.Solution,
+		Captcha:   body.Captcha,
+		Key:       body.Key,
+	})
 	if err != nil {
 		return errorResponse("failed to send coins:", err)
 	}
 	return &ClaimSucceeded{
 		Amount: a,
+		ID:     id,
 		TXID:   tx,
 	}
 }
 
+func (self apiServer) ClaimGet(ctx context.Context, id string) interface{} {
+	if len(id) == 0 {
+		return &InvalidRequest{RequestErrors: []RequestError{{
+			Error:     "MissingValue",
+			Parameter: "id",
+		}}}
+	}
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || n <= 0 {
+		return &InvalidRequest{RequestErrors: []RequestError{{
+			Error:     "InvalidValue",
+			Parameter: "id",
+		}}}
+	}
+	cr, err := self.faucet.ClaimByID(ctx, n)
+	if err != nil {
+		return errorResponse("failed to query claim:", err)
+	}
+	return &claimHistory([]faucet.ClaimRecord{*cr}, 0, false).Claims[0]
+}
+
+// Claim history page size.
+const (
+	defClaimsLimit = 20
+	maxClaimsLimit = 100
+)
+
+// parseClaimQuery parses common claim history query parameters. Returns nil and error response if they are invalid.
+func parseClaimQuery(r *http.Request) (*faucet.ClaimQuery, interface{}) {
+	v := r.URL.Query()
+	q := &faucet.ClaimQuery{
+		Limit:     defClaimsLimit,
+		Recipient: v.Get("recipient"),
+	}
+	if s := v.Get("before"); len(s) > 0 {
+		b, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || b <= 0 {
+			return nil, &InvalidRequest{RequestErrors: []RequestError{{
+				Error:     "InvalidValue",
+				Parameter: "before",
+			}}}
+		}
+		q.Before = b
+	}
+	if s := v.Get("limit"); len(s) > 0 {
+		l, err := strconv.Atoi(s)
+		if err != nil || l <= 0 || l > maxClaimsLimit {
+			return nil, &InvalidRequest{RequestErrors: []RequestError{{
+				Error:     "InvalidValue",
+				Parameter: "limit",
+			}}}
+		}
+		q.Limit = l
+	}
+	return q, nil
+}
+
+// claimHistory converts claim records to response. Client and recipient addresses are included if full is true.
+func claimHistory(crs []faucet.ClaimRecord, limit int, full bool) *ClaimHistory {
+	res := &ClaimHistory{Claims: make([]ClaimInfo, len(crs))}
+	for i, cr := range crs {
+		ci := &res.Claims[i]
+		ci.Amount = cr.Amount
+		ci.ID = cr.ID
+		ci.Status = cr.Status.String()
+		ci.Time = cr.Time.UTC()
+		ci.TXID = hex.EncodeToString(cr.TX)
+		if full {
+			if len(cr.Client) > 0 {
+				ci.Client = cr.Client.String()
+			}
+			ci.Recipient = cr.Recipient
+		}
+	}
+	if limit > 0 && len(crs) >= limit {
+		res.Next = crs[len(crs)-1].ID
+	}
+	return res
+}
+
+func (self apiServer) ClaimsGet(ctx context.Context, q *faucet.ClaimQuery) interface{} {
+	if len(q.Recipient) == 0 {
+		return &InvalidRequest{RequestErrors: []RequestError{{
+			Error:     "MissingValue",
+			Parameter: "recipient",
+		}}}
+	}
+	crs, err := self.faucet.Claims(ctx, q)
+	if err != nil {
+		return errorResponse("failed to query claims:", err)
+	}
+	return claimHistory(crs, q.Limit, false)
+}
+
+func (self apiServer) TransactionGet(ctx context.Context, tx string) interface{} {
+	if len(tx) == 0 {
+		return &InvalidRequest{RequestErrors: []RequestError{{
+			Error:     "MissingValue",
+			Parameter: "txid",
+		}}}
+	}
+	if b, err := hex.DecodeString(tx); err != nil || len(b) != 32 {
+		return &InvalidRequest{RequestErrors: []RequestError{{
+			Error:     "InvalidValue",
+			Parameter: "txid",
+		}}}
+	}
+	crs, err := self.faucet.ClaimsByTX(ctx, tx)
+	if err == faucet.ErrNoClaim {
+		return &InvalidRequest{RequestErrors: []RequestError{{
+			Error:     "NotFound",
+			Parameter: "txid",
+		}}}
+	}
+	if err != nil {
+		return errorResponse("failed to query transaction claims:", err)
+	}
+	return &TransactionStatus{
+		Claims:        claimHistory(crs, 0, false).Claims,
+		Confirmations: crs[0].Confirmations,
+		Status:        crs[0].TxState.String(),
+		TXID:          hex.EncodeToString(crs[0].TX),
+	}
+}
+
+// policyWindow converts policy window to response. Returns nil if the window is nil.
+func policyWindow(pw *faucet.PolicyWindow) *PolicyWindow {
+	if pw == nil {
+		return nil
+	}
+	res := &PolicyWindow{
+		Amount:          pw.Amount,
+		IPClaimInterval: int64(pw.IPClaimInterval / time.Second),
+		Name:            pw.Name,
+		Paused:          pw.Pause,
+		RateLimit:       pw.RateLimit.Amount,
+	}
+	if !pw.To.IsZero() {
+		res.Until = new(time.Time)
+		*res.Until = pw.To.UTC()
+	}
+	return res
+}
+
+// schedulePoints converts amount schedule points to response.
+func schedulePoints(ps []faucet.SchedulePoint) []SchedulePoint {
+	if len(ps) == 0 {
+		return nil
+	}
+	res := make([]SchedulePoint, len(ps))
+	for i := range ps {
+		res[i] = SchedulePoint{Amount: ps[i].Amount, Level: ps[i].Level}
+	}
+	return res
+}
+
 func (self apiServer) InfoGet(ctx context.Context, client string) interface{} {
 	a, err := self.faucet.Amount(ctx)
 	if err != nil {
 		return errorResponse("failed to get giveaway amount:", err)
 	}
-	t, err := self.faucet.Token(ctx, client)
+	t, d, err := self.faucet.Token(ctx, client)
 	if err != nil {
 		return errorResponse("failed to generate token:", err)
 	}
@@ -88,12 +317,21 @@ func (self apiServer) InfoGet(ctx context.Context, client string) interface{} {
 	res := &Info{
 		AddressVersions: self.faucet.AddressVersions(),
 		Amount:          a,
+		Difficulty:      d,
 		Token:           t,
 	}
+	if s := self.faucet.Schedule(); s != nil {
+		res.Schedule = &AmountSchedule{
+			Balance: schedulePoints(s.Balance),
+			Rate:    schedulePoints(s.Rate),
+			Tiered:  s.Tiered,
+		}
+	}
 	if !w.IsZero() {
 		res.Wait = new(time.Time)
 		*res.Wait = w.UTC().Round(time.Second)
 	}
+	res.Window = policyWindow(self.faucet.Window())
 	return res
 }
 
@@ -116,8 +354,29 @@ func ensureContentType(w http.ResponseWriter, r *http.Request, ct string) bool {
 type claimHandler struct{ s apiServer }
 
 func (self claimHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Allow", "OPTIONS,POST")
+	w.Header().Set("Allow", "GET,OPTIONS,POST")
 	switch r.Method {
+	case "GET":
+		res := self.s.ClaimGet(r.Context(), r.URL.Query().Get("id"))
+		var st int
+		switch v := res.(type) {
+		case *ClaimInfo:
+			st = 200
+		case *InvalidRequest:
+			st = 400
+			if v.RequestErrors[0].Error == "NotFound" {
+				st = 404
+			}
+		case *RequestFailed:
+			st = 500
+		case *ServiceUnavailable:
+			st = 503
+		default:
+			log.Printf("unexpected /claim GET response type: %T", res)
+			res = &RequestFailed{Error: "InternalError"}
+			st = 500
+		}
+		writeJSON(w, st, res, "/claim GET")
 	case "OPTIONS":
 	case "POST":
 		if !ensureContentType(w, r, "application/json") {
@@ -171,6 +430,76 @@ func (self claimHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+type claimsHandler struct{ s apiServer }
+
+func (self claimsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", "GET,OPTIONS")
+	switch r.Method {
+	case "GET":
+		q, res := parseClaimQuery(r)
+		if q != nil {
+			res = self.s.ClaimsGet(r.Context(), q)
+		}
+		var st int
+		switch res.(type) {
+		case *ClaimHistory:
+			st = 200
+		case *InvalidRequest:
+			st = 400
+		case *RequestFailed:
+			st = 500
+		case *ServiceUnavailable:
+			st = 503
+		default:
+			log.Printf("unexpected /claims GET response type: %T", res)
+			res = &RequestFailed{Error: "InternalError"}
+			st = 500
+		}
+		writeJSON(w, st, res, "/claims GET")
+	case "OPTIONS":
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+}
+
+// transactionHandler serves status of transactions at paths with prefix p followed by transaction identifier.
+type transactionHandler struct {
+	s apiServer
+	p string
+}
+
+func (self transactionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", "GET,OPTIONS")
+	switch r.Method {
+	case "GET":
+		res := self.s.TransactionGet(r.Context(), strings.ToLower(strings.TrimPrefix(r.URL.Path, self.p)))
+		var st int
+		switch v := res.(type) {
+		case *TransactionStatus:
+			st = 200
+		case *InvalidRequest:
+			st = 400
+			if v.RequestErrors[0].Error == "NotFound" {
+				st = 404
+			}
+		case *RequestFailed:
+			st = 500
+		case *ServiceUnavailable:
+			st = 503
+		default:
+			log.Printf("unexpected /claims/{txid} GET response type: %T", res)
+			res = &RequestFailed{Error: "InternalError"}
+			st = 500
+		}
+		writeJSON(w, st, res, "/claims/{txid} GET")
+	case "OPTIONS":
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+}
+
 type infoHandler struct{ s apiServer }
 
 func (self infoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -182,6 +511,8 @@ func (self infoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		switch res.(type) {
 		case *Info:
 			st = 200
+		case *ClaimRejected:
+			st = 403
 		case *RequestFailed:
 			st = 500
 		case *ServiceUnavailable:
@@ -217,6 +548,30 @@ func registerAPIServer(mux *http.ServeMux, s apiServer, prefix string) {
 			http.Handle(p, h)
 		}
 	}
+	{
+		p := "/claims"
+		if len(prefix) > 0 {
+			p = path.Join(prefix, p)
+		}
+		h := claimsHandler{s}
+		if mux != nil {
+			mux.Handle(p, h)
+		} else {
+			http.Handle(p, h)
+		}
+	}
+	{
+		p := "/claims/"
+		if len(prefix) > 0 {
+			p = path.Join(prefix, p) + "/"
+		}
+		h := transactionHandler{s, p}
+		if mux != nil {
+			mux.Handle(p, h)
+		} else {
+			http.Handle(p, h)
+		}
+	}
 	{
 		p := "/info"
 		if len(prefix) > 0 {
@@ -0,0 +1,202 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Server-Sent Events feed of faucet activity
+
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+)
+
+import (
+	"faucet"
+)
+
+type EventsConfig struct {
+	MaxConns  int           // Maximum number of concurrent connections from one client. Zero disables the feed.
+	Heartbeat time.Duration // Interval between heartbeat comments.
+}
+
+// ClaimEvent defines model for claim event data.
+type ClaimEvent struct {
+
+	// Amount of coins sent.
+	Amount faucet.Amount `json:"amount"`
+
+	// Truncated cryptocurrency recipient address.
+	Recipient string `json:"recipient"`
+
+	// Time of the claim.
+	Time time.Time `json:"time"`
+
+	// Cryptocurrency transaction identifier (hash).
+	TXID string `json:"txid"`
+}
+
+// AmountEvent defines model for amount event data.
+type AmountEvent struct {
+
+	// Expected giveaway amount. Zero means dry or paused faucet.
+	Amount faucet.Amount `json:"amount"`
+}
+
+// BalanceEvent defines model for balance event data.
+type BalanceEvent struct {
+
+	// Bank balance.
+	Balance faucet.Amount `json:"balance"`
+}
+
+// eventData converts event to response model.
+func eventData(e *faucet.Event) interface{} {
+	switch e.Kind {
+	case faucet.EventAmount:
+		return &AmountEvent{Amount: e.Amount}
+	case faucet.EventBalance:
+		return &BalanceEvent{Balance: e.Balance}
+	case faucet.EventClaim:
+		return &ClaimEvent{
+			Amount:    e.Amount,
+			Recipient: e.Recipient,
+			Time:      e.Time.UTC(),
+			TXID:      e.TX,
+		}
+	}
+	return nil
+}
+
+// connLimiter counts connections per client.
+type connLimiter struct {
+	m   sync.Mutex
+	max int
+	n   map[string]int
+}
+
+// clientKey returns the key that connections are counted by. IPv6 clients are grouped by /64 prefix.
+func clientKey(addr string) string {
+	h, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		h = addr
+	}
+	ip := net.ParseIP(h)
+	if ip == nil {
+		return h
+	}
+	if ip.To4() == nil {
+		ip = ip.Mask(net.CIDRMask(64, 128))
+	}
+	return ip.String()
+}
+
+// acquire returns false if the client has too many connections.
+func (self *connLimiter) acquire(k string) bool {
+	self.m.Lock()
+	defer self.m.Unlock()
+	if self.n[k] >= self.max {
+		return false
+	}
+	self.n[k]++
+	return true
+}
+
+func (self *connLimiter) release(k string) {
+	self.m.Lock()
+	defer self.m.Unlock()
+	self.n[k]--
+	if self.n[k] <= 0 {
+		delete(self.n, k)
+	}
+}
+
+type eventsHandler struct {
+	es faucet.EventSource
+	hb time.Duration
+	cl *connLimiter
+}
+
+func (self *eventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", "GET,OPTIONS")
+	switch r.Method {
+	case "GET":
+	case "OPTIONS":
+		return
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	k := clientKey(r.RemoteAddr)
+	if !self.cl.acquire(k) {
+		http.Error(w, "too many connections", http.StatusTooManyRequests)
+		return
+	}
+	defer self.cl.release(k)
+	c, cancel := self.es.Subscribe()
+	defer cancel()
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	bw := bufio.NewWriter(w)
+	bw.WriteString("retry: 5000\n\n")
+	hb := time.NewTicker(self.hb)
+	defer hb.Stop()
+	for {
+		if bw.Flush() != nil {
+			return
+		}
+		f.Flush()
+		select {
+		case <-r.Context().Done():
+			return
+		case <-hb.C:
+			bw.WriteString(":\n\n")
+		case e, ok := <-c:
+			if !ok {
+				// Fell behind. The client will reconnect.
+				return
+			}
+			d, err := json.Marshal(eventData(&e))
+			if err != nil {
+				return
+			}
+			bw.WriteString("event: ")
+			bw.WriteString(e.Kind)
+			bw.WriteString("\ndata: ")
+			bw.Write(d)
+			bw.WriteString("\n\n")
+		}
+	}
+}
+
+// RegisterEvents registers Server-Sent Events endpoint at {apiprefix}/events if cfg.Events is configured.
+func RegisterEvents(s *Server, cfg *ServerConfig, es faucet.EventSource) {
+	if cfg.Events.MaxConns <= 0 {
+		return
+	}
+	hb := cfg.Events.Heartbeat
+	if hb <= 0 {
+		hb = 30 * time.Second
+	}
+	p := "/events"
+	if len(cfg.APIPrefix) > 0 {
+		p = path.Join(cfg.APIPrefix, p)
+	}
+	s.Handle(p, &eventsHandler{
+		es: es,
+		hb: hb,
+		cl: &connLimiter{
+			max: cfg.Events.MaxConns,
+			n:   make(map[string]int),
+		},
+	})
+}
@@ -8,20 +8,81 @@ import (
 	"time"
 )
 
+import (
+	"faucet"
+)
+
+// AmountSchedule defines model for AmountSchedule.
+type AmountSchedule struct {
+
+	// Points mapping bank balance to giveaway amount, ordered by increasing balance.
+	Balance []SchedulePoint `json:"balance,omitempty"`
+
+	// Points mapping total amount given away during rate limit period to giveaway amount, ordered by increasing total.
+	Rate []SchedulePoint `json:"rate,omitempty"`
+
+	// If true, the amount between points is the amount of the point below. Otherwise it is interpolated linearly.
+	Tiered bool `json:"tiered,omitempty"`
+}
+
+// ClaimHistory defines model for ClaimHistory.
+type ClaimHistory struct {
+
+	// Claims from newest to oldest.
+	Claims []ClaimInfo `json:"claims"`
+
+	// Value of "before" parameter to get the next page. It is absent if there are no more claims.
+	Next int64 `json:"next,omitempty"`
+}
+
+// ClaimInfo defines model for ClaimInfo.
+type ClaimInfo struct {
+
+	// Amount of coins sent.
+	Amount faucet.Amount `json:"amount"`
+
+	// Client IP address. It is present only in admin API.
+	Client string `json:"client,omitempty"`
+
+	// Claim record identifier.
+	ID int64 `json:"id"`
+
+	// Cryptocurrency recipient address. It is present only in admin API.
+	Recipient string `json:"recipient,omitempty"`
+
+	// Claim status: "sent", "pending" (queued for sending), "sending" or "failed".
+	Status string `json:"status"`
+
+	// Time of the claim.
+	Time time.Time `json:"time"`
+
+	// Cryptocurrency transaction identifier (hash). It is empty until the claim is sent.
+	TXID string `json:"txid"`
+}
+
 // ClaimRejected defines model for ClaimRejected.
 type ClaimRejected struct {
 	RejectReason string `json:"rejectReason"`
 
-	// The client with this IP address cannot claim coins before the given time.
+	// The client with this IP address or this recipient address cannot claim coins before the given time.
 	Wait *time.Time `json:"wait,omitempty"`
 }
 
 // ClaimRequest defines model for ClaimRequest.
 type ClaimRequest struct {
 
+	// CAPTCHA response, if CAPTCHA verification is enabled.
+	Captcha string `json:"captcha,omitempty"`
+
+	// Idempotency key. Repeated requests with the same key return the outcome of the first one instead of claiming again.
+	Key string `json:"key,omitempty"`
+
 	// Cryptocurrency recipient address.
 	Recipient string `json:"recipient"`
 
+	// Proof-of-work solution for the token, if Info has difficulty.
+	Solution string `json:"solution,omitempty"`
+
 	// The token obtained from earlier API call.
 	Token string `json:"token,omitempty"`
 }
@@ -30,10 +91,13 @@ type ClaimRequest struct {
 type ClaimSucceeded struct {
 
 	// Actual amount of coins sent.
-	Amount float64 `json:"amount"`
+	Amount faucet.Amount `json:"amount"`
 
-	// Cryptocurrency transaction identifier (hash).
-	TXID string `json:"txid"`
+	// Claim identifier. It is present if claims are logged.
+	ID int64 `json:"id,omitempty"`
+
+	// Cryptocurrency transaction identifier (hash). It is absent if the claim is queued for sending in a batch.
+	TXID string `json:"txid,omitempty"`
 }
 
 // Info defines model for Info.
@@ -43,13 +107,22 @@ type Info struct {
 	AddressVersions []uint `json:"addressVersions,omitempty"`
 
 	// Expected giveaway amount. Actual amount may differ. Zero means dry faucet.
-	Amount float64 `json:"amount"`
+	Amount faucet.Amount `json:"amount"`
+
+	// Proof-of-work difficulty. If it is present, the token is a challenge that must be solved before claiming.
+	Difficulty uint `json:"difficulty,omitempty"`
+
+	// Schedule of giveaway amount. It is absent if the amount does not depend on balance and giveaway rate this way.
+	Schedule *AmountSchedule `json:"schedule,omitempty"`
 
 	// A token that must be passed to other API calls where specified. It is valid for at least 1 hour.
 	Token string `json:"token,omitempty"`
 
 	// The client with this IP address cannot claim coins before the given time.
 	Wait *time.Time `json:"wait,omitempty"`
+
+	// Active policy window that overrides giveaway parameters. It is absent if there is none.
+	Window *PolicyWindow `json:"window,omitempty"`
 }
 
 // InvalidRequest defines model for InvalidRequest.
@@ -57,6 +130,28 @@ type InvalidRequest struct {
 	RequestErrors []RequestError `json:"requestErrors"`
 }
 
+// PolicyWindow defines model for PolicyWindow.
+type PolicyWindow struct {
+
+	// Giveaway amount during the window. It is absent if the amount is not overridden.
+	Amount faucet.Amount `json:"amount,omitempty"`
+
+	// Minimum interval in seconds between claims from the same IP address during the window. It is absent if the interval is not overridden.
+	IPClaimInterval int64 `json:"ipClaimInterval,omitempty"`
+
+	// Name of the window.
+	Name string `json:"name"`
+
+	// Whether giveaway is paused during the window.
+	Paused bool `json:"paused,omitempty"`
+
+	// Maximum total giveaway amount during rate limit period. It is absent if the rate limit is not overridden.
+	RateLimit faucet.Amount `json:"rateLimit,omitempty"`
+
+	// End of the date range of the window. It is absent if the date range is not limited.
+	Until *time.Time `json:"until,omitempty"`
+}
+
 // RequestError defines model for RequestError.
 type RequestError struct {
 
@@ -72,7 +167,33 @@ type RequestFailed struct {
 	Error string `json:"error"`
 }
 
+// SchedulePoint defines model for SchedulePoint.
+type SchedulePoint struct {
+
+	// Giveaway amount at this level.
+	Amount faucet.Amount `json:"amount"`
+
+	// Bank balance or total amount given away during rate limit period.
+	Level faucet.Amount `json:"level"`
+}
+
 // ServiceUnavailable defines model for ServiceUnavailable.
 type ServiceUnavailable struct {
 	Error string `json:"error"`
 }
+
+// TransactionStatus defines model for TransactionStatus.
+type TransactionStatus struct {
+
+	// Claims sent in the transaction, from oldest to newest.
+	Claims []ClaimInfo `json:"claims"`
+
+	// Number of confirmations when the transaction was last checked. It is negative if the transaction was double-spent.
+	Confirmations int `json:"confirmations"`
+
+	// Transaction status: "unconfirmed", "confirmed", "conflicted" (double-spent) or "abandoned".
+	Status string `json:"status"`
+
+	// Cryptocurrency transaction identifier (hash).
+	TXID string `json:"txid"`
+}
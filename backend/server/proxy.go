@@ -0,0 +1,302 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProxyHeaderTimeout limits time to receive PROXY protocol header.
+const ProxyHeaderTimeout = 10 * time.Second
+
+// proxyNets is a list of trusted proxy networks.
+type proxyNets []*net.IPNet
+
+// defProxyNets is trusted when forwarded headers are enabled without explicit trusted proxies.
+var defProxyNets = proxyNets{
+	{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(104, 128)},
+	{IP: net.IPv6loopback, Mask: net.CIDRMask(128, 128)},
+}
+
+// parseProxyNets parses IP addresses and CIDR prefixes.
+func parseProxyNets(ss []string) (proxyNets, error) {
+	pns := make(proxyNets, 0, len(ss))
+	for _, s := range ss {
+		if !strings.ContainsRune(s, '/') {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address %q", s)
+			}
+			pns = append(pns, &net.IPNet{IP: ip.To16(), Mask: net.CIDRMask(128, 128)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy prefix %q", s)
+		}
+		pns = append(pns, n)
+	}
+	return pns, nil
+}
+
+func (self proxyNets) contains(ip net.IP) bool {
+	for _, n := range self {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHostIP parses IP address with optional port number.
+func parseHostIP(a string) net.IP {
+	h, _, err := net.SplitHostPort(a)
+	if err == nil {
+		a = h
+	} else {
+		a = strings.TrimSuffix(strings.TrimPrefix(a, "["), "]")
+	}
+	return net.ParseIP(a)
+}
+
+// Headers with forwarded client addresses.
+const (
+	HeaderForwarded     = "forwarded"       // Forwarded (RFC 7239).
+	HeaderXForwardedFor = "x-forwarded-for" // X-Forwarded-For.
+)
+
+// forwarder finds addresses of clients that send requests through trusted proxies.
+type forwarder struct {
+	fwd proxyNets
+	hdr string // HeaderForwarded or HeaderXForwardedFor
+}
+
+// newForwarder checks the header name and returns forwarder that uses it. Empty name means X-Forwarded-For.
+func newForwarder(pns proxyNets, hdr string) (*forwarder, error) {
+	switch strings.ToLower(hdr) {
+	case "", HeaderXForwardedFor:
+		return &forwarder{fwd: pns, hdr: HeaderXForwardedFor}, nil
+	case HeaderForwarded:
+		return &forwarder{fwd: pns, hdr: HeaderForwarded}, nil
+	}
+	return nil, fmt.Errorf("invalid forwarded header %q", hdr)
+}
+
+// forwarded returns node identifiers from "for" parameters of Forwarded headers (RFC 7239),
+// from the nearest to the farthest hop.
+func forwarded(h http.Header) []string {
+	var fs []string
+	vs := h.Values("Forwarded")
+	for i := len(vs) - 1; i >= 0; i-- {
+		es := strings.Split(vs[i], ",")
+		for j := len(es) - 1; j >= 0; j-- {
+			f := ""
+			for _, p := range strings.Split(es[j], ";") {
+				p = strings.TrimSpace(p)
+				if len(p) > 4 && strings.EqualFold(p[:4], "for=") {
+					f = strings.Trim(p[4:], `"`)
+				}
+			}
+			fs = append(fs, f)
+		}
+	}
+	return fs
+}
+
+// xForwardedFor returns addresses from X-Forwarded-For headers, from the nearest to the farthest hop.
+func xForwardedFor(h http.Header) []string {
+	var fs []string
+	vs := h.Values("X-Forwarded-For")
+	for i := len(vs) - 1; i >= 0; i-- {
+		es := strings.Split(vs[i], ",")
+		for j := len(es) - 1; j >= 0; j-- {
+			fs = append(fs, strings.TrimSpace(es[j]))
+		}
+	}
+	return fs
+}
+
+// clientAddr returns address of the client that sent the request through trusted proxies.
+// Only the configured header is used, since a proxy passes other headers from the client unchanged.
+// Forwarded hops are walked from the nearest one while the address is trusted.
+// If a hop identifier is not an IP address (obfuscated or "unknown"), the last trusted proxy address is returned.
+func (self *forwarder) clientAddr(r *http.Request) string {
+	a := r.RemoteAddr
+	ip := parseHostIP(a)
+	if ip == nil || !self.fwd.contains(ip) {
+		return a
+	}
+	fs := xForwardedFor(r.Header)
+	if self.hdr == HeaderForwarded {
+		fs = forwarded(r.Header)
+	}
+	for _, f := range fs {
+		ip = parseHostIP(f)
+		if ip == nil {
+			break
+		}
+		a = ip.String()
+		if !self.fwd.contains(ip) {
+			break
+		}
+	}
+	return a
+}
+
+var (
+	errProxyHeader   = errors.New("invalid PROXY protocol header")
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// proxyListener accepts connections with PROXY protocol header (version 1 or 2) from trusted proxies.
+// Connections from other addresses are accepted as is.
+type proxyListener struct {
+	net.Listener
+	pns proxyNets
+}
+
+func (self *proxyListener) Accept() (net.Conn, error) {
+	c, err := self.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if a, ok := c.RemoteAddr().(*net.TCPAddr); !ok || !self.pns.contains(a.IP) {
+		return c, nil
+	}
+	return &proxyConn{Conn: c, r: bufio.NewReader(c)}, nil
+}
+
+// proxyConn reads PROXY protocol header on first use.
+type proxyConn struct {
+	net.Conn
+	once sync.Once
+	r    *bufio.Reader
+	ra   net.Addr
+	err  error
+}
+
+func (self *proxyConn) init() {
+	self.once.Do(func() {
+		self.Conn.SetReadDeadline(time.Now().Add(ProxyHeaderTimeout))
+		self.ra, self.err = readProxyHeader(self.r)
+		self.Conn.SetReadDeadline(time.Time{})
+		if self.err != nil {
+			self.Conn.Close()
+		}
+	})
+}
+
+func (self *proxyConn) Read(b []byte) (int, error) {
+	self.init()
+	if self.err != nil {
+		return 0, self.err
+	}
+	return self.r.Read(b)
+}
+
+func (self *proxyConn) RemoteAddr() net.Addr {
+	self.init()
+	if self.ra != nil {
+		return self.ra
+	}
+	return self.Conn.RemoteAddr()
+}
+
+// readProxyHeader reads PROXY protocol header and returns source address.
+// Returns nil address if the header does not contain it (v1 UNKNOWN, v2 LOCAL or not TCP).
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	b, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(b, proxyV2Signature) {
+		return readProxyV2(r)
+	}
+	if bytes.HasPrefix(b, proxyV1Prefix) {
+		return readProxyV1(r)
+	}
+	return nil, errProxyHeader
+}
+
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	// The header is at most 107 bytes including CRLF.
+	var sb strings.Builder
+	for sb.Len() < 107 {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		sb.WriteByte(c)
+		if c == '\n' {
+			break
+		}
+	}
+	s := sb.String()
+	if !strings.HasSuffix(s, "\r\n") {
+		return nil, errProxyHeader
+	}
+	fs := strings.Split(strings.TrimSuffix(s, "\r\n"), " ")
+	if len(fs) >= 2 && fs[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fs) != 6 || (fs[1] != "TCP4" && fs[1] != "TCP6") {
+		return nil, errProxyHeader
+	}
+	ip := net.ParseIP(fs[2])
+	port, err := strconv.ParseUint(fs[4], 10, 16)
+	if ip == nil || err != nil || (ip.To4() != nil) != (fs[1] == "TCP4") {
+		return nil, errProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	var h [16]byte
+	_, err := io.ReadFull(r, h[:])
+	if err != nil {
+		return nil, err
+	}
+	if h[12]>>4 != 2 {
+		return nil, errProxyHeader
+	}
+	b := make([]byte, binary.BigEndian.Uint16(h[14:]))
+	_, err = io.ReadFull(r, b)
+	if err != nil {
+		return nil, err
+	}
+	switch h[12] & 0xF {
+	case 0: // LOCAL
+		return nil, nil
+	case 1: // PROXY
+	default:
+		return nil, errProxyHeader
+	}
+	var l int
+	switch h[13] {
+	case 0x11: // TCP over IPv4
+		l = net.IPv4len
+	case 0x21: // TCP over IPv6
+		l = net.IPv6len
+	default:
+		return nil, nil
+	}
+	if len(b) < 2*l+4 {
+		return nil, errProxyHeader
+	}
+	return &net.TCPAddr{
+		IP:   append(net.IP(nil), b[:l]...),
+		Port: int(binary.BigEndian.Uint16(b[2*l:])),
+	}, nil
+}
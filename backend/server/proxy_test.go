@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package server_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	"faucet/server"
+)
+
+// addrHandler replies with remote address of the request.
+var addrHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, r.RemoteAddr) })
+
+func newAddrServer(t *testing.T, cfg *server.ServerConfig) *server.Server {
+	s, err := server.NewServer(cfg, nil)
+	if err != nil {
+		t.Fatal("NewServer failed:", err)
+	}
+	s.Handle("/addr", addrHandler)
+	return s
+}
+
+func TestForwardedAddr(t *testing.T) {
+	cfg := &server.ServerConfig{UseFwdAddr: true, TrustedProxies: []string{"192.0.2.0/24", "2001:db8::1"}}
+	xs := newAddrServer(t, cfg)
+	cfg.FwdHeader = server.HeaderForwarded
+	fs := newAddrServer(t, cfg)
+	for i, c := range []struct {
+		s      *server.Server
+		remote string
+		fwd    string // Forwarded
+		xff    []string
+		want   string
+	}{
+		// Headers from untrusted addresses are ignored.
+		{xs, "198.51.100.1:1234", "", []string{"203.0.113.1"}, "198.51.100.1:1234"},
+		{fs, "198.51.100.1:1234", "for=203.0.113.1", nil, "198.51.100.1:1234"},
+		// Hops are walked from the nearest one while they are trusted.
+		{xs, "192.0.2.1:1234", "", []string{"203.0.113.1"}, "203.0.113.1"},
+		{xs, "192.0.2.1:1234", "", []string{"203.0.113.9, 203.0.113.1, 192.0.2.2"}, "203.0.113.1"},
+		{xs, "192.0.2.1:1234", "", []string{"203.0.113.9, 203.0.113.1", "192.0.2.2"}, "203.0.113.1"},
+		{xs, "[2001:db8::1]:1234", "", []string{"2001:db8::2"}, "2001:db8::2"},
+		{fs, "192.0.2.1:1234", `for=203.0.113.9, for="[2001:db8::2]:4711";proto=https, for=192.0.2.2`, nil, "2001:db8::2"},
+		// Obfuscated and unknown hops stop the walk at the last trusted proxy.
+		{fs, "192.0.2.1:1234", "for=_hidden, for=192.0.2.2", nil, "192.0.2.2"},
+		{fs, "192.0.2.1:1234", "for=unknown", nil, "192.0.2.1:1234"},
+		{xs, "192.0.2.1:1234", "", []string{"unknown, 192.0.2.2"}, "192.0.2.2"},
+		// Only the configured header is used, so the header passed from the client is ignored.
+		{xs, "192.0.2.1:1234", "for=203.0.113.9", []string{"203.0.113.1"}, "203.0.113.1"},
+		{xs, "192.0.2.1:1234", "for=203.0.113.9", nil, "192.0.2.1:1234"},
+		{fs, "192.0.2.1:1234", "for=203.0.113.1", []string{"203.0.113.9"}, "203.0.113.1"},
+	} {
+		r := httptest.NewRequest("GET", "/addr", nil)
+		r.RemoteAddr = c.remote
+		if len(c.fwd) > 0 {
+			r.Header.Set("Forwarded", c.fwd)
+		}
+		for _, x := range c.xff {
+			r.Header.Add("X-Forwarded-For", x)
+		}
+		w := httptest.NewRecorder()
+		c.s.ServeHTTP(w, r)
+		if a := w.Body.String(); a != c.want {
+			t.Errorf("case %v address %v, want %v", i, a, c.want)
+		}
+	}
+	cfg.FwdHeader = "x-real-ip"
+	if _, err := server.NewServer(cfg, nil); err == nil {
+		t.Error("NewServer accepted invalid forwarded header")
+	}
+}
+
+// proxyV2 returns PROXY protocol version 2 header.
+func proxyV2(cmd, fam byte, addrs []byte) []byte {
+	h := append([]byte("\r\n\r\n\x00\r\nQUIT\n"), 0x20|cmd, fam, 0, 0)
+	binary.BigEndian.PutUint16(h[14:], uint16(len(addrs)))
+	return append(h, addrs...)
+}
+
+func TestProxyProtocol(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Listen failed:", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	s := newAddrServer(t, &server.ServerConfig{Listen: addr, ProxyProtocol: true})
+	sc := make(chan error, 1)
+	go func() { sc <- s.Serve() }()
+	defer func() {
+		s.Stop()
+		<-sc
+	}()
+	v4 := []byte{203, 0, 113, 1, 192, 0, 2, 1, 0x12, 0x67, 0, 80}
+	v6 := append(append(net.ParseIP("2001:db8::2"), net.ParseIP("2001:db8::1")...), 0x12, 0x67, 0, 80)
+	for i, c := range []struct {
+		hdr  []byte
+		want string // Empty if the connection must be closed.
+	}{
+		{[]byte("PROXY TCP4 203.0.113.1 192.0.2.1 4711 80\r\n"), "203.0.113.1:4711"},
+		{[]byte("PROXY TCP6 2001:db8::2 2001:db8::1 4711 80\r\n"), "[2001:db8::2]:4711"},
+		{[]byte("PROXY UNKNOWN\r\n"), "127.0.0.1:"},
+		{proxyV2(1, 0x11, v4), "203.0.113.1:4711"},
+		{proxyV2(1, 0x21, v6), "[2001:db8::2]:4711"},
+		// LOCAL command is sent by the proxy itself, like health checks.
+		{proxyV2(0, 0, nil), "127.0.0.1:"},
+		{[]byte("PROXY TCP4 203.0.113.1 192.0.2.1 4711\r\n"), ""},
+		{[]byte("PROXY TCP4 2001:db8::2 192.0.2.1 4711 80\r\n"), ""},
+		{[]byte("PROXY TCP4 203.0.113.1 192.0.2.1 4711 80\n"), ""},
+		{proxyV2(1, 0x11, v4[:8]), ""},
+		{proxyV2(2, 0x11, v4), ""},
+		{[]byte("GET /addr HTTP/1.0\r\n"), ""},
+	} {
+		var c1 net.Conn
+		for j := 0; j < 50; j++ {
+			c1, err = net.Dial("tcp", addr)
+			if err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatal("Dial failed:", err)
+		}
+		c1.SetDeadline(time.Now().Add(5 * time.Second))
+		c1.Write(c.hdr)
+		io.WriteString(c1, "GET /addr HTTP/1.0\r\n\r\n")
+		res, err := http.ReadResponse(bufio.NewReader(c1), nil)
+		var a string
+		if err == nil {
+			b, _ := ioutil.ReadAll(res.Body)
+			a = string(b)
+		}
+		c1.Close()
+		if len(c.want) == 0 {
+			if err == nil {
+				t.Errorf("case %v was served with address %v", i, a)
+			}
+			continue
+		}
+		if err != nil || !strings.HasPrefix(a, c.want) {
+			t.Errorf("case %v address %v, %v, want %v", i, a, err, c.want)
+		}
+	}
+}
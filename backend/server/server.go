@@ -5,47 +5,55 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
 	"net/http"
-	"strings"
+	"sync/atomic"
 	"time"
 )
 
 import (
 	"faucet"
+	"faucet/metrics"
 )
 
 type ServerConfig struct {
 	Listen, CertFile, KeyFile string
 	APIPrefix, PubDir         string
 	AllowOrigin               string
-	UseFwdAddr                bool
+	UseFwdAddr, ProxyProtocol bool
+	FwdHeader                 string // HeaderXForwardedFor or HeaderForwarded
+	TrustedProxies            []string
+	Admin                     AdminConfig
+	Events                    EventsConfig
+	Metrics                   string
 }
 
 type mHandler struct {
 	h           http.Handler
-	allowOrigin string
-	useFwdAddr  bool
+	allowOrigin atomic.Value // string
+	fwd         *forwarder
 }
 
 func (self *mHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if len(self.allowOrigin) > 0 {
+	if ao := self.allowOrigin.Load().(string); len(ao) > 0 {
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		w.Header().Set("Access-Control-Allow-Origin", self.allowOrigin)
-	}
-	if self.useFwdAddr {
-		for _, a := range strings.Split(r.Header.Get("X-Forwarded-For"), ",") {
-			a = strings.TrimSpace(a)
-			if len(a) > 0 {
-				r.RemoteAddr = a
-			}
-		}
+		w.Header().Set("Access-Control-Allow-Origin", ao)
+	}
+	if self.fwd != nil {
+		r.RemoteAddr = self.fwd.clientAddr(r)
 	}
 	self.h.ServeHTTP(w, r)
 }
 
 type Server struct {
+	am                *apiMetrics
 	certFile, keyFile string
 	m                 *http.ServeMux
+	mh                *mHandler
+	pp                proxyNets
 	s                 *http.Server
 	sc                chan error
 }
@@ -53,13 +61,36 @@ type Server struct {
 // Handle registers HTTP request handler for the given pattern.
 func (self *Server) Handle(pattern string, handler http.Handler) { self.m.Handle(pattern, handler) }
 
+// ServeHTTP serves the request like the HTTP server does.
+func (self *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) { self.mh.ServeHTTP(w, r) }
+
+// SetAllowOrigin replaces the origin allowed by CORS headers. Empty origin disables the headers.
+func (self *Server) SetAllowOrigin(origin string) { self.mh.allowOrigin.Store(origin) }
+
+// RegisterMetrics registers API metrics.
+func (self *Server) RegisterMetrics(r *metrics.Registry) { r.Register(self.am.claims) }
+
 // Serve listens on configured TCP address and serves HTTP requests. It returns when the server is stopped.
 func (self *Server) Serve() error {
-	var err error
-	if len(self.certFile) > 0 || len(self.keyFile) > 0 {
-		err = self.s.ListenAndServeTLS(self.certFile, self.keyFile)
+	useTLS := len(self.certFile) > 0 || len(self.keyFile) > 0
+	addr := self.s.Addr
+	if len(addr) == 0 {
+		addr = ":http"
+		if useTLS {
+			addr = ":https"
+		}
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if self.pp != nil {
+		l = &proxyListener{Listener: l, pns: self.pp}
+	}
+	if useTLS {
+		err = self.s.ServeTLS(l, self.certFile, self.keyFile)
 	} else {
-		err = self.s.ListenAndServe()
+		err = self.s.Serve(l)
 	}
 	if err == http.ErrServerClosed {
 		e, ok := <-self.sc
@@ -86,8 +117,24 @@ func (self *Server) Stop() {
 	c <- s.Close()
 }
 
-func NewServer(cfg *ServerConfig, f faucet.Faucet) *Server {
+// NewServer creates HTTP server.
+// Forwarded client addresses and PROXY protocol headers are accepted only from trusted proxies.
+// If none are configured, only loopback addresses are trusted.
+func NewServer(cfg *ServerConfig, f faucet.Faucet) (*Server, error) {
+	pns := defProxyNets
+	if len(cfg.TrustedProxies) == 0 && (cfg.UseFwdAddr || cfg.ProxyProtocol) {
+		// Proxies in other containers or hosts were trusted before trustedproxies was added.
+		log.Println("warning: trustedproxies is empty, forwarded client addresses are accepted only from loopback addresses")
+	}
+	if len(cfg.TrustedProxies) > 0 {
+		var err error
+		pns, err = parseProxyNets(cfg.TrustedProxies)
+		if err != nil {
+			return nil, err
+		}
+	}
 	self := &Server{
+		am:       newAPIMetrics(),
 		certFile: cfg.CertFile,
 		keyFile:  cfg.KeyFile,
 		m:        http.NewServeMux(),
@@ -96,14 +143,31 @@ func NewServer(cfg *ServerConfig, f faucet.Faucet) *Server {
 		},
 		sc: make(chan error, 2),
 	}
-	self.s.Handler = &mHandler{
-		h:           self.m,
-		allowOrigin: cfg.AllowOrigin,
-		useFwdAddr:  cfg.UseFwdAddr,
+	self.mh = &mHandler{h: self.m}
+	self.SetAllowOrigin(cfg.AllowOrigin)
+	if cfg.UseFwdAddr {
+		var err error
+		self.mh.fwd, err = newForwarder(pns, cfg.FwdHeader)
+		if err != nil {
+			return nil, err
+		}
+	}
+	self.s.Handler = self.mh
+	if cfg.ProxyProtocol {
+		self.pp = pns
+	}
+	if len(cfg.Admin.ClientCerts) > 0 {
+		if len(cfg.CertFile) == 0 || len(cfg.KeyFile) == 0 {
+			return nil, errors.New("admin client certificates require certfile and keyfile")
+		}
+		self.s.TLSConfig = &tls.Config{ClientAuth: tls.RequestClientCert}
 	}
 	if len(cfg.PubDir) > 0 {
 		self.m.Handle("/", http.FileServer(http.Dir(cfg.PubDir)))
 	}
-	registerAPIServer(self.m, apiServer{faucet: f}, cfg.APIPrefix)
-	return self
+	registerAPIServer(self.m, apiServer{
+		faucet: f,
+		m:      self.am,
+	}, cfg.APIPrefix)
+	return self, nil
 }
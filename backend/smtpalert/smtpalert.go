@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package smtpalert sends alerts by email.
+package smtpalert
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+import (
+	"faucet/alert"
+)
+
+type SMTPConfig struct {
+	// Server is SMTP server address with port number.
+	Server string
+
+	// Username and Password are used for PLAIN authentication if Username is not empty.
+	Username, Password string
+
+	From string
+	To   []string
+}
+
+func (self *SMTPConfig) Configured() bool { return len(self.Server) > 0 }
+
+// Timeout limits time of sending one message, including connection.
+var Timeout = 10 * time.Second
+
+// SMTP implements alert.Sender interface by sending email messages.
+// The connection is upgraded with STARTTLS if the server supports it.
+type SMTP struct {
+	auth smtp.Auth
+	cfg  SMTPConfig
+	host string
+}
+
+// message returns the email message of the alert.
+func (self *SMTP) message(a *alert.Alert) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %v\r\n", self.cfg.From)
+	fmt.Fprintf(&b, "To: %v\r\n", strings.Join(self.cfg.To, ", "))
+	fmt.Fprintf(&b, "Subject: Faucet %v alert\r\n", a.Kind)
+	fmt.Fprintf(&b, "Date: %v\r\n", a.Time.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(a.Text())
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+// Send sends the alert like smtp.SendMail, but the session is limited by Timeout,
+// so that an unresponsive server does not delay other alert senders.
+func (self *SMTP) Send(a *alert.Alert) error {
+	conn, err := net.DialTimeout("tcp", self.cfg.Server, Timeout)
+	if err != nil {
+		return err
+	}
+	err = conn.SetDeadline(time.Now().Add(Timeout))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	c, err := smtp.NewClient(conn, self.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: self.host})
+		if err != nil {
+			return err
+		}
+	}
+	if self.auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("SMTP server does not support authentication")
+		}
+		err = c.Auth(self.auth)
+		if err != nil {
+			return err
+		}
+	}
+	err = c.Mail(self.cfg.From)
+	if err != nil {
+		return err
+	}
+	for _, to := range self.cfg.To {
+		err = c.Rcpt(to)
+		if err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(self.message(a))
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// NewSMTP checks SMTP configuration and creates the sender.
+func NewSMTP(cfg *SMTPConfig) (*SMTP, error) {
+	host, _, err := net.SplitHostPort(cfg.Server)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP server address: %w", err)
+	}
+	if len(cfg.From) == 0 || len(cfg.To) == 0 {
+		return nil, errors.New("SMTP sender and recipient addresses are required")
+	}
+	self := &SMTP{cfg: *cfg, host: host}
+	if len(cfg.Username) > 0 {
+		self.auth = smtp.PlainAuth("", cfg.Username, cfg.Password, host)
+	}
+	return self, nil
+}
@@ -0,0 +1,163 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package smtpalert_test
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	"faucet"
+	"faucet/alert"
+	"faucet/smtpalert"
+)
+
+// smtpMock is a fake SMTP server that accepts one session and records it.
+type smtpMock struct {
+	l    net.Listener
+	auth string   // decoded AUTH PLAIN credentials
+	cmds []string // MAIL and RCPT commands
+	data string
+	done chan struct{}
+}
+
+func newSMTPMock(t *testing.T) *smtpMock {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Listen failed:", err)
+	}
+	self := &smtpMock{l: l, done: make(chan struct{})}
+	go self.serve()
+	return self
+}
+
+func (self *smtpMock) serve() {
+	defer close(self.done)
+	c, err := self.l.Accept()
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(c)
+	reply := func(s string) {
+		w.WriteString(s + "\r\n")
+		w.Flush()
+	}
+	reply("220 localhost ESMTP")
+	for {
+		l, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		l = strings.TrimRight(l, "\r\n")
+		cmd := strings.ToUpper(strings.SplitN(l, " ", 2)[0])
+		switch cmd {
+		case "EHLO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			f := strings.Fields(l)
+			b, _ := base64.StdEncoding.DecodeString(f[len(f)-1])
+			self.auth = string(b)
+			reply("235 Authentication succeeded")
+		case "MAIL", "RCPT":
+			self.cmds = append(self.cmds, l)
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var b strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				b.WriteString(l)
+			}
+			self.data = b.String()
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	sm := newSMTPMock(t)
+	defer sm.l.Close()
+	s, err := smtpalert.NewSMTP(&smtpalert.SMTPConfig{
+		Server:   sm.l.Addr().String(),
+		Username: "u",
+		Password: "p",
+		From:     "faucet@example.com",
+		To:       []string{"a@example.com", "b@example.com"},
+	})
+	if err != nil {
+		t.Fatal("NewSMTP failed:", err)
+	}
+	a := &alert.Alert{Kind: alert.KindRate, Amount: 100 * faucet.Coin, Period: time.Hour}
+	err = s.Send(a)
+	if err != nil {
+		t.Fatal("Send failed:", err)
+	}
+	<-sm.done
+	if sm.auth != "\x00u\x00p" {
+		t.Errorf("authenticated with %q", sm.auth)
+	}
+	want := []string{"MAIL FROM:<faucet@example.com>", "RCPT TO:<a@example.com>", "RCPT TO:<b@example.com>"}
+	if strings.Join(sm.cmds, "\n") != strings.Join(want, "\n") {
+		t.Errorf("envelope %q, want %q", sm.cmds, want)
+	}
+	for _, s := range []string{"Subject: Faucet rate alert\r\n", "To: a@example.com, b@example.com\r\n", "\r\n\r\n" + a.Text() + "\r\n"} {
+		if !strings.Contains(sm.data, s) {
+			t.Errorf("message %q does not contain %q", sm.data, s)
+		}
+	}
+
+	for _, cfg := range []smtpalert.SMTPConfig{
+		{Server: "localhost", From: "f", To: []string{"t"}},
+		{Server: "localhost:25", To: []string{"t"}},
+		{Server: "localhost:25", From: "f"},
+	} {
+		if _, err = smtpalert.NewSMTP(&cfg); err == nil {
+			t.Errorf("NewSMTP accepted %+v", cfg)
+		}
+	}
+}
+
+func TestSMTPTimeout(t *testing.T) {
+	defer func(d time.Duration) { smtpalert.Timeout = d }(smtpalert.Timeout)
+	smtpalert.Timeout = 100 * time.Millisecond
+	// The server accepts connections, but never replies.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Listen failed:", err)
+	}
+	defer l.Close()
+	s, err := smtpalert.NewSMTP(&smtpalert.SMTPConfig{
+		Server: l.Addr().String(),
+		From:   "faucet@example.com",
+		To:     []string{"a@example.com"},
+	})
+	if err != nil {
+		t.Fatal("NewSMTP failed:", err)
+	}
+	t0 := time.Now()
+	if err = s.Send(&alert.Alert{Kind: alert.KindRate}); err == nil {
+		t.Error("Send to unresponsive server succeeded")
+	}
+	if d := time.Since(t0); d > 5*time.Second {
+		t.Error("Send to unresponsive server took", d)
+	}
+}
@@ -6,6 +6,7 @@ package sqldb
 import (
 	"database/sql"
 	"net"
+	"strings"
 	"time"
 )
 
@@ -13,14 +14,14 @@ import (
 	"faucet"
 )
 
-// Driver-specific SQL code to create needed tables.
-var CreateSQL = make(map[string][]string)
-
 type ErrUnsupportedDriver struct{ Driver string }
 
 func (self ErrUnsupportedDriver) Error() string { return "unsupported SQL driver " + self.Driver }
 
-type cli struct{ rs *sql.Rows }
+type cli struct {
+	d  *Dialect
+	rs *sql.Rows
+}
 
 func (self cli) Close() error {
 	err := self.rs.Close()
@@ -30,56 +31,191 @@ func (self cli) Close() error {
 	return self.rs.Err()
 }
 
-func (self cli) Get(t *time.Time, client *net.IP, amount *float64) error {
-	return self.rs.Scan(t, client, amount)
+func (self cli) Get(t *time.Time, client *net.IP, recipient *string, amount *faucet.Amount) error {
+	return self.rs.Scan(t, self.d.ipScanner(client), recipient, amount)
 }
 
 func (self cli) Next() bool { return self.rs.Next() }
 
-type DBConfig struct{ Driver, Source string }
+type DBConfig struct {
+	Driver, Source string
+
+	// Shared is whether rate limiting records are stored in the database, so that faucet instances using it share rate limits.
+	Shared bool
+}
 
 func (self *DBConfig) Configured() bool { return len(self.Driver) > 0 }
 
 type DB struct {
+	d  *Dialect
 	db *sql.DB
 	dn string
 }
 
+// claimColumns are columns that scanClaim reads.
+const claimColumns = `"id","time","client","recipient","amount","txid","status","confirmations","tx_state"`
+
+// scanClaim reads claimColumns of current row.
+func (self *DB) scanClaim(rs *sql.Rows) (faucet.ClaimRecord, error) {
+	var cr faucet.ClaimRecord
+	err := rs.Scan(&cr.ID, &cr.Time, self.d.ipScanner(&cr.Client), &cr.Recipient, &cr.Amount, &cr.TX, &cr.Status, &cr.Confirmations, &cr.TxState)
+	return cr, err
+}
+
+// queryClaims returns claim records selected by the query.
+func (self *DB) queryClaims(query string, args ...interface{}) ([]faucet.ClaimRecord, error) {
+	rs, err := self.db.Query(self.d.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if rs != nil {
+			rs.Close()
+		}
+	}()
+	var crs []faucet.ClaimRecord
+	for rs.Next() {
+		cr, err := self.scanClaim(rs)
+		if err != nil {
+			return nil, err
+		}
+		crs = append(crs, cr)
+	}
+	err = rs.Close()
+	if err == nil {
+		err = rs.Err()
+	}
+	rs = nil
+	return crs, err
+}
+
+func (self *DB) ClaimByID(id int64) (*faucet.ClaimRecord, error) {
+	crs, err := self.queryClaims(`SELECT `+claimColumns+`FROM"claims"WHERE"id"=?`, id)
+	if err != nil || len(crs) == 0 {
+		return nil, err
+	}
+	return &crs[0], nil
+}
+
+func (self *DB) ClaimByKey(key string) (*faucet.ClaimRecord, error) {
+	crs, err := self.queryClaims(`SELECT `+claimColumns+`FROM"claims"WHERE"idempotency_key"=?`, key)
+	if err != nil || len(crs) == 0 {
+		return nil, err
+	}
+	return &crs[0], nil
+}
+
+func (self *DB) ClaimsByTX(tx []byte) ([]faucet.ClaimRecord, error) {
+	return self.queryClaims(`SELECT `+claimColumns+`FROM"claims"WHERE"txid"=? AND"status"=? ORDER BY"id"`, tx, faucet.ClaimSent)
+}
+
 func (self *DB) ClaimsSince(t time.Time) (faucet.ClaimLogIter, error) {
-	rs, err := self.db.Query(`SELECT"time","client","amount"FROM"claims"WHERE"time">=?`, t.UTC())
+	rs, err := self.db.Query(self.d.Rebind(`SELECT"time","client","recipient","amount"FROM"claims"WHERE"time">=? AND"status"<>?`), t.UTC(), faucet.ClaimFailed)
 	if err != nil {
 		return nil, err
 	}
-	return cli{rs}, nil
+	return cli{
+		d:  self.d,
+		rs: rs,
+	}, nil
 }
 
 func (self *DB) Close() error { return self.db.Close() }
 
-func (self *DB) CreateTables() error {
-	csql := CreateSQL[self.dn]
-	if len(csql) == 0 {
-		return ErrUnsupportedDriver{Driver: self.dn}
+func (self *DB) LogClaim(t time.Time, client net.IP, recipient string, amount faucet.Amount, tx []byte) error {
+	if tx == nil {
+		tx = []byte{}
 	}
-	for _, s := range csql {
-		_, err := self.db.Exec(s)
-		if err != nil {
-			return err
-		}
+	_, err := self.db.Exec(self.d.Rebind(`INSERT INTO"claims"("time","client","recipient","amount","txid")VALUES(?,?,?,?,?)`), t.UTC(), self.d.ipValue(client), recipient, amount, tx)
+	return err
+}
+
+func (self *DB) LogPendingClaim(t time.Time, client net.IP, recipient string, amount faucet.Amount, key string, status faucet.ClaimStatus) (int64, error) {
+	s := `INSERT INTO"claims"("time","client","recipient","amount","txid","status","idempotency_key")VALUES(?,?,?,?,?,?,?)`
+	k := sql.NullString{String: key, Valid: len(key) > 0}
+	args := []interface{}{t.UTC(), self.d.ipValue(client), recipient, amount, []byte{}, status, k}
+	if self.d.ReturningID {
+		var id int64
+		err := self.db.QueryRow(self.d.Rebind(s+`RETURNING"id"`), args...).Scan(&id)
+		return id, err
+	}
+	r, err := self.db.Exec(self.d.Rebind(s), args...)
+	if err != nil {
+		return 0, err
+	}
+	return r.LastInsertId()
+}
+
+func (self *DB) PendingClaims() ([]faucet.ClaimRecord, error) {
+	return self.queryClaims(`SELECT `+claimColumns+`FROM"claims"WHERE"status"IN(?,?)ORDER BY"id"`, faucet.ClaimPending, faucet.ClaimSending)
+}
+
+// QueryClaims returns claim records selected by the query, from newest to oldest.
+func (self *DB) QueryClaims(q *faucet.ClaimQuery) ([]faucet.ClaimRecord, error) {
+	var (
+		args  []interface{}
+		conds []string
+	)
+	if q.Before > 0 {
+		conds = append(conds, `"id"<?`)
+		args = append(args, q.Before)
+	}
+	if q.Client != nil {
+		c, a := self.d.ipNetCond(`"client"`, q.Client)
+		conds = append(conds, c)
+		args = append(args, a...)
+	}
+	if len(q.Recipient) > 0 {
+		conds = append(conds, `"recipient"=?`)
+		args = append(args, q.Recipient)
+	}
+	s := `SELECT ` + claimColumns + `FROM"claims"`
+	if len(conds) > 0 {
+		s += `WHERE ` + strings.Join(conds, ` AND `)
 	}
-	return nil
+	s += ` ORDER BY"id"DESC LIMIT ?`
+	args = append(args, q.Limit)
+	return self.queryClaims(s, args...)
 }
 
-func (self *DB) LogClaim(t time.Time, client net.IP, recipient string, amount float64, tx []byte) error {
-	_, err := self.db.Exec(`INSERT INTO"claims"("time","client","recipient","amount","txid")VALUES(?,?,?,?,?)`, t.UTC(), client, recipient, amount, tx)
+func (self *DB) SetClaimStatus(ids []int64, status faucet.ClaimStatus, tx []byte) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	s := `UPDATE"claims"SET"status"=?`
+	args := []interface{}{status}
+	if tx != nil {
+		s += `,"txid"=?`
+		args = append(args, tx)
+	}
+	s += `WHERE"id"IN(?` + strings.Repeat(`,?`, len(ids)-1) + `)`
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	_, err := self.db.Exec(self.d.Rebind(s), args...)
 	return err
 }
 
+func (self *DB) SetTxState(tx []byte, state faucet.TxState, confirmations int) error {
+	_, err := self.db.Exec(self.d.Rebind(`UPDATE"claims"SET"tx_state"=?,"confirmations"=? WHERE"txid"=? AND"status"=?`), state, confirmations, tx, faucet.ClaimSent)
+	return err
+}
+
+func (self *DB) UnconfirmedClaims(since time.Time) ([]faucet.ClaimRecord, error) {
+	return self.queryClaims(`SELECT `+claimColumns+`FROM"claims"WHERE"time">=? AND"status"=? AND"tx_state"=? ORDER BY"id"`, since.UTC(), faucet.ClaimSent, faucet.TxUnconfirmed)
+}
+
 func NewDB(cfg *DBConfig) (*DB, error) {
 	db, err := sql.Open(cfg.Driver, cfg.Source)
 	if err != nil {
 		return nil, err
 	}
+	d := Dialects[cfg.Driver]
+	if d == nil {
+		d = defDialect
+	}
 	return &DB{
+		d:  d,
 		db: db,
 		dn: cfg.Driver,
 	}, nil
@@ -0,0 +1,367 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package sqldb_test
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+import (
+	"faucet"
+	"faucet/sqldb"
+)
+
+func TestMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqldb")
+	if err != nil {
+		t.Fatal("failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Database of current version created from scratch for comparison.
+	fn := filepath.Join(dir, "new.sqlite")
+	db, err := sqldb.NewDB(&sqldb.DBConfig{Driver: "sqlite3", Source: fn})
+	if err != nil {
+		t.Fatal("NewDB failed:", err)
+	}
+	defer db.Close()
+	v, err := db.Version()
+	if err != nil || v != 0 {
+		t.Fatal("Version of empty database:", v, err)
+	}
+	_, err = db.Migrate()
+	if err == nil {
+		t.Error("Migrate of empty database succeeded")
+	}
+	err = db.CreateTables()
+	if err != nil {
+		t.Fatal("CreateTables failed:", err)
+	}
+	err = db.CreateTables()
+	if err == nil {
+		t.Error("CreateTables succeeded on existing tables")
+	}
+	v, err = db.Version()
+	if err != nil || v != sqldb.SchemaVersion {
+		t.Fatal("Version of new database:", v, err)
+	}
+	ndb, err := sql.Open("sqlite3", fn)
+	if err != nil {
+		t.Fatal("failed to open database:", err)
+	}
+	defer ndb.Close()
+
+	// Database created by the first version.
+	fn = filepath.Join(dir, "old.sqlite")
+	odb, err := sql.Open("sqlite3", fn)
+	if err != nil {
+		t.Fatal("failed to open database:", err)
+	}
+	defer odb.Close()
+	for _, s := range []string{`CREATE TABLE "claims" (
+  "id" INTEGER NOT NULL PRIMARY KEY,
+  "time" DATETIME NOT NULL,
+  "client" BLOB(16) NOT NULL,
+  "recipient" VARCHAR(35) COLLATE BINARY NOT NULL,
+  "amount" REAL NOT NULL,
+  "txid" BLOB(32) NOT NULL
+)`, `CREATE INDEX "claim_time" ON "claims" ("time")`} {
+		_, err = odb.Exec(s)
+		if err != nil {
+			t.Fatal("failed to create legacy table:", err)
+		}
+	}
+	t0 := time.Now().UTC().Truncate(time.Second)
+	_, err = odb.Exec(`INSERT INTO"claims"("time","client","recipient","amount","txid")VALUES(?,?,?,?,?)`, t0, net.ParseIP("1.2.3.4").To16(), "r", 0.3, []byte{1})
+	if err != nil {
+		t.Fatal("failed to insert legacy record:", err)
+	}
+	db, err = sqldb.NewDB(&sqldb.DBConfig{Driver: "sqlite3", Source: fn})
+	if err != nil {
+		t.Fatal("NewDB failed:", err)
+	}
+	defer db.Close()
+	v, err = db.Version()
+	if err != nil || v != 1 {
+		t.Fatal("Version of legacy database:", v, err)
+	}
+	for i, want := range []int{1, sqldb.SchemaVersion} {
+		v, err = db.Migrate()
+		if err != nil {
+			t.Fatal("Migrate failed:", err)
+		}
+		if v != want {
+			t.Error("Migrate run", i+1, "returned version", v, "want", want)
+		}
+	}
+	v, err = db.Version()
+	if err != nil || v != sqldb.SchemaVersion {
+		t.Fatal("Version of migrated database:", v, err)
+	}
+	schema := func(db *sql.DB) map[string]string {
+		rs, err := db.Query(`SELECT "name","sql" FROM "sqlite_master"`)
+		if err != nil {
+			t.Fatal("failed to query schema:", err)
+		}
+		defer rs.Close()
+		m := make(map[string]string)
+		for rs.Next() {
+			var n, s string
+			err = rs.Scan(&n, &s)
+			if err != nil {
+				t.Fatal("failed to read schema:", err)
+			}
+			m[n] = s
+		}
+		return m
+	}
+	nsc := schema(ndb)
+	osc := schema(odb)
+	for n, s := range nsc {
+		if osc[n] != s {
+			t.Errorf("migrated schema of %v: %q, want %q", n, osc[n], s)
+		}
+	}
+	if len(osc) != len(nsc) {
+		t.Error("migrated schema has", len(osc), "objects, want", len(nsc))
+	}
+
+	err = db.LogClaim(t0, net.ParseIP("1.2.3.5").To16(), "r", faucet.Coin/10, []byte{2})
+	if err != nil {
+		t.Fatal("LogClaim failed:", err)
+	}
+	cli, err := db.ClaimsSince(t0.Add(-time.Second))
+	if err != nil {
+		t.Fatal("ClaimsSince failed:", err)
+	}
+	var (
+		ta  faucet.Amount
+		ct  time.Time
+		ip  net.IP
+		r   string
+		amt faucet.Amount
+	)
+	for cli.Next() {
+		err = cli.Get(&ct, &ip, &r, &amt)
+		if err != nil {
+			t.Fatal("Get failed:", err)
+		}
+		ta += amt
+	}
+	err = cli.Close()
+	if err != nil {
+		t.Fatal("Close failed:", err)
+	}
+	if ta != faucet.Coin/10*4 {
+		t.Error("total amount", ta, "want 0.4")
+	}
+}
+
+func TestQueryClaims(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqldb")
+	if err != nil {
+		t.Fatal("failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "claims.sqlite")
+	cfg := &sqldb.DBConfig{Driver: "sqlite3", Source: fn}
+	db, err := sqldb.NewDB(cfg)
+	if err != nil {
+		t.Fatal("NewDB failed:", err)
+	}
+	defer db.Close()
+	err = db.CreateTables()
+	if err != nil {
+		t.Fatal("CreateTables failed:", err)
+	}
+	t0 := time.Now().UTC().Truncate(time.Second)
+	ips := []net.IP{net.ParseIP("1.2.3.4"), net.ParseIP("1.2.4.5"), net.ParseIP("2001:db8::1")}
+	for i := 0; i < 9; i++ {
+		err = db.LogClaim(t0.Add(time.Duration(i)*time.Second), ips[i%3], fmt.Sprint("r", i%2), faucet.Amount(i+1), []byte{byte(i)})
+		if err != nil {
+			t.Fatal("LogClaim failed:", err)
+		}
+	}
+	_, n24, _ := net.ParseCIDR("1.2.3.0/24")
+	_, n6, _ := net.ParseCIDR("2001:db8::/32")
+	for _, c := range [...]struct {
+		q   faucet.ClaimQuery
+		ids []int64
+	}{
+		{faucet.ClaimQuery{Limit: 100}, []int64{9, 8, 7, 6, 5, 4, 3, 2, 1}},
+		{faucet.ClaimQuery{Limit: 3}, []int64{9, 8, 7}},
+		{faucet.ClaimQuery{Before: 7, Limit: 3}, []int64{6, 5, 4}},
+		{faucet.ClaimQuery{Limit: 100, Recipient: "r1"}, []int64{8, 6, 4, 2}},
+		{faucet.ClaimQuery{Before: 6, Limit: 100, Recipient: "r1"}, []int64{4, 2}},
+		{faucet.ClaimQuery{Client: n24, Limit: 100}, []int64{7, 4, 1}},
+		{faucet.ClaimQuery{Client: n6, Limit: 100, Recipient: "r0"}, []int64{9, 3}},
+		{faucet.ClaimQuery{Limit: 100, Recipient: "x"}, nil},
+	} {
+		for _, d := range []string{"sqlite3", "fakemysql"} {
+			cfg.Driver = d
+			db, err := sqldb.NewDB(cfg)
+			if err != nil {
+				t.Fatal(d, "NewDB failed:", err)
+			}
+			crs, err := db.QueryClaims(&c.q)
+			db.Close()
+			if err != nil {
+				t.Fatal(d, "QueryClaims failed:", err)
+			}
+			var ids []int64
+			for _, cr := range crs {
+				ids = append(ids, cr.ID)
+				i := int(cr.ID - 1)
+				if !cr.Client.Equal(ips[i%3]) || cr.Amount != faucet.Amount(i+1) || !cr.Time.Equal(t0.Add(time.Duration(i)*time.Second)) || len(cr.TX) != 1 || cr.TX[0] != byte(i) {
+					t.Error(d, "record", cr.ID, "got", cr)
+				}
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(c.ids) {
+				t.Errorf("%v %+v: got %v, want %v", d, c.q, ids, c.ids)
+			}
+		}
+	}
+}
+
+func TestPendingClaims(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqldb")
+	if err != nil {
+		t.Fatal("failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+	t0 := time.Now().UTC().Truncate(time.Second)
+	for _, d := range []string{"sqlite3", "fakemysql"} {
+		cfg := &sqldb.DBConfig{Driver: "sqlite3", Source: filepath.Join(dir, d+".sqlite")}
+		db, err := sqldb.NewDB(cfg)
+		if err != nil {
+			t.Fatal("NewDB failed:", err)
+		}
+		err = db.CreateTables()
+		db.Close()
+		if err != nil {
+			t.Fatal("CreateTables failed:", err)
+		}
+		cfg.Driver = d
+		db, err = sqldb.NewDB(cfg)
+		if err != nil {
+			t.Fatal(d, "NewDB failed:", err)
+		}
+		err = db.LogClaim(t0, net.ParseIP("1.2.3.4"), "r0", faucet.Coin, []byte{1})
+		if err != nil {
+			t.Fatal(d, "LogClaim failed:", err)
+		}
+		for i := int64(2); i <= 4; i++ {
+			id, err := db.LogPendingClaim(t0, net.ParseIP("1.2.3.4"), fmt.Sprint("r", i), faucet.Coin, fmt.Sprint("k", i), faucet.ClaimPending)
+			if err != nil {
+				t.Fatal(d, "LogPendingClaim failed:", err)
+			}
+			if id != i {
+				t.Error(d, "LogPendingClaim returned", id, "want", i)
+			}
+		}
+		err = db.SetClaimStatus([]int64{2, 3}, faucet.ClaimSending, nil)
+		if err != nil {
+			t.Fatal(d, "SetClaimStatus failed:", err)
+		}
+		err = db.SetClaimStatus([]int64{2}, faucet.ClaimSent, []byte{2})
+		if err != nil {
+			t.Fatal(d, "SetClaimStatus failed:", err)
+		}
+		err = db.SetClaimStatus([]int64{4}, faucet.ClaimFailed, nil)
+		if err != nil {
+			t.Fatal(d, "SetClaimStatus failed:", err)
+		}
+		_, err = db.LogPendingClaim(t0, net.ParseIP("1.2.3.4"), "r5", faucet.Coin, "", faucet.ClaimPending)
+		if err != nil {
+			t.Fatal(d, "LogPendingClaim failed:", err)
+		}
+		crs, err := db.PendingClaims()
+		if err != nil {
+			t.Fatal(d, "PendingClaims failed:", err)
+		}
+		if len(crs) != 2 || crs[0].ID != 3 || crs[0].Status != faucet.ClaimSending || crs[1].ID != 5 || crs[1].Status != faucet.ClaimPending {
+			t.Error(d, "PendingClaims returned", crs)
+		}
+		for _, c := range [...]struct {
+			id int64
+			st faucet.ClaimStatus
+			tx string
+		}{
+			{1, faucet.ClaimSent, "\x01"},
+			{2, faucet.ClaimSent, "\x02"},
+			{4, faucet.ClaimFailed, ""},
+		} {
+			cr, err := db.ClaimByID(c.id)
+			if err != nil {
+				t.Fatal(d, "ClaimByID failed:", err)
+			}
+			if cr == nil || cr.ID != c.id || cr.Status != c.st || string(cr.TX) != c.tx || cr.Amount != faucet.Coin || !cr.Time.Equal(t0) {
+				t.Error(d, "ClaimByID returned", cr)
+			}
+		}
+		cr, err := db.ClaimByID(6)
+		if err != nil || cr != nil {
+			t.Error(d, "ClaimByID of missing claim returned", cr, err)
+		}
+		cr, err = db.ClaimByKey("k3")
+		if err != nil || cr == nil || cr.ID != 3 || cr.Recipient != "r3" {
+			t.Error(d, "ClaimByKey returned", cr, err)
+		}
+		cr, err = db.ClaimByKey("k5")
+		if err != nil || cr != nil {
+			t.Error(d, "ClaimByKey of missing key returned", cr, err)
+		}
+		_, err = db.LogPendingClaim(t0, net.ParseIP("1.2.3.4"), "r6", faucet.Coin, "k2", faucet.ClaimSending)
+		if err == nil {
+			t.Error(d, "LogPendingClaim accepted duplicate key")
+		}
+		_, err = db.LogPendingClaim(t0, net.ParseIP("1.2.3.4"), "r6", faucet.Coin, "", faucet.ClaimSending)
+		if err != nil {
+			t.Error(d, "LogPendingClaim of another claim without key failed:", err)
+		}
+		// Failed claims are not counted.
+		cli, err := db.ClaimsSince(t0)
+		if err != nil {
+			t.Fatal(d, "ClaimsSince failed:", err)
+		}
+		n := 0
+		for cli.Next() {
+			n++
+		}
+		err = cli.Close()
+		if err != nil {
+			t.Fatal(d, "Close failed:", err)
+		}
+		if n != 5 {
+			t.Error(d, "ClaimsSince returned", n, "records, want 5")
+		}
+		crs, err = db.UnconfirmedClaims(t0)
+		if err != nil || len(crs) != 2 || crs[0].ID != 1 || crs[1].ID != 2 {
+			t.Error(d, "UnconfirmedClaims returned", crs, err)
+		}
+		err = db.SetTxState([]byte{2}, faucet.TxConfirmed, 6)
+		if err != nil {
+			t.Fatal(d, "SetTxState failed:", err)
+		}
+		crs, err = db.ClaimsByTX([]byte{2})
+		if err != nil || len(crs) != 1 || crs[0].ID != 2 || crs[0].TxState != faucet.TxConfirmed || crs[0].Confirmations != 6 {
+			t.Error(d, "ClaimsByTX returned", crs, err)
+		}
+		crs, err = db.UnconfirmedClaims(t0)
+		if err != nil || len(crs) != 1 || crs[0].ID != 1 {
+			t.Error(d, "UnconfirmedClaims after SetTxState returned", crs, err)
+		}
+		crs, err = db.UnconfirmedClaims(t0.Add(time.Second))
+		if err != nil || len(crs) != 0 {
+			t.Error(d, "UnconfirmedClaims of later claims returned", crs, err)
+		}
+		db.Close()
+	}
+}
@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package sqldb
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Dialect describes differences between SQL dialects that matter for queries issued by DB.
+type Dialect struct {
+	// NumberedParams is whether query parameters are written as $1, $2 and so on instead of ?.
+	NumberedParams bool
+
+	// Quote is the character used to quote identifiers.
+	Quote byte
+
+	// ReturningID is whether identifier of inserted row is obtained with RETURNING clause instead of LastInsertId.
+	ReturningID bool
+
+	// TextIP is whether IP addresses are stored in textual form (as in PostgreSQL inet type) instead of 16 bytes.
+	TextIP bool
+}
+
+// Driver-specific SQL dialects.
+var Dialects = make(map[string]*Dialect)
+
+var defDialect = &Dialect{Quote: '"'}
+
+// Rebind converts a query written with ? parameters and double-quoted identifiers to this dialect.
+// The query must not contain string literals with these characters.
+func (self *Dialect) Rebind(query string) string {
+	if !self.NumberedParams && self.Quote == '"' {
+		return query
+	}
+	var sb strings.Builder
+	n := 0
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '?' && self.NumberedParams:
+			n++
+			sb.WriteByte('$')
+			sb.WriteString(strconv.Itoa(n))
+		case c == '"':
+			sb.WriteByte(self.Quote)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// ipValue converts IP address to query parameter.
+func (self *Dialect) ipValue(ip net.IP) interface{} {
+	if self.TextIP {
+		return ip.String()
+	}
+	return []byte(ip.To16())
+}
+
+// ipNetCond returns SQL condition and parameters that select IP addresses in the network.
+func (self *Dialect) ipNetCond(col string, n *net.IPNet) (string, []interface{}) {
+	if self.TextIP {
+		return col + `<<=CAST(? AS INET)`, []interface{}{n.String()}
+	}
+	lo := n.IP.To16()
+	m := n.Mask
+	if len(m) == net.IPv4len {
+		m = append(net.CIDRMask(96, 128)[:12], m...)
+	}
+	hi := make([]byte, net.IPv6len)
+	for i := range hi {
+		hi[i] = lo[i] | ^m[i]
+	}
+	return col + `BETWEEN ? AND ?`, []interface{}{[]byte(lo.Mask(m)), hi}
+}
+
+// ipScanner scans IP address from a column.
+type ipScanner struct {
+	ip   *net.IP
+	text bool
+}
+
+func (self ipScanner) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into IP address", src)
+	}
+	if !self.text {
+		if len(b) != net.IPv6len && len(b) != net.IPv4len {
+			return fmt.Errorf("invalid IP address length %v", len(b))
+		}
+		*self.ip = append(net.IP(nil), b...).To16()
+		return nil
+	}
+	s := string(b)
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		s = s[:i]
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return fmt.Errorf("invalid IP address %q", s)
+	}
+	*self.ip = ip.To16()
+	return nil
+}
+
+func (self *Dialect) ipScanner(ip *net.IP) ipScanner { return ipScanner{ip: ip, text: self.TextIP} }
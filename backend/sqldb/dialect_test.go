@@ -0,0 +1,239 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package sqldb_test
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+import (
+	"faucet"
+	"faucet/sqldb"
+)
+
+// fakeDriver emulates query syntax of other SQL dialects on top of SQLite.
+// It rejects placeholders and identifier quotes that the emulated dialect does not accept,
+// and records translated queries.
+type fakeDriver struct {
+	d        sqlite3.SQLiteDriver
+	m        sync.Mutex
+	numbered bool
+	qs       []string
+	quote    byte
+}
+
+// prepared returns whether a query that contains s was prepared.
+func (self *fakeDriver) prepared(s string) bool {
+	self.m.Lock()
+	defer self.m.Unlock()
+	for _, q := range self.qs {
+		if strings.Contains(q, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *fakeDriver) Open(name string) (driver.Conn, error) {
+	c, err := self.d.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return fakeConn{
+		Conn: c,
+		d:    self,
+	}, nil
+}
+
+func (self *fakeDriver) translate(query string) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch c {
+		case '?':
+			if self.numbered {
+				return "", fmt.Errorf("syntax error at or near \"?\" in %q", query)
+			}
+		case '$':
+			if !self.numbered {
+				return "", fmt.Errorf("syntax error near '$' in %q", query)
+			}
+			// Like PostgreSQL 15, reject parameters that are followed by identifier characters.
+			j := i + 1
+			for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+				j++
+			}
+			if j < len(query) && (query[j] == '_' || (query[j]|0x20) >= 'a' && (query[j]|0x20) <= 'z') {
+				return "", fmt.Errorf("trailing junk after parameter at or near %q in %q", query[i:j+1], query)
+			}
+			sb.WriteByte('?')
+			i = j - 1
+			continue
+		case '"', '`':
+			if c != self.quote {
+				return "", fmt.Errorf("syntax error near '%c' in %q", c, query)
+			}
+			c = '"'
+		}
+		sb.WriteByte(c)
+	}
+	self.m.Lock()
+	self.qs = append(self.qs, query)
+	self.m.Unlock()
+	return sb.String(), nil
+}
+
+type fakeConn struct {
+	driver.Conn
+	d *fakeDriver
+}
+
+func (self fakeConn) Prepare(query string) (driver.Stmt, error) {
+	q, err := self.d.translate(query)
+	if err != nil {
+		return nil, err
+	}
+	return self.Conn.Prepare(q)
+}
+
+var fakePostgres = &fakeDriver{
+	numbered: true,
+	quote:    '"',
+}
+
+func init() {
+	sql.Register("fakepostgres", fakePostgres)
+	sqldb.Dialects["fakepostgres"] = sqldb.Dialects["postgres"]
+	sql.Register("fakemysql", &fakeDriver{quote: '`'})
+	sqldb.Dialects["fakemysql"] = sqldb.Dialects["mysql"]
+}
+
+func TestRebind(t *testing.T) {
+	q := `SELECT"a"FROM"t"WHERE"b"=? AND"c"=? ORDER BY"a"`
+	for _, c := range [...]struct{ d, q string }{
+		{"sqlite3", q},
+		{"postgres", `SELECT"a"FROM"t"WHERE"b"=$1 AND"c"=$2 ORDER BY"a"`},
+		{"mysql", "SELECT`a`FROM`t`WHERE`b`=? AND`c`=? ORDER BY`a`"},
+	} {
+		gq := sqldb.Dialects[c.d].Rebind(q)
+		if gq != c.q {
+			t.Errorf("%v: got %q, want %q", c.d, gq, c.q)
+		}
+	}
+}
+
+func TestDialects(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqldb")
+	if err != nil {
+		t.Fatal("failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+	t0 := time.Now().UTC().Truncate(time.Second)
+	ips := []net.IP{net.ParseIP("1.2.3.4"), net.ParseIP("2001:db8::1")}
+	for _, c := range [...]struct {
+		d, ct string
+	}{
+		{"sqlite3", "blob"},
+		{"fakepostgres", "text"},
+		{"fakemysql", "blob"},
+	} {
+		fn := filepath.Join(dir, c.d+".sqlite")
+		cfg := &sqldb.DBConfig{Driver: "sqlite3", Source: fn}
+		db, err := sqldb.NewDB(cfg)
+		if err != nil {
+			t.Fatal("NewDB failed:", err)
+		}
+		err = db.CreateTables()
+		if err != nil {
+			t.Fatal("CreateTables failed:", err)
+		}
+		db.Close()
+		cfg.Driver = c.d
+		db, err = sqldb.NewDB(cfg)
+		if err != nil {
+			t.Fatal(c.d, "NewDB failed:", err)
+		}
+		for i, ip := range ips {
+			err = db.LogClaim(t0.Add(time.Duration(i)*time.Second), ip, "r", faucet.Amount(i+1), []byte{byte(i)})
+			if err != nil {
+				t.Fatal(c.d, "LogClaim failed:", err)
+			}
+		}
+		cli, err := db.ClaimsSince(t0)
+		if err != nil {
+			t.Fatal(c.d, "ClaimsSince failed:", err)
+		}
+		i := 0
+		for cli.Next() {
+			var (
+				ct  time.Time
+				ip  net.IP
+				r   string
+				amt faucet.Amount
+			)
+			err = cli.Get(&ct, &ip, &r, &amt)
+			if err != nil {
+				t.Fatal(c.d, "Get failed:", err)
+			}
+			if i >= len(ips) || !ip.Equal(ips[i]) || amt != faucet.Amount(i+1) || !ct.Equal(t0.Add(time.Duration(i)*time.Second)) {
+				t.Error(c.d, "record", i, "got", ct, ip, r, amt)
+			}
+			i++
+		}
+		err = cli.Close()
+		if err != nil {
+			t.Fatal(c.d, "Close failed:", err)
+		}
+		if i != len(ips) {
+			t.Error(c.d, "got", i, "records, want", len(ips))
+		}
+		err = db.SetTxState([]byte{1}, faucet.TxConfirmed, 3)
+		if err != nil {
+			t.Fatal(c.d, "SetTxState failed:", err)
+		}
+		crs, err := db.ClaimsByTX([]byte{1})
+		if err != nil || len(crs) != 1 || crs[0].TxState != faucet.TxConfirmed || crs[0].Confirmations != 3 {
+			t.Error(c.d, "ClaimsByTX returned", crs, err)
+		}
+		crs, err = db.UnconfirmedClaims(t0)
+		if err != nil || len(crs) != 1 || !bytes.Equal(crs[0].TX, []byte{0}) {
+			t.Error(c.d, "UnconfirmedClaims returned", crs, err)
+		}
+		db.Close()
+		sdb, err := sql.Open("sqlite3", fn)
+		if err != nil {
+			t.Fatal("failed to open database:", err)
+		}
+		var ct string
+		err = sdb.QueryRow(`SELECT typeof("client") FROM "claims" LIMIT 1`).Scan(&ct)
+		sdb.Close()
+		if err != nil {
+			t.Fatal("failed to query column type:", err)
+		}
+		if ct != c.ct {
+			t.Error(c.d, "client column type", ct, "want", c.ct)
+		}
+	}
+	for _, q := range []string{
+		`"txid"=$1 AND"status"=$2 ORDER BY"id"`,
+		`SET"tx_state"=$1,"confirmations"=$2 WHERE"txid"=$3 AND"status"=$4`,
+		`"time">=$1 AND"status"=$2 AND"tx_state"=$3 ORDER BY"id"`,
+	} {
+		if !fakePostgres.prepared(q) {
+			t.Error("postgres query with", q, "was not prepared")
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package sqldb
+
+// MySQL driver is not linked by this package. See cmd/faucetd for build tags that include it.
+
+// rateLimitMySQL creates tables of rate limiting records.
+var rateLimitMySQL = []string{"CREATE TABLE `ip_intervals` (\n" +
+	"  `prefix` VARBINARY(8) NOT NULL PRIMARY KEY,\n" +
+	"  `until` BIGINT NOT NULL\n" +
+	")", "CREATE TABLE `recipient_intervals` (\n" +
+	"  `recipient` VARCHAR(35) CHARACTER SET ascii COLLATE ascii_bin NOT NULL PRIMARY KEY,\n" +
+	"  `until` BIGINT NOT NULL\n" +
+	")", "CREATE TABLE `rate_claims` (\n" +
+	"  `time` BIGINT NOT NULL,\n" +
+	"  `amount` BIGINT NOT NULL\n" +
+	")", "CREATE INDEX `rate_claim_time` ON `rate_claims` (`time`)",
+	"CREATE TABLE `rate_lock` (`id` INTEGER NOT NULL PRIMARY KEY)",
+	"INSERT INTO `rate_lock` (`id`) VALUES (1)"}
+
+var createMySQL = append([]string{"CREATE TABLE `claims` (\n" +
+	"  `id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,\n" +
+	"  `time` DATETIME NOT NULL,\n" +
+	"  `client` VARBINARY(16) NOT NULL,\n" +
+	"  `recipient` VARCHAR(35) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,\n" +
+	"  `amount` BIGINT NOT NULL,\n" +
+	"  `txid` VARBINARY(32) NOT NULL,\n" +
+	"  `status` SMALLINT NOT NULL DEFAULT 0,\n" +
+	"  `idempotency_key` VARCHAR(64) CHARACTER SET ascii COLLATE ascii_bin,\n" +
+	"  `confirmations` INTEGER NOT NULL DEFAULT 0,\n" +
+	"  `tx_state` SMALLINT NOT NULL DEFAULT 0\n" +
+	")", "CREATE INDEX `claim_time` ON `claims` (`time`)", "CREATE INDEX `claim_recipient` ON `claims` (`recipient`)",
+	"CREATE UNIQUE INDEX `claim_key` ON `claims` (`idempotency_key`)", "CREATE INDEX `claim_txid` ON `claims` (`txid`)"}, rateLimitMySQL...)
+
+var migrateMySQL = []Migration{{
+	// Index for claim history queries.
+	Version: 3,
+	SQL:     []string{"CREATE INDEX `claim_recipient` ON `claims` (`recipient`)"},
+}, {
+	// Claim status for batched sending.
+	Version: 4,
+	SQL:     []string{"ALTER TABLE `claims` ADD COLUMN `status` SMALLINT NOT NULL DEFAULT 0"},
+}, {
+	// Rate limiting records shared by faucet instances.
+	Version: 5,
+	SQL:     rateLimitMySQL,
+}, {
+	// Idempotency keys of claims.
+	Version: 6,
+	SQL: []string{
+		"ALTER TABLE `claims` ADD COLUMN `idempotency_key` VARCHAR(64) CHARACTER SET ascii COLLATE ascii_bin",
+		"CREATE UNIQUE INDEX `claim_key` ON `claims` (`idempotency_key`)",
+	},
+}, {
+	// Confirmation tracking of sent transactions.
+	Version: 7,
+	SQL: []string{
+		"ALTER TABLE `claims` ADD COLUMN `confirmations` INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE `claims` ADD COLUMN `tx_state` SMALLINT NOT NULL DEFAULT 0",
+		"CREATE INDEX `claim_txid` ON `claims` (`txid`)",
+	},
+}}
+
+func init() {
+	Schemas["mysql"] = &Schema{
+		Create:      createMySQL,
+		Migrations:  migrateMySQL,
+		TableExists: `SELECT COUNT(*) FROM "information_schema"."tables" WHERE "table_schema"=DATABASE() AND "table_name"=?`,
+	}
+	Dialects["mysql"] = &Dialect{Quote: '`'}
+}
@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package sqldb
+
+// PostgreSQL driver is not linked by this package. See cmd/faucetd for build tags that include it.
+
+// rateLimitPostgres creates tables of rate limiting records.
+var rateLimitPostgres = []string{`CREATE TABLE "ip_intervals" (
+  "prefix" BYTEA NOT NULL PRIMARY KEY,
+  "until" BIGINT NOT NULL
+)`, `CREATE TABLE "recipient_intervals" (
+  "recipient" VARCHAR(35) COLLATE "C" NOT NULL PRIMARY KEY,
+  "until" BIGINT NOT NULL
+)`, `CREATE TABLE "rate_claims" (
+  "time" BIGINT NOT NULL,
+  "amount" BIGINT NOT NULL
+)`, `CREATE INDEX "rate_claim_time" ON "rate_claims" ("time")`,
+	`CREATE TABLE "rate_lock" ("id" INTEGER NOT NULL PRIMARY KEY)`,
+	`INSERT INTO "rate_lock" ("id") VALUES (1)`}
+
+var createPostgres = append([]string{`CREATE TABLE "claims" (
+  "id" BIGSERIAL NOT NULL PRIMARY KEY,
+  "time" TIMESTAMP NOT NULL,
+  "client" INET NOT NULL,
+  "recipient" VARCHAR(35) COLLATE "C" NOT NULL,
+  "amount" BIGINT NOT NULL,
+  "txid" BYTEA NOT NULL,
+  "status" SMALLINT NOT NULL DEFAULT 0,
+  "idempotency_key" VARCHAR(64) COLLATE "C",
+  "confirmations" INTEGER NOT NULL DEFAULT 0,
+  "tx_state" SMALLINT NOT NULL DEFAULT 0
+)`, `CREATE INDEX "claim_time" ON "claims" ("time")`, `CREATE INDEX "claim_recipient" ON "claims" ("recipient")`,
+	`CREATE UNIQUE INDEX "claim_key" ON "claims" ("idempotency_key")`, `CREATE INDEX "claim_txid" ON "claims" ("txid")`}, rateLimitPostgres...)
+
+var migratePostgres = []Migration{{
+	// Index for claim history queries.
+	Version: 3,
+	SQL:     []string{`CREATE INDEX "claim_recipient" ON "claims" ("recipient")`},
+}, {
+	// Claim status for batched sending.
+	Version: 4,
+	SQL:     []string{`ALTER TABLE "claims" ADD COLUMN "status" SMALLINT NOT NULL DEFAULT 0`},
+}, {
+	// Rate limiting records shared by faucet instances.
+	Version: 5,
+	SQL:     rateLimitPostgres,
+}, {
+	// Idempotency keys of claims.
+	Version: 6,
+	SQL: []string{
+		`ALTER TABLE "claims" ADD COLUMN "idempotency_key" VARCHAR(64) COLLATE "C"`,
+		`CREATE UNIQUE INDEX "claim_key" ON "claims" ("idempotency_key")`,
+	},
+}, {
+	// Confirmation tracking of sent transactions.
+	Version: 7,
+	SQL: []string{
+		`ALTER TABLE "claims" ADD COLUMN "confirmations" INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE "claims" ADD COLUMN "tx_state" SMALLINT NOT NULL DEFAULT 0`,
+		`CREATE INDEX "claim_txid" ON "claims" ("txid")`,
+	},
+}}
+
+var postgresDialect = &Dialect{
+	NumberedParams: true,
+	Quote:          '"',
+	ReturningID:    true,
+	TextIP:         true,
+}
+
+func init() {
+	sc := &Schema{
+		Create:      createPostgres,
+		Migrations:  migratePostgres,
+		TableExists: `SELECT COUNT(*) FROM "information_schema"."tables" WHERE "table_schema"=current_schema() AND "table_name"=?`,
+	}
+	Schemas["postgres"] = sc
+	Schemas["pgx"] = sc
+	Dialects["postgres"] = postgresDialect
+	Dialects["pgx"] = postgresDialect
+}
@@ -0,0 +1,266 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package sqldb
+
+import (
+	"bytes"
+	"database/sql"
+	"strings"
+	"sync"
+	"time"
+)
+
+import (
+	"faucet"
+	"faucet/core"
+)
+
+// RateLimitStore implements core.RateLimitStore with records in the database,
+// so that faucet instances that use the same database share rate limits.
+// Checks and updates of interval records are serialized by locking a row of rate_lock table in a transaction.
+// Times are stored as Unix time in nanoseconds.
+type RateLimitStore struct {
+	db *DB
+	m  sync.Mutex
+	rl *core.RateLimits
+}
+
+// NewRateLimitStore creates rate limit store in the database with given rate limits.
+func NewRateLimitStore(db *DB, rl *core.RateLimits) *RateLimitStore {
+	self := &RateLimitStore{db: db}
+	self.SetRateLimits(rl)
+	return self
+}
+
+// limits returns rate limits in effect.
+func (self *RateLimitStore) limits() *core.RateLimits {
+	self.m.Lock()
+	defer self.m.Unlock()
+	return self.rl
+}
+
+// SetRateLimits replaces rate limits. Existing records are kept.
+func (self *RateLimitStore) SetRateLimits(rl *core.RateLimits) {
+	rl1 := *rl
+	self.m.Lock()
+	defer self.m.Unlock()
+	self.rl = &rl1
+}
+
+// rci returns whether recipient interval applies.
+func rci(rl *core.RateLimits, recipient string) bool {
+	return len(recipient) > 0 && rl.RecipientClaimInterval >= time.Second
+}
+
+// exec executes statements in a transaction that holds the lock.
+// Expired interval records are removed before f is called with current time.
+func (self *RateLimitStore) exec(f func(tx *sql.Tx, ct time.Time) error) error {
+	tx, err := self.db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+	d := self.db.d
+	_, err = tx.Exec(d.Rebind(`UPDATE"rate_lock"SET"id"=1`))
+	if err != nil {
+		return err
+	}
+	ct := core.Now()
+	for _, s := range []string{`DELETE FROM"ip_intervals"WHERE"until"<=?`, `DELETE FROM"recipient_intervals"WHERE"until"<=?`} {
+		_, err = tx.Exec(d.Rebind(s), ct.UnixNano())
+		if err != nil {
+			return err
+		}
+	}
+	err = f(tx, ct)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	tx = nil
+	return err
+}
+
+// prefixes returns query parameters with all prefixes of the address.
+func prefixes(a [8]byte) []interface{} {
+	ps := make([]interface{}, len(a))
+	for l := len(a); l > 0; l-- {
+		ps[len(a)-l] = a[:l]
+	}
+	return ps
+}
+
+// prefixCond returns SQL condition that selects interval records of all prefixes of the address.
+func prefixCond(a [8]byte) (string, []interface{}) {
+	return `"prefix"IN(?` + strings.Repeat(`,?`, len(a)-1) + `)`, prefixes(a)
+}
+
+// querier is implemented by sql.DB and sql.Tx.
+type querier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// nextTime returns the latest end of active interval records in the table selected by the condition.
+// Returns zero if there are none.
+func (self *RateLimitStore) nextTime(q querier, table, cond string, ct time.Time, args ...interface{}) (time.Time, error) {
+	var u sql.NullInt64
+	args = append(args, ct.UnixNano())
+	err := q.QueryRow(self.db.d.Rebind(`SELECT MAX("until")FROM"`+table+`"WHERE `+cond+` AND"until">?`), args...).Scan(&u)
+	if err != nil || !u.Valid {
+		return time.Time{}, err
+	}
+	return time.Unix(0, u.Int64), nil
+}
+
+// replace sets interval record of the key that is known to be expired.
+func (self *RateLimitStore) replace(tx *sql.Tx, table, col string, key interface{}, t time.Time) error {
+	_, err := tx.Exec(self.db.d.Rebind(`DELETE FROM"`+table+`"WHERE"`+col+`"=?`), key)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(self.db.d.Rebind(`INSERT INTO"`+table+`"("`+col+`","until")VALUES(?,?)`), key, t.UnixNano())
+	return err
+}
+
+func (self *RateLimitStore) AddClaim(t time.Time, amount faucet.Amount) error {
+	d := self.db.d
+	_, err := self.db.db.Exec(d.Rebind(`INSERT INTO"rate_claims"("time","amount")VALUES(?,?)`), t.UnixNano(), amount)
+	if err != nil {
+		return err
+	}
+	_, err = self.db.db.Exec(d.Rebind(`DELETE FROM"rate_claims"WHERE"time"<?`), core.Now().Add(-self.limits().RatePeriod).UnixNano())
+	return err
+}
+
+func (self *RateLimitStore) CheckAddIntervals(a [8]byte, recipient string) ([]time.Time, bool, error) {
+	rl := self.limits()
+	var ts []time.Time
+	ri := rci(rl, recipient)
+	err := self.exec(func(tx *sql.Tx, ct time.Time) error {
+		c, args := prefixCond(a)
+		t, err := self.nextTime(tx, "ip_intervals", c, ct, args...)
+		if err != nil || !t.IsZero() {
+			return err
+		}
+		if ri {
+			t, err = self.nextTime(tx, "recipient_intervals", `"recipient"=?`, ct, recipient)
+			if err != nil || !t.IsZero() {
+				return err
+			}
+		}
+		l, ds := rl.Subnets(a)
+		for i, d := range ds {
+			it := ct.Add(d)
+			err = self.replace(tx, "ip_intervals", "prefix", a[:l-i], it)
+			if err != nil {
+				return err
+			}
+			ts = append(ts, it)
+		}
+		if ri {
+			it := ct.Add(rl.RecipientClaimInterval)
+			err = self.replace(tx, "recipient_intervals", "recipient", recipient, it)
+			if err != nil {
+				return err
+			}
+			ts = append(ts, it)
+		}
+		return nil
+	})
+	if err != nil || len(ts) == 0 {
+		return nil, false, err
+	}
+	return ts, ri, nil
+}
+
+func (self *RateLimitStore) CheckAddRecipientInterval(recipient string) ([]time.Time, error) {
+	rl := self.limits()
+	if !rci(rl, recipient) {
+		return nil, nil
+	}
+	var ts []time.Time
+	err := self.exec(func(tx *sql.Tx, ct time.Time) error {
+		t, err := self.nextTime(tx, "recipient_intervals", `"recipient"=?`, ct, recipient)
+		if err != nil || !t.IsZero() {
+			return err
+		}
+		it := ct.Add(rl.RecipientClaimInterval)
+		err = self.replace(tx, "recipient_intervals", "recipient", recipient, it)
+		if err != nil {
+			return err
+		}
+		ts = []time.Time{it}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+func (self *RateLimitStore) CheckInterval(a [8]byte) (time.Time, error) {
+	c, args := prefixCond(a)
+	return self.nextTime(self.db.db, "ip_intervals", c, core.Now(), args...)
+}
+
+func (self *RateLimitStore) CheckRecipientInterval(recipient string) (time.Time, error) {
+	return self.nextTime(self.db.db, "recipient_intervals", `"recipient"=?`, core.Now(), recipient)
+}
+
+func (self *RateLimitStore) ClearIntervals(a [8]byte, l int) (int, error) {
+	var n int64
+	err := self.exec(func(tx *sql.Tx, ct time.Time) error {
+		// Prefixes that begin with a[:l] are between a[:l] and a[:l] followed by 0xFF bytes.
+		hi := append(append([]byte(nil), a[:l]...), bytes.Repeat([]byte{0xFF}, len(a)-l)...)
+		s := `DELETE FROM"ip_intervals"WHERE"prefix"BETWEEN ? AND ?`
+		args := []interface{}{a[:l], hi}
+		if l > 1 {
+			s += ` OR"prefix"IN(?` + strings.Repeat(`,?`, l-2) + `)`
+			args = append(args, prefixes(a)[len(a)-l+1:]...)
+		}
+		r, err := tx.Exec(self.db.d.Rebind(s), args...)
+		if err != nil {
+			return err
+		}
+		n, err = r.RowsAffected()
+		return err
+	})
+	return int(n), err
+}
+
+func (self *RateLimitStore) DelIntervals(a [8]byte, recipient string, ts []time.Time) error {
+	d := self.db.d
+	rl := self.limits()
+	if len(recipient) > 0 && len(ts) > 0 {
+		_, err := self.db.db.Exec(d.Rebind(`DELETE FROM"recipient_intervals"WHERE"recipient"=? AND"until"<=?`), recipient, ts[len(ts)-1].UnixNano())
+		if err != nil {
+			return err
+		}
+		ts = ts[:len(ts)-1]
+	}
+	l, _ := rl.Subnets(a)
+	for i, t := range ts {
+		_, err := self.db.db.Exec(d.Rebind(`DELETE FROM"ip_intervals"WHERE"prefix"=? AND"until"<=?`), a[:l-i], t.UnixNano())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (self *RateLimitStore) IntervalCount() (int, error) {
+	var n int
+	ct := core.Now().UnixNano()
+	err := self.db.db.QueryRow(self.db.d.Rebind(`SELECT(SELECT COUNT(*)FROM"ip_intervals"WHERE"until">?)+(SELECT COUNT(*)FROM"recipient_intervals"WHERE"until">?)`), ct, ct).Scan(&n)
+	return n, err
+}
+
+func (self *RateLimitStore) PeriodTotal() (faucet.Amount, error) {
+	var ta faucet.Amount
+	err := self.db.db.QueryRow(self.db.d.Rebind(`SELECT COALESCE(SUM("amount"),0)FROM"rate_claims"WHERE"time">=?`), core.Now().Add(-self.limits().RatePeriod).UnixNano()).Scan(&ta)
+	return ta, err
+}
@@ -0,0 +1,242 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package sqldb_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	"faucet"
+	"faucet/core"
+	"faucet/sqldb"
+)
+
+// createDB creates tables in a new database file and returns its configuration for the driver.
+func createDB(t *testing.T, fn, driver string) *sqldb.DBConfig {
+	t.Helper()
+	cfg := &sqldb.DBConfig{Driver: "sqlite3", Source: fn}
+	db, err := sqldb.NewDB(cfg)
+	if err != nil {
+		t.Fatal("NewDB failed:", err)
+	}
+	err = db.CreateTables()
+	db.Close()
+	if err != nil {
+		t.Fatal("CreateTables failed:", err)
+	}
+	cfg.Driver = driver
+	return cfg
+}
+
+func TestRateLimitStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqldb")
+	if err != nil {
+		t.Fatal("failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+	ct := time.Now()
+	core.Now = func() time.Time { return ct }
+	defer func() { core.Now = time.Now }()
+	rl := &core.RateLimits{
+		IPClaimInterval:        256 * time.Minute,
+		RatePeriod:             time.Hour,
+		RecipientClaimInterval: time.Hour,
+	}
+	a1 := [8]byte{0, 0, 0, 0, 192, 0, 2, 1}
+	a2 := [8]byte{0, 0, 0, 0, 198, 51, 100, 1}
+	for _, d := range []string{"sqlite3", "fakepostgres", "fakemysql"} {
+		ct = time.Now()
+		cfg := createDB(t, filepath.Join(dir, d+".sqlite"), d)
+		var ss [2]*sqldb.RateLimitStore
+		for i := range ss {
+			db, err := sqldb.NewDB(cfg)
+			if err != nil {
+				t.Fatal(d, "NewDB failed:", err)
+			}
+			defer db.Close()
+			ss[i] = sqldb.NewRateLimitStore(db, rl)
+		}
+		_, ds := rl.Subnets(a1)
+		t0 := ct
+		ts, rr, err := ss[0].CheckAddIntervals(a1, "r1")
+		if err != nil || !rr {
+			t.Fatal(d, "CheckAddIntervals returned", rr, err)
+		}
+		if len(ts) != len(ds)+1 {
+			t.Fatal(d, "CheckAddIntervals added", len(ts), "records, want", len(ds)+1)
+		}
+		if n, err := ss[1].IntervalCount(); err != nil || n != len(ts) {
+			t.Error(d, "IntervalCount returned", n, err)
+		}
+		for _, c := range [...]struct {
+			a [8]byte
+			r string
+		}{{a1, "r2"}, {a2, "r1"}} {
+			ts2, _, err := ss[1].CheckAddIntervals(c.a, c.r)
+			if err != nil || ts2 != nil {
+				t.Error(d, "CheckAddIntervals", c.a, c.r, "returned", ts2, err)
+			}
+		}
+		if it, err := ss[1].CheckInterval(a1); err != nil || !it.Equal(time.Unix(0, t0.Add(256*time.Minute).UnixNano())) {
+			t.Error(d, "CheckInterval returned", it, err)
+		}
+		if it, err := ss[1].CheckInterval(a2); err != nil || !it.IsZero() {
+			t.Error(d, "CheckInterval of unrelated address returned", it, err)
+		}
+		if rt, err := ss[1].CheckRecipientInterval("r1"); err != nil || !rt.Equal(time.Unix(0, t0.Add(time.Hour).UnixNano())) {
+			t.Error(d, "CheckRecipientInterval returned", rt, err)
+		}
+		err = ss[1].DelIntervals(a1, "r1", ts)
+		if err != nil {
+			t.Fatal(d, "DelIntervals failed:", err)
+		}
+		if n, err := ss[0].IntervalCount(); err != nil || n != 0 {
+			t.Error(d, "IntervalCount after DelIntervals returned", n, err)
+		}
+
+		ct = ct.Add(time.Second)
+		ts, _, err = ss[1].CheckAddIntervals(a1, "r1")
+		if err != nil || len(ts) == 0 {
+			t.Fatal(d, "CheckAddIntervals after DelIntervals returned", ts, err)
+		}
+		n, err := ss[0].ClearIntervals([8]byte{0, 0, 0, 0, 192, 0, 2}, 7)
+		if err != nil || n != len(ds) {
+			t.Error(d, "ClearIntervals returned", n, err, "want", len(ds))
+		}
+		if it, err := ss[0].CheckInterval(a1); err != nil || !it.IsZero() {
+			t.Error(d, "CheckInterval after ClearIntervals returned", it, err)
+		}
+		ts, err = ss[0].CheckAddRecipientInterval("r1")
+		if err != nil || ts != nil {
+			t.Error(d, "CheckAddRecipientInterval returned", ts, err)
+		}
+		ct = ct.Add(time.Hour)
+		ts, err = ss[0].CheckAddRecipientInterval("r1")
+		if err != nil || len(ts) != 1 {
+			t.Error(d, "CheckAddRecipientInterval after interval returned", ts, err)
+		}
+
+		for i, s := range ss {
+			err = s.AddClaim(ct.Add(time.Duration(i)*time.Minute), faucet.Amount(i+1))
+			if err != nil {
+				t.Fatal(d, "AddClaim failed:", err)
+			}
+		}
+		if ta, err := ss[0].PeriodTotal(); err != nil || ta != 3 {
+			t.Error(d, "PeriodTotal returned", ta, err)
+		}
+		ct = ct.Add(time.Hour + time.Second)
+		if ta, err := ss[1].PeriodTotal(); err != nil || ta != 2 {
+			t.Error(d, "PeriodTotal after period returned", ta, err)
+		}
+	}
+}
+
+// bankMock is a Bank with unlimited balance.
+type bankMock struct {
+	m sync.Mutex
+	n int
+}
+
+func (self *bankMock) Balance(ctx context.Context) (faucet.Amount, error) {
+	return 1000 * faucet.Coin, nil
+}
+
+func (self *bankMock) Send(ctx context.Context, recipient string, amount faucet.Amount) (string, error) {
+	return self.SendMany(ctx, map[string]faucet.Amount{recipient: amount})
+}
+
+func (self *bankMock) SendMany(ctx context.Context, amounts map[string]faucet.Amount) (string, error) {
+	self.m.Lock()
+	defer self.m.Unlock()
+	self.n++
+	return fmt.Sprintf("%016x", self.n), nil
+}
+
+func TestRateLimitStoreFaucets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqldb")
+	if err != nil {
+		t.Fatal("failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+	dcfg := createDB(t, filepath.Join(dir, "shared.sqlite"), "sqlite3")
+	fcfg := &core.FaucetConfig{
+		Amount:                 faucet.Coin,
+		MinAmount:              faucet.Coin,
+		IPClaimInterval:        time.Hour,
+		RecipientClaimInterval: time.Hour,
+	}
+	fcfg.RateLimit.Period = time.Hour
+	rl, err := core.NewRateLimits(fcfg)
+	if err != nil {
+		t.Fatal("NewRateLimits failed:", err)
+	}
+	var fs [2]*core.Faucet
+	for i := range fs {
+		db, err := sqldb.NewDB(dcfg)
+		if err != nil {
+			t.Fatal("NewDB failed:", err)
+		}
+		defer db.Close()
+		fs[i], err = core.NewFaucet(fcfg, nil, new(bankMock), nil)
+		if err != nil {
+			t.Fatal("NewFaucet failed:", err)
+		}
+		defer fs[i].Close()
+		fs[i].SetRateLimitStore(sqldb.NewRateLimitStore(db, rl))
+	}
+	ctx := context.Background()
+	_, _, _, err = fs[0].Claim(ctx, &faucet.ClaimRequest{Client: "192.0.2.1", Recipient: "r0"})
+	if err != nil {
+		t.Fatal("Claim failed:", err)
+	}
+	for _, req := range []*faucet.ClaimRequest{
+		{Client: "192.0.2.1", Recipient: "r1"},
+		{Client: "198.51.100.1", Recipient: "r0"},
+	} {
+		_, _, _, err = fs[1].Claim(ctx, req)
+		if _, ok := err.(faucet.MustWait); !ok {
+			t.Error("claim", req.Client, req.Recipient, "from other faucet returned", err)
+		}
+	}
+	wt, err := fs[1].WaitTime(ctx, "192.0.2.1")
+	if err != nil || wt.IsZero() {
+		t.Error("WaitTime returned", wt, err)
+	}
+
+	// Concurrent claims from the same client through both faucets.
+	var (
+		wg sync.WaitGroup
+		m  sync.Mutex
+		ok int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _, _, err := fs[i%2].Claim(ctx, &faucet.ClaimRequest{Client: "203.0.113.1", Recipient: fmt.Sprint("r", i+10)})
+			if err == nil {
+				m.Lock()
+				ok++
+				m.Unlock()
+			} else if _, mw := err.(faucet.MustWait); !mw {
+				t.Error("concurrent claim", i, "failed:", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if ok != 1 {
+		t.Error(ok, "concurrent claims succeeded, want 1")
+	}
+	if ta := fs[1].PeriodTotal(); ta != 2*faucet.Coin {
+		t.Error("period total", ta, "want", 2*faucet.Coin)
+	}
+}
@@ -0,0 +1,214 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package sqldb
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// SchemaVersion is the version of database schema that this package works with.
+const SchemaVersion = 7
+
+// unversionedSchema is the version of databases that were created before schema_version table was introduced,
+// unless Schema.Unversioned query says otherwise.
+const unversionedSchema = 2
+
+// Migration upgrades database schema from version Version-1 to Version.
+type Migration struct {
+	Version int
+	SQL     []string
+}
+
+// Schema contains driver-specific SQL code that manages database schema.
+type Schema struct {
+	// Create creates tables of current schema version, except for schema_version table.
+	Create []string
+
+	// Migrations upgrade schema of existing databases. They are ordered by version.
+	Migrations []Migration
+
+	// TableExists is a query with table name parameter that returns number of tables with that name.
+	TableExists string
+
+	// Unversioned is a query that returns schema version of existing tables when schema_version table is absent.
+	// If it is empty, such tables are assumed to have the version at which schema versioning was introduced.
+	Unversioned string
+}
+
+// Driver-specific database schemas.
+var Schemas = make(map[string]*Schema)
+
+type ErrSchemaVersion struct{ Version int }
+
+func (self ErrSchemaVersion) Error() string {
+	return fmt.Sprintf("unsupported database schema version %v, expected %v", self.Version, SchemaVersion)
+}
+
+var versionSQL = []string{
+	`CREATE TABLE "schema_version" ("version" INTEGER NOT NULL)`,
+	`INSERT INTO "schema_version" ("version") VALUES (0)`,
+}
+
+const setVersionSQL = `UPDATE "schema_version" SET "version"=?`
+
+// CreateStatements returns SQL statements that create tables of current schema version for given driver.
+func CreateStatements(driver string) []string {
+	sc := Schemas[driver]
+	if sc == nil {
+		return nil
+	}
+	d := Dialects[driver]
+	if d == nil {
+		d = defDialect
+	}
+	ss := append([]string(nil), sc.Create...)
+	ss = append(ss, d.Rebind(versionSQL[0]))
+	ss = append(ss, d.Rebind(`INSERT INTO "schema_version" ("version") VALUES (`+strconv.Itoa(SchemaVersion)+`)`))
+	return ss
+}
+
+func (self *DB) schema() (*Schema, error) {
+	sc := Schemas[self.dn]
+	if sc == nil {
+		return nil, ErrUnsupportedDriver{Driver: self.dn}
+	}
+	return sc, nil
+}
+
+func (self *DB) tableExists(sc *Schema, name string) (bool, error) {
+	var n int
+	err := self.db.QueryRow(self.d.Rebind(sc.TableExists), name).Scan(&n)
+	return n > 0, err
+}
+
+// CreateTables creates tables of current schema version in empty database.
+func (self *DB) CreateTables() error {
+	v, err := self.Version()
+	if err != nil {
+		return err
+	}
+	if v != 0 {
+		return fmt.Errorf("database already has tables of schema version %v", v)
+	}
+	tx, err := self.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+	for _, s := range CreateStatements(self.dn) {
+		_, err = tx.Exec(s)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	tx = nil
+	return err
+}
+
+// Migrate upgrades database schema to current version. Returns schema version before the upgrade.
+func (self *DB) Migrate() (int, error) {
+	sc, err := self.schema()
+	if err != nil {
+		return 0, err
+	}
+	v, err := self.Version()
+	if err != nil {
+		return 0, err
+	}
+	if v == 0 {
+		return 0, fmt.Errorf("database has no tables to migrate")
+	}
+	if v > SchemaVersion {
+		return v, ErrSchemaVersion{Version: v}
+	}
+	vt, err := self.tableExists(sc, "schema_version")
+	if err != nil {
+		return v, err
+	}
+	cv := v
+	for _, m := range sc.Migrations {
+		if m.Version <= cv {
+			continue
+		}
+		err = self.migrate(m, vt)
+		if err != nil {
+			return v, fmt.Errorf("migration to schema version %v failed: %w", m.Version, err)
+		}
+		cv = m.Version
+		vt = true
+	}
+	if !vt {
+		err = self.migrate(Migration{Version: cv}, vt)
+		if err != nil {
+			return v, err
+		}
+	}
+	return v, nil
+}
+
+// migrate applies a migration and records its version. Creates schema_version table if vt is false.
+func (self *DB) migrate(m Migration, vt bool) error {
+	tx, err := self.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+	if !vt {
+		for _, s := range versionSQL {
+			_, err = tx.Exec(self.d.Rebind(s))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, s := range m.SQL {
+		_, err = tx.Exec(s)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(self.d.Rebind(setVersionSQL), m.Version)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	tx = nil
+	return err
+}
+
+// Version returns schema version of the database. Returns 0 if tables do not exist.
+func (self *DB) Version() (int, error) {
+	sc, err := self.schema()
+	if err != nil {
+		return 0, err
+	}
+	ok, err := self.tableExists(sc, "schema_version")
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		var v int
+		err = self.db.QueryRow(self.d.Rebind(`SELECT MAX("version") FROM "schema_version"`)).Scan(&v)
+		return v, err
+	}
+	ok, err = self.tableExists(sc, "claims")
+	if err != nil || !ok {
+		return 0, err
+	}
+	if len(sc.Unversioned) == 0 {
+		return unversionedSchema, nil
+	}
+	var v int
+	err = self.db.QueryRow(self.d.Rebind(sc.Unversioned)).Scan(&v)
+	return v, err
+}
@@ -4,16 +4,147 @@ package sqldb
 
 import "github.com/mattn/go-sqlite3"
 
-var createSQLite = []string{`CREATE TABLE "claims" (
+// rateLimitSQLite creates tables of rate limiting records.
+var rateLimitSQLite = []string{`CREATE TABLE "ip_intervals" (
+  "prefix" BLOB(8) NOT NULL PRIMARY KEY,
+  "until" INTEGER NOT NULL
+) WITHOUT ROWID`, `CREATE TABLE "recipient_intervals" (
+  "recipient" VARCHAR(35) COLLATE BINARY NOT NULL PRIMARY KEY,
+  "until" INTEGER NOT NULL
+) WITHOUT ROWID`, `CREATE TABLE "rate_claims" (
+  "time" INTEGER NOT NULL,
+  "amount" INTEGER NOT NULL
+)`, `CREATE INDEX "rate_claim_time" ON "rate_claims" ("time")`,
+	`CREATE TABLE "rate_lock" ("id" INTEGER NOT NULL PRIMARY KEY)`,
+	`INSERT INTO "rate_lock" ("id") VALUES (1)`}
+
+var createSQLite = append([]string{`CREATE TABLE "claims" (
   "id" INTEGER NOT NULL PRIMARY KEY,
   "time" DATETIME NOT NULL,
   "client" BLOB(16) NOT NULL,
   "recipient" VARCHAR(35) COLLATE BINARY NOT NULL,
-  "amount" REAL NOT NULL,
+  "amount" INTEGER NOT NULL,
+  "txid" BLOB(32) NOT NULL,
+  "status" SMALLINT NOT NULL DEFAULT 0,
+  "idempotency_key" VARCHAR(64) COLLATE BINARY,
+  "confirmations" INTEGER NOT NULL DEFAULT 0,
+  "tx_state" SMALLINT NOT NULL DEFAULT 0
+)`, `CREATE INDEX "claim_time" ON "claims" ("time")`, `CREATE INDEX "claim_recipient" ON "claims" ("recipient")`,
+	`CREATE UNIQUE INDEX "claim_key" ON "claims" ("idempotency_key")`, `CREATE INDEX "claim_txid" ON "claims" ("txid")`}, rateLimitSQLite...)
+
+var migrateSQLite = []Migration{{
+	// Amounts in koinu instead of coins in floating point numbers.
+	Version: 2,
+	SQL: []string{
+		`ALTER TABLE "claims" RENAME TO "claims_real"`,
+		`DROP INDEX "claim_time"`,
+		`CREATE TABLE "claims" (
+  "id" INTEGER NOT NULL PRIMARY KEY,
+  "time" DATETIME NOT NULL,
+  "client" BLOB(16) NOT NULL,
+  "recipient" VARCHAR(35) COLLATE BINARY NOT NULL,
+  "amount" INTEGER NOT NULL,
   "txid" BLOB(32) NOT NULL
-)`, `CREATE INDEX "claim_time" ON "claims" ("time")`}
+)`,
+		`CREATE INDEX "claim_time" ON "claims" ("time")`,
+		`INSERT INTO "claims"("id","time","client","recipient","amount","txid")
+SELECT "id","time","client","recipient",CAST(ROUND("amount"*100000000) AS INTEGER),"txid" FROM "claims_real"`,
+		`DROP TABLE "claims_real"`,
+	},
+}, {
+	// Index for claim history queries.
+	Version: 3,
+	SQL:     []string{`CREATE INDEX "claim_recipient" ON "claims" ("recipient")`},
+}, {
+	// Claim status for batched sending.
+	// Table is rebuilt so that its definition is the same as in new databases.
+	Version: 4,
+	SQL: []string{
+		`ALTER TABLE "claims" RENAME TO "claims_v3"`,
+		`DROP INDEX "claim_time"`,
+		`DROP INDEX "claim_recipient"`,
+		`CREATE TABLE "claims" (
+  "id" INTEGER NOT NULL PRIMARY KEY,
+  "time" DATETIME NOT NULL,
+  "client" BLOB(16) NOT NULL,
+  "recipient" VARCHAR(35) COLLATE BINARY NOT NULL,
+  "amount" INTEGER NOT NULL,
+  "txid" BLOB(32) NOT NULL,
+  "status" SMALLINT NOT NULL DEFAULT 0
+)`,
+		`CREATE INDEX "claim_time" ON "claims" ("time")`,
+		`CREATE INDEX "claim_recipient" ON "claims" ("recipient")`,
+		`INSERT INTO "claims"("id","time","client","recipient","amount","txid")
+SELECT "id","time","client","recipient","amount","txid" FROM "claims_v3"`,
+		`DROP TABLE "claims_v3"`,
+	},
+}, {
+	// Rate limiting records shared by faucet instances.
+	Version: 5,
+	SQL:     rateLimitSQLite,
+}, {
+	// Idempotency keys of claims.
+	// Table is rebuilt so that its definition is the same as in new databases.
+	Version: 6,
+	SQL: []string{
+		`ALTER TABLE "claims" RENAME TO "claims_v5"`,
+		`DROP INDEX "claim_time"`,
+		`DROP INDEX "claim_recipient"`,
+		`CREATE TABLE "claims" (
+  "id" INTEGER NOT NULL PRIMARY KEY,
+  "time" DATETIME NOT NULL,
+  "client" BLOB(16) NOT NULL,
+  "recipient" VARCHAR(35) COLLATE BINARY NOT NULL,
+  "amount" INTEGER NOT NULL,
+  "txid" BLOB(32) NOT NULL,
+  "status" SMALLINT NOT NULL DEFAULT 0,
+  "idempotency_key" VARCHAR(64) COLLATE BINARY
+)`,
+		`CREATE INDEX "claim_time" ON "claims" ("time")`,
+		`CREATE INDEX "claim_recipient" ON "claims" ("recipient")`,
+		`CREATE UNIQUE INDEX "claim_key" ON "claims" ("idempotency_key")`,
+		`INSERT INTO "claims"("id","time","client","recipient","amount","txid","status")
+SELECT "id","time","client","recipient","amount","txid","status" FROM "claims_v5"`,
+		`DROP TABLE "claims_v5"`,
+	},
+}, {
+	// Confirmation tracking of sent transactions.
+	// Table is rebuilt so that its definition is the same as in new databases.
+	Version: 7,
+	SQL: []string{
+		`ALTER TABLE "claims" RENAME TO "claims_v6"`,
+		`DROP INDEX "claim_time"`,
+		`DROP INDEX "claim_recipient"`,
+		`DROP INDEX "claim_key"`,
+		`CREATE TABLE "claims" (
+  "id" INTEGER NOT NULL PRIMARY KEY,
+  "time" DATETIME NOT NULL,
+  "client" BLOB(16) NOT NULL,
+  "recipient" VARCHAR(35) COLLATE BINARY NOT NULL,
+  "amount" INTEGER NOT NULL,
+  "txid" BLOB(32) NOT NULL,
+  "status" SMALLINT NOT NULL DEFAULT 0,
+  "idempotency_key" VARCHAR(64) COLLATE BINARY,
+  "confirmations" INTEGER NOT NULL DEFAULT 0,
+  "tx_state" SMALLINT NOT NULL DEFAULT 0
+)`,
+		`CREATE INDEX "claim_time" ON "claims" ("time")`,
+		`CREATE INDEX "claim_recipient" ON "claims" ("recipient")`,
+		`CREATE UNIQUE INDEX "claim_key" ON "claims" ("idempotency_key")`,
+		`CREATE INDEX "claim_txid" ON "claims" ("txid")`,
+		`INSERT INTO "claims"("id","time","client","recipient","amount","txid","status","idempotency_key")
+SELECT "id","time","client","recipient","amount","txid","status","idempotency_key" FROM "claims_v6"`,
+		`DROP TABLE "claims_v6"`,
+	},
+}}
 
 func init() {
 	sqlite3.SQLiteTimestampFormats = []string{"2006-01-02 15:04:05"}
-	CreateSQL["sqlite3"] = createSQLite
+	Schemas["sqlite3"] = &Schema{
+		Create:      createSQLite,
+		Migrations:  migrateSQLite,
+		TableExists: `SELECT COUNT(*) FROM "sqlite_master" WHERE "type"='table' AND "name"=?`,
+		Unversioned: `SELECT CASE WHEN EXISTS(SELECT 1 FROM pragma_table_info('claims') WHERE "name"='amount' AND "type"='REAL') THEN 1 ELSE 2 END`,
+	}
+	Dialects["sqlite3"] = defDialect
 }
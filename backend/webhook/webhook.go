@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package webhook sends alerts to HTTP webhooks.
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"text/template"
+	"time"
+)
+
+import (
+	"faucet/alert"
+)
+
+// DefTemplate is the default payload template. It is accepted by Slack incoming webhooks and Matrix hookshot webhooks.
+const DefTemplate = `{"text":{{json .Text}}}`
+
+// Defaults of retry configuration.
+const (
+	DefAttempts = 4
+	DefBackoff  = time.Second
+)
+
+// Timeout is the timeout of one webhook request.
+var Timeout = 10 * time.Second
+
+type WebhookConfig struct {
+	URL string
+
+	// Headers are added to requests, for example Authorization.
+	Headers map[string]string
+
+	// Template is text/template of JSON payload executed with alert.Alert.
+	Template string
+
+	// Attempts is the maximum number of requests per alert. Backoff is the delay before the second request;
+	// it is doubled before each next one.
+	Attempts int
+	Backoff  time.Duration
+}
+
+// HTTPStatusError is returned when the webhook replies with unsuccessful HTTP status.
+type HTTPStatusError struct{ StatusCode int }
+
+func (self HTTPStatusError) Error() string {
+	return fmt.Sprintf("webhook HTTP status %v", self.StatusCode)
+}
+
+// temporary returns whether a request that failed with the error may succeed later.
+func temporary(err error) bool {
+	he, ok := err.(HTTPStatusError)
+	return !ok || he.StatusCode >= 500 || he.StatusCode == http.StatusTooManyRequests
+}
+
+// Webhook implements alert.Sender interface by posting JSON payload to a URL.
+type Webhook struct {
+	c   http.Client
+	cfg WebhookConfig
+	t   *template.Template
+}
+
+// post sends the payload once.
+func (self *Webhook) post(body []byte) error {
+	req, err := http.NewRequest("POST", self.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range self.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	res, err := self.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return HTTPStatusError{StatusCode: res.StatusCode}
+	}
+	return nil
+}
+
+// Send posts the alert. Requests that fail with network errors, server errors or too many requests status are retried.
+func (self *Webhook) Send(a *alert.Alert) error {
+	var b bytes.Buffer
+	err := self.t.Execute(&b, a)
+	if err != nil {
+		return err
+	}
+	d := self.cfg.Backoff
+	for i := 1; ; i++ {
+		err = self.post(b.Bytes())
+		if err == nil || i >= self.cfg.Attempts || !temporary(err) {
+			return err
+		}
+		time.Sleep(d)
+		d *= 2
+	}
+}
+
+// NewWebhook creates webhook client and parses its template.
+func NewWebhook(cfg *WebhookConfig) (*Webhook, error) {
+	if len(cfg.URL) == 0 {
+		return nil, fmt.Errorf("webhook URL is not configured")
+	}
+	self := &Webhook{
+		c:   http.Client{Timeout: Timeout},
+		cfg: *cfg,
+	}
+	if len(self.cfg.Template) == 0 {
+		self.cfg.Template = DefTemplate
+	}
+	if self.cfg.Attempts <= 0 {
+		self.cfg.Attempts = DefAttempts
+	}
+	if self.cfg.Backoff <= 0 {
+		self.cfg.Backoff = DefBackoff
+	}
+	t, err := template.New("webhook").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(self.cfg.Template)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook template: %w", err)
+	}
+	self.t = t
+	return self, nil
+}
@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package webhook_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	"faucet"
+	"faucet/alert"
+	"faucet/webhook"
+)
+
+// hookMock is a webhook server that replies with queued statuses, then with 200.
+type hookMock struct {
+	m      sync.Mutex
+	bodies []string
+	hdr    http.Header
+	sts    []int
+}
+
+func (self *hookMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, _ := ioutil.ReadAll(r.Body)
+	self.m.Lock()
+	defer self.m.Unlock()
+	self.bodies = append(self.bodies, string(b))
+	self.hdr = r.Header
+	if len(self.sts) > 0 {
+		w.WriteHeader(self.sts[0])
+		self.sts = self.sts[1:]
+	}
+}
+
+// take returns received request bodies and forgets them.
+func (self *hookMock) take() []string {
+	self.m.Lock()
+	defer self.m.Unlock()
+	bs := self.bodies
+	self.bodies = nil
+	return bs
+}
+
+func TestWebhook(t *testing.T) {
+	hm := new(hookMock)
+	hs := httptest.NewServer(hm)
+	defer hs.Close()
+	w, err := webhook.NewWebhook(&webhook.WebhookConfig{
+		URL:      hs.URL,
+		Headers:  map[string]string{"Authorization": "Bearer t"},
+		Attempts: 3,
+		Backoff:  time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal("NewWebhook failed:", err)
+	}
+	a := &alert.Alert{Kind: alert.KindBalance, Amount: 5 * faucet.Coin}
+	err = w.Send(a)
+	if err != nil {
+		t.Fatal("Send failed:", err)
+	}
+	bs := hm.take()
+	if len(bs) != 1 {
+		t.Fatal("sent", len(bs), "requests, want 1")
+	}
+	var p struct{ Text string }
+	err = json.Unmarshal([]byte(bs[0]), &p)
+	if err != nil || p.Text != a.Text() {
+		t.Errorf("sent payload %s, want text %q: %v", bs[0], a.Text(), err)
+	}
+	if h := hm.hdr.Get("Authorization"); h != "Bearer t" {
+		t.Errorf("Authorization header %q", h)
+	}
+	if ct := hm.hdr.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type header %q", ct)
+	}
+
+	// Server errors are retried.
+	hm.sts = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+	err = w.Send(a)
+	if err != nil || len(hm.take()) != 3 {
+		t.Error("Send with retries returned", err)
+	}
+	hm.sts = []int{500, 500, 500}
+	err = w.Send(a)
+	if err != (webhook.HTTPStatusError{StatusCode: 500}) || len(hm.take()) != 3 {
+		t.Error("Send after all attempts failed returned", err)
+	}
+	// Client errors are not.
+	hm.sts = []int{http.StatusBadRequest}
+	err = w.Send(a)
+	if err != (webhook.HTTPStatusError{StatusCode: http.StatusBadRequest}) || len(hm.take()) != 1 {
+		t.Error("Send rejected by server returned", err)
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	hm := new(hookMock)
+	hs := httptest.NewServer(hm)
+	defer hs.Close()
+	w, err := webhook.NewWebhook(&webhook.WebhookConfig{
+		URL:      hs.URL,
+		Template: `{"msgtype":"m.text","body":{{json .Text}},"kind":{{json .Kind}},"tx":{{json .TX}}}`,
+	})
+	if err != nil {
+		t.Fatal("NewWebhook failed:", err)
+	}
+	a := &alert.Alert{Kind: alert.KindTx, TX: "\"tx\"", State: faucet.TxConflicted, Age: time.Hour}
+	err = w.Send(a)
+	if err != nil {
+		t.Fatal("Send failed:", err)
+	}
+	var p struct{ MsgType, Body, Kind, TX string }
+	bs := hm.take()
+	err = json.Unmarshal([]byte(bs[0]), &p)
+	if err != nil || p.MsgType != "m.text" || p.Body != a.Text() || p.Kind != "tx" || p.TX != a.TX {
+		t.Errorf("sent payload %s: %v", bs[0], err)
+	}
+	_, err = webhook.NewWebhook(&webhook.WebhookConfig{URL: hs.URL, Template: "{{"})
+	if err == nil {
+		t.Error("NewWebhook accepted invalid template")
+	}
+}